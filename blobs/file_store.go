@@ -0,0 +1,65 @@
+package blobs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps blobs as files in a directory on the local filesystem
+type FileStore struct {
+	Root string
+}
+
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{Root: root}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	// Keys are generated by us, but make sure they can never point outside of the root
+	return filepath.Join(s.Root, filepath.Base(key))
+}
+
+func (s *FileStore) Put(key string, r io.Reader) error {
+	// Write to a temporary file first so a failed upload never leaves a partial blob behind
+	tmp, err := ioutil.TempFile(s.Root, ".upload-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,118 @@
+package blobs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Utilities */
+
+// fakeS3 is a local stand-in for an S3-compatible object store
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch req.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(req.Body)
+		hash := sha256.Sum256(body)
+		if req.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[req.URL.Path] = body
+	case "GET":
+		body, ok := f.objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case "DELETE":
+		if _, ok := f.objects[req.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("attachment contents")
+	if err := store.Put(key, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	r, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	actual, _ := ioutil.ReadAll(r)
+	r.Close()
+
+	if !bytes.Equal(actual, content) {
+		t.Errorf("Blob contents do not match. Expected: %q. Actual: %q", content, actual)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	if _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("Wrong error after delete. Expected: %v. Actual: %v", ErrNotFound, err)
+	}
+
+	if err := store.Delete(key); err != ErrNotFound {
+		t.Errorf("Wrong error deleting twice. Expected: %v. Actual: %v", ErrNotFound, err)
+	}
+}
+
+/* Tests */
+
+func Test_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func Test_S3Store(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	store := NewS3Store(server.URL, "attachments", "us-east-1", "access", "secret")
+
+	testStore(t, store)
+}
@@ -0,0 +1,264 @@
+// Package client is a Go client for the convos API.
+//
+// Every method unwraps the JSON envelope sent by the server: a successful call returns the `response` of the
+// envelope, and a failed one returns an `*Error` holding the error code sent by the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// AnyETag can be given instead of an ETag to change a convo whatever its current version is
+	AnyETag = "*"
+
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+)
+
+type Client struct {
+	// URL of a version of the API, e.g. "http://localhost:8080/v1"
+	BaseURL string
+
+	// Sent as the `X-USER-API-KEY` header
+	UserKey string
+
+	HTTPClient *http.Client
+
+	// How many times a request is retried after a network error or a `502`, `503` or `504` response.
+	// Only requests which are safe to repeat are retried: `GET`, `DELETE`, and creating convos, which is made safe
+	// with an `Idempotency-Key`.
+	MaxRetries int
+
+	// How long to wait before the first retry. The wait doubles after each retry.
+	RetryBackoff time.Duration
+}
+
+func New(baseURL, userKey string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		UserKey:      userKey,
+		HTTPClient:   http.DefaultClient,
+		MaxRetries:   defaultMaxRetries,
+		RetryBackoff: defaultRetryBackoff,
+	}
+}
+
+// ListConvos returns the threads of the user. Only the archived threads are returned if `archived` is set.
+func (c *Client) ListConvos(ctx context.Context, archived bool) ([]*Convo, error) {
+	path := "/convos/"
+	if archived {
+		path += "?archived=true"
+	}
+
+	var convos []*Convo
+	_, err := c.do(ctx, &request{method: "GET", path: path}, &convos)
+	return convos, err
+}
+
+// GetConvo returns a convo, with its `ETag` set so that it can be updated or deleted
+func (c *Client) GetConvo(ctx context.Context, id int) (*Convo, error) {
+	convo := &Convo{}
+	header, err := c.do(ctx, &request{method: "GET", path: convoPath(id)}, convo)
+	if err != nil {
+		return nil, err
+	}
+
+	convo.ETag = header.Get("ETag")
+	return convo, nil
+}
+
+// CreateConvo starts a new thread. Only the recipient, subject and body of `convo` are sent.
+func (c *Client) CreateConvo(ctx context.Context, convo *Convo) (*Convo, error) {
+	return c.create(ctx, "/convos/", convo)
+}
+
+// Reply adds a reply to the thread of convo `id`. Only the recipient and body of `convo` are sent; the subject is
+// always the subject of the thread, and the recipient, which may be left out, always the other user of the convo.
+func (c *Client) Reply(ctx context.Context, id int, convo *Convo) (*Convo, error) {
+	return c.create(ctx, convoPath(id)+"reply/", convo)
+}
+
+// UpdateConvo applies a patch to a convo, returning the updated convo.
+// `etag` must be the ETag of the version of the convo the patch is based on (or `AnyETag`); if the convo has
+// changed since, nothing is changed and an error with code `CodePreconditionFailed` is returned.
+func (c *Client) UpdateConvo(ctx context.Context, id int, patch *ConvoPatch, etag string) (*Convo, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{
+		method: "PATCH",
+		path:   convoPath(id),
+		body:   body,
+		header: http.Header{"If-Match": {etag}, "Content-Type": {"application/merge-patch+json"}},
+	}
+
+	convo := &Convo{}
+	header, err := c.do(ctx, req, convo)
+	if err != nil {
+		return nil, err
+	}
+
+	convo.ETag = header.Get("ETag")
+	return convo, nil
+}
+
+// DeleteConvo deletes a convo and its replies. `etag` works as it does for `UpdateConvo`.
+func (c *Client) DeleteConvo(ctx context.Context, id int, etag string) error {
+	req := &request{
+		method: "DELETE",
+		path:   convoPath(id),
+		header: http.Header{"If-Match": {etag}},
+	}
+
+	_, err := c.do(ctx, req, nil)
+	return err
+}
+
+func (c *Client) create(ctx context.Context, path string, convo *Convo) (*Convo, error) {
+	body, err := json.Marshal(&newConvo{Recipient: convo.Recipient, Subject: convo.Subject, Body: convo.Body})
+	if err != nil {
+		return nil, err
+	}
+
+	// The same key is sent with every retry, so that the convo is only created once
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{
+		method: "POST",
+		path:   path,
+		body:   body,
+		header: http.Header{"Idempotency-Key": {key}, "Content-Type": {"application/json"}},
+	}
+
+	created := &Convo{}
+	if _, err := c.do(ctx, req, created); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+type request struct {
+	method string
+	path   string
+	body   []byte
+	header http.Header
+}
+
+func (r *request) retryable() bool {
+	return r.method == "GET" || r.method == "DELETE" || r.header.Get("Idempotency-Key") != ""
+}
+
+type envelope struct {
+	Response json.RawMessage `json:"response"`
+	Error    *Error          `json:"error"`
+}
+
+// do sends a request, retrying it if possible, and decodes the `response` of the envelope into `v`
+func (c *Client) do(ctx context.Context, r *request, v interface{}) (http.Header, error) {
+	backoff := c.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		header, err := c.send(ctx, r, v)
+		if err == nil || !r.retryable() || attempt >= c.MaxRetries || !isTemporary(err) {
+			return header, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, r *request, v interface{}) (http.Header, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequest(r.method, c.BaseURL+r.path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-USER-API-KEY", c.UserKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		// The context's error is more useful than the one wrapped by `net/http`
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &networkError{err}
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &networkError{err}
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		// Errors which happen before the request reaches a handler, e.g. an unknown route, are not wrapped
+		if resp.StatusCode >= 400 {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		}
+		return nil, fmt.Errorf("convos: invalid response: %v", err)
+	}
+
+	if env.Error != nil || resp.StatusCode >= 400 {
+		apiErr := env.Error
+		if apiErr == nil {
+			apiErr = &Error{Message: http.StatusText(resp.StatusCode)}
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return nil, apiErr
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(env.Response, v); err != nil {
+			return nil, fmt.Errorf("convos: invalid response: %v", err)
+		}
+	}
+
+	return resp.Header, nil
+}
+
+func convoPath(id int) string {
+	return fmt.Sprintf("/convos/%d/", id)
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeServer answers requests with the envelopes the convos server would send
+type fakeServer struct {
+	*httptest.Server
+	requests []*http.Request
+	handler  func(w http.ResponseWriter, r *http.Request)
+}
+
+func newFakeServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *fakeServer {
+	s := &fakeServer{handler: handler}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-USER-API-KEY") != "1" {
+			t.Errorf("Wrong X-USER-API-KEY header. Expected: %v. Actual: %v", "1", r.Header.Get("X-USER-API-KEY"))
+		}
+
+		s.requests = append(s.requests, r)
+		s.handler(w, r)
+	}))
+
+	return s
+}
+
+func (s *fakeServer) client() *Client {
+	c := New(s.URL+"/v1", "1")
+	c.RetryBackoff = time.Millisecond
+	return c
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, response interface{}, apiErr *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"response": response,
+		"meta":     map[string]string{"count": "1"},
+		"error":    apiErr,
+	})
+}
+
+func Test_ListConvos(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/convos/" || r.URL.Query().Get("archived") != "true" {
+			t.Errorf("Wrong request: %s %s", r.Method, r.URL)
+		}
+
+		writeEnvelope(w, http.StatusOK, []*Convo{{Id: 1, Subject: "First Post"}, {Id: 2, Subject: "Second Post"}}, nil)
+	})
+	defer s.Close()
+
+	convos, err := s.client().ListConvos(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Convo{{Id: 1, Subject: "First Post"}, {Id: 2, Subject: "Second Post"}}
+	if !reflect.DeepEqual(convos, expected) {
+		t.Errorf("Convos do not match.\nExpected: %#v\nActual  : %#v", expected, convos)
+	}
+}
+
+func Test_GetConvo_NotFound(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeEnvelope(w, http.StatusNotFound, "", &Error{Code: CodeNotFound, Message: "Unable to find convo with id '3'."})
+	})
+	defer s.Close()
+
+	_, err := s.client().GetConvo(context.Background(), 3)
+
+	expected := &Error{StatusCode: http.StatusNotFound, Code: CodeNotFound, Message: "Unable to find convo with id '3'."}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Errors do not match.\nExpected: %#v\nActual  : %#v", expected, err)
+	}
+
+	if ErrorCode(err) != CodeNotFound {
+		t.Errorf("Wrong error code. Expected: %v. Actual: %v", CodeNotFound, ErrorCode(err))
+	}
+
+	// Errors which are not worth retrying are only sent once
+	if len(s.requests) != 1 {
+		t.Errorf("Wrong number of requests. Expected: %v. Actual: %v", 1, len(s.requests))
+	}
+}
+
+func Test_UpdateConvo(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("ETag", `"v1"`)
+			writeEnvelope(w, http.StatusOK, &Convo{Id: 1}, nil)
+			return
+		}
+
+		var patch map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&patch)
+
+		if r.Header.Get("If-Match") != `"v1"` || !reflect.DeepEqual(patch, map[string]interface{}{"read": true}) {
+			t.Errorf("Wrong request. If-Match: %v. Patch: %v", r.Header.Get("If-Match"), patch)
+		}
+
+		w.Header().Set("ETag", `"v2"`)
+		writeEnvelope(w, http.StatusOK, &Convo{Id: 1, Read: true}, nil)
+	})
+	defer s.Close()
+
+	c := s.client()
+	convo, err := c.GetConvo(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := true
+	updated, err := c.UpdateConvo(context.Background(), convo.Id, &ConvoPatch{Read: &read}, convo.ETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !updated.Read || updated.ETag != `"v2"` {
+		t.Errorf("Convo was not updated. Actual: %#v", updated)
+	}
+}
+
+func Test_CreateConvo_Retries(t *testing.T) {
+	s := newFakeServer(t, nil)
+	defer s.Close()
+
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		if len(s.requests) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		writeEnvelope(w, http.StatusCreated, &Convo{Id: 5, Recipient: 2, Subject: "First Post"}, nil)
+	}
+
+	convo, err := s.client().CreateConvo(context.Background(), &Convo{Recipient: 2, Subject: "First Post", Body: "Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if convo.Id != 5 {
+		t.Errorf("Wrong convo. Actual: %#v", convo)
+	}
+
+	if len(s.requests) != 3 {
+		t.Fatalf("Wrong number of requests. Expected: %v. Actual: %v", 3, len(s.requests))
+	}
+
+	// Every attempt must use the same key, so that the convo is only created once
+	key := s.requests[0].Header.Get("Idempotency-Key")
+	for i, req := range s.requests {
+		if key == "" || req.Header.Get("Idempotency-Key") != key {
+			t.Errorf("Wrong Idempotency-Key for attempt %d. Expected: %v. Actual: %v", i, key, req.Header.Get("Idempotency-Key"))
+		}
+	}
+}
+
+func Test_UpdateConvo_NotRetried(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer s.Close()
+
+	read := true
+	_, err := s.client().UpdateConvo(context.Background(), 1, &ConvoPatch{Read: &read}, AnyETag)
+
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Wrong error. Actual: %#v", err)
+	}
+
+	if len(s.requests) != 1 {
+		t.Errorf("Wrong number of requests. Expected: %v. Actual: %v", 1, len(s.requests))
+	}
+}
+
+func Test_Context_Canceled(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer s.Close()
+
+	c := s.client()
+	c.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.ListConvos(ctx, false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Wrong error. Expected: %v. Actual: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func Test_Error(t *testing.T) {
+	err := &Error{StatusCode: http.StatusConflict, Code: CodeConflict, Message: "Already exists."}
+	if expected := "convos: 409 conflict: Already exists."; err.Error() != expected {
+		t.Errorf("Wrong message. Expected: %v. Actual: %v", expected, err.Error())
+	}
+}
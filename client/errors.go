@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error codes sent by the server. They never change, so use them rather than the message to decide what to do.
+const (
+	CodeInvalidJson          = "invalid_json"
+	CodeInvalidRequest       = "invalid_request"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeGone                 = "gone"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeTooLarge             = "too_large"
+	CodeInvalidPatch         = "invalid_patch"
+	CodeInvalidFields        = "invalid_fields"
+	CodePreconditionRequired = "precondition_required"
+	CodeInternalError        = "internal_error"
+)
+
+// Error is an error sent by the server
+type Error struct {
+	StatusCode int           `json:"-"`
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	Fields     []*FieldError `json:"fields"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("convos: %d %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("convos: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// FieldError explains why a field of a convo is invalid, when the error code is `CodeInvalidFields`
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorCode returns the code of an error sent by the server, or "" for any other error
+func ErrorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+
+	return ""
+}
+
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string {
+	return "convos: " + e.err.Error()
+}
+
+// isTemporary reports whether a request which failed with `err` may succeed if it is sent again
+func isTemporary(err error) bool {
+	switch e := err.(type) {
+	case *networkError:
+		return true
+	case *Error:
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	return false
+}
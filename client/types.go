@@ -0,0 +1,44 @@
+package client
+
+import "time"
+
+// Convo is a convo as it is sent by the server. See the README for the meaning of each field.
+type Convo struct {
+	Id          int           `json:"id"`
+	Sender      int           `json:"sender"`
+	Recipient   int           `json:"recipient"`
+	Parent      int           `json:"parent"`
+	Subject     string        `json:"subject"`
+	Body        string        `json:"body"`
+	Read        bool          `json:"read"`
+	Archived    bool          `json:"archived"`
+	Replies     []*Convo      `json:"replies"`
+	EditedAt    *time.Time    `json:"edited_at"`
+	Attachments []*Attachment `json:"attachments"`
+	UndoUntil   *time.Time    `json:"undo_until"`
+
+	// The version of the convo, needed to update or delete it. Only set by `GetConvo` and `UpdateConvo`.
+	ETag string `json:"-"`
+}
+
+type Attachment struct {
+	Id          int    `json:"id"`
+	Convo       int    `json:"convo"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// ConvoPatch holds the changes to make to a convo. Fields left as nil are not changed.
+type ConvoPatch struct {
+	Read     *bool   `json:"read,omitempty"`
+	Archived *bool   `json:"archived,omitempty"`
+	Subject  *string `json:"subject,omitempty"`
+	Body     *string `json:"body,omitempty"`
+}
+
+type newConvo struct {
+	Recipient int    `json:"recipient"`
+	Subject   string `json:"subject,omitempty"`
+	Body      string `json:"body"`
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// config is read from a JSON file, by default `~/.convos.json`:
+//
+//	{"url": "http://localhost:8080/v1", "user_key": "1"}
+type config struct {
+	URL     string `json:"url"`
+	UserKey string `json:"user_key"`
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("CONVOS_CONFIG"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".convos.json"
+	}
+
+	return filepath.Join(home, ".convos.json")
+}
+
+func loadConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %v", err)
+	}
+	defer f.Close()
+
+	cfg := &config{}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+
+	if cfg.URL == "" || cfg.UserKey == "" {
+		return nil, fmt.Errorf("invalid config %s: `url` and `user_key` are required", path)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,235 @@
+// convos-cli works with the convos API from a terminal.
+//
+// Usage:
+//
+//	convos-cli [-config path] [-format table|json] <command> [arguments]
+//
+// Commands:
+//
+//	inbox [-archived]                     list threads
+//	thread <id>                           show a convo and all of its replies
+//	compose -to <user> -subject <subject> [-body <body>]
+//	reply <id> [-body <body>]             reply to the other person in a convo
+//	read <id>                             mark a convo as read
+//	delete <id>                           delete a convo and its replies
+//
+// When `-body` is not given, the body is read from standard input.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nt3rp/convos/client"
+)
+
+var errUsage = errors.New("usage: convos-cli [-config path] [-format table|json] inbox|thread|compose|reply|read|delete [arguments]")
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type cli struct {
+	client *client.Client
+	config *config
+	out    *output
+	stdin  io.Reader
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("convos-cli", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	configPath := flags.String("config", defaultConfigPath(), "path of the config file")
+	format := flags.String("format", "table", "output format: table or json")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return errUsage
+	}
+
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	c := &cli{
+		client: client.New(cfg.URL, cfg.UserKey),
+		config: cfg,
+		out:    &output{w: stdout, json: *format == "json"},
+		stdin:  stdin,
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"inbox":   c.inbox,
+		"thread":  c.thread,
+		"compose": c.compose,
+		"reply":   c.reply,
+		"read":    c.read,
+		"delete":  c.delete,
+	}
+
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		return errUsage
+	}
+
+	return command(ctx, flags.Args()[1:])
+}
+
+func (c *cli) inbox(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("inbox", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	archived := flags.Bool("archived", false, "list archived threads instead")
+	if err := flags.Parse(args); err != nil {
+		return errors.New("usage: convos-cli inbox [-archived]")
+	}
+
+	convos, err := c.client.ListConvos(ctx, *archived)
+	if err != nil {
+		return err
+	}
+
+	return c.out.convoList(convos)
+}
+
+func (c *cli) thread(ctx context.Context, args []string) error {
+	id, err := idArgument("thread <id>", args)
+	if err != nil {
+		return err
+	}
+
+	convo, err := c.client.GetConvo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.out.thread(convo)
+}
+
+func (c *cli) compose(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("compose", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	to := flags.Int("to", 0, "user id of the recipient")
+	subject := flags.String("subject", "", "subject of the new thread")
+	body := flags.String("body", "", "body of the convo; read from standard input if not given")
+	if err := flags.Parse(args); err != nil || *to == 0 {
+		return errors.New("usage: convos-cli compose -to <user> -subject <subject> [-body <body>]")
+	}
+
+	text, err := c.bodyOrStdin(*body)
+	if err != nil {
+		return err
+	}
+
+	convo, err := c.client.CreateConvo(ctx, &client.Convo{Recipient: *to, Subject: *subject, Body: text})
+	if err != nil {
+		return err
+	}
+
+	return c.out.convo(convo)
+}
+
+func (c *cli) reply(ctx context.Context, args []string) error {
+	usage := errors.New("usage: convos-cli reply <id> [-body <body>]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return usage
+	}
+
+	flags := flag.NewFlagSet("reply", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	body := flags.String("body", "", "body of the reply; read from standard input if not given")
+	if err := flags.Parse(args[1:]); err != nil {
+		return usage
+	}
+
+	text, err := c.bodyOrStdin(*body)
+	if err != nil {
+		return err
+	}
+
+	// The server sends the reply to whoever is on the other end of the convo
+	convo, err := c.client.Reply(ctx, id, &client.Convo{Body: text})
+	if err != nil {
+		return err
+	}
+
+	return c.out.convo(convo)
+}
+
+func (c *cli) read(ctx context.Context, args []string) error {
+	id, err := idArgument("read <id>", args)
+	if err != nil {
+		return err
+	}
+
+	convo, err := c.client.GetConvo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	read := true
+	convo, err = c.client.UpdateConvo(ctx, id, &client.ConvoPatch{Read: &read}, convo.ETag)
+	if err != nil {
+		return err
+	}
+
+	return c.out.convo(convo)
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	id, err := idArgument("delete <id>", args)
+	if err != nil {
+		return err
+	}
+
+	convo, err := c.client.GetConvo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.DeleteConvo(ctx, id, convo.ETag); err != nil {
+		return err
+	}
+
+	return c.out.message(fmt.Sprintf("Deleted convo %d.", id))
+}
+
+func (c *cli) bodyOrStdin(body string) (string, error) {
+	if body != "" {
+		return body, nil
+	}
+
+	b, err := ioutil.ReadAll(c.stdin)
+	return strings.TrimRight(string(b), "\n"), err
+}
+
+func idArgument(usage string, args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("usage: convos-cli " + usage)
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, errors.New("usage: convos-cli " + usage)
+	}
+
+	return id, nil
+}
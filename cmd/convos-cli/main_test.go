@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nt3rp/convos/client"
+)
+
+var testThread = &client.Convo{
+	Id: 1, Parent: 1, Sender: 1, Recipient: 2, Subject: "First Post", Body: "Hello", Read: true,
+	Replies: []*client.Convo{
+		{Id: 2, Parent: 1, Sender: 2, Recipient: 1, Subject: "First Post", Body: "Hi\nHow are you?", Replies: []*client.Convo{
+			{Id: 4, Parent: 2, Sender: 1, Recipient: 2, Subject: "First Post", Body: "Good", Read: true},
+		}},
+		{Id: 3, Parent: 1, Sender: 2, Recipient: 1, Subject: "First Post", Body: "Also"},
+	},
+}
+
+// runCLI runs a command against a fake server, returning what was written to standard output
+func runCLI(t *testing.T, handler http.HandlerFunc, args ...string) (string, error) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "convos-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.json")
+	config := `{"url": "` + server.URL + `/v1", "user_key": "1"}`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	err = run(context.Background(), append([]string{"-config", configPath}, args...), strings.NewReader(""), &stdout)
+	return stdout.String(), err
+}
+
+func respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("ETag", `"v1"`)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"response": response, "error": nil})
+}
+
+func Test_Inbox(t *testing.T) {
+	out, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, []*client.Convo{testThread})
+	}, "inbox")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID  FROM  TO  READ  SUBJECT\n" +
+		"1   1     2   yes   First Post\n"
+	if out != expected {
+		t.Errorf("Wrong output.\nExpected:\n%s\nActual:\n%s", expected, out)
+	}
+}
+
+func Test_Thread(t *testing.T) {
+	out, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, testThread)
+	}, "thread", "1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "First Post\n\n" +
+		"ID  FROM  TO  READ  MESSAGE\n" +
+		"1   1     2   yes   Hello\n" +
+		"2   2     1   no    ├─Hi\n" +
+		"                    │ │ How are you?\n" +
+		"4   1     2   yes   │ └─Good\n" +
+		"3   2     1   no    └─Also\n"
+	if out != expected {
+		t.Errorf("Wrong output.\nExpected:\n%s\nActual:\n%s", expected, out)
+	}
+}
+
+func Test_Thread_Json(t *testing.T) {
+	out, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, testThread)
+	}, "-format", "json", "thread", "1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var convo *client.Convo
+	if err := json.Unmarshal([]byte(out), &convo); err != nil || len(convo.Replies) != 2 {
+		t.Errorf("Invalid JSON output: %v\n%s", err, out)
+	}
+}
+
+func Test_Reply(t *testing.T) {
+	var reply map[string]interface{}
+
+	_, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/convos/2/reply/" {
+			t.Errorf("Wrong path. Expected: %v. Actual: %v", "/v1/convos/2/reply/", r.URL.Path)
+		}
+
+		json.NewDecoder(r.Body).Decode(&reply)
+		respond(w, http.StatusCreated, &client.Convo{Id: 5})
+	}, "reply", "2", "-body", "Fine, thanks")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server works out who the reply goes to
+	if reply["recipient"] != float64(0) || reply["body"] != "Fine, thanks" {
+		t.Errorf("Wrong reply: %v", reply)
+	}
+}
+
+func Test_Delete(t *testing.T) {
+	var ifMatch string
+
+	out, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			ifMatch = r.Header.Get("If-Match")
+		}
+
+		respond(w, http.StatusOK, testThread)
+	}, "delete", "1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ifMatch != `"v1"` {
+		t.Errorf("Wrong If-Match header. Expected: %v. Actual: %v", `"v1"`, ifMatch)
+	}
+
+	if out != "Deleted convo 1.\n" {
+		t.Errorf("Wrong output: %q", out)
+	}
+}
+
+func Test_Error(t *testing.T) {
+	_, err := runCLI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response": "",
+			"error":    map[string]string{"code": "not_found", "message": "Unable to find convo with id '9'."},
+		})
+	}, "thread", "9")
+
+	if client.ErrorCode(err) != client.CodeNotFound {
+		t.Errorf("Wrong error. Expected code: %v. Actual: %v", client.CodeNotFound, err)
+	}
+}
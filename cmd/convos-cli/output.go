@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nt3rp/convos/client"
+)
+
+// output writes results either as tables for people, or as JSON for scripts
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+func (o *output) convoList(convos []*client.Convo) error {
+	if o.json {
+		return o.writeJson(convos)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFROM\tTO\tREAD\tSUBJECT")
+	for _, c := range convos {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\n", c.Id, c.Sender, c.Recipient, yesNo(c.Read), c.Subject)
+	}
+
+	return tw.Flush()
+}
+
+// thread shows a convo and its replies as a tree
+func (o *output) thread(convo *client.Convo) error {
+	if o.json {
+		return o.writeJson(convo)
+	}
+
+	fmt.Fprintf(o.w, "%s\n\n", convo.Subject)
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFROM\tTO\tREAD\tMESSAGE")
+	writeTree(tw, convo, "", "")
+
+	return tw.Flush()
+}
+
+func writeTree(w io.Writer, convo *client.Convo, prefix, childPrefix string) {
+	lines := strings.Split(convo.Body, "\n")
+	fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s%s\n", convo.Id, convo.Sender, convo.Recipient, yesNo(convo.Read), prefix, lines[0])
+
+	// The rest of the body is kept in line with the first line, under any replies' branches
+	bodyPrefix := childPrefix
+	if len(convo.Replies) > 0 {
+		bodyPrefix += "│ "
+	} else {
+		bodyPrefix += "  "
+	}
+
+	for _, line := range lines[1:] {
+		fmt.Fprintf(w, "\t\t\t\t%s%s\n", bodyPrefix, line)
+	}
+
+	for i, reply := range convo.Replies {
+		if i == len(convo.Replies)-1 {
+			writeTree(w, reply, childPrefix+"└─", childPrefix+"  ")
+		} else {
+			writeTree(w, reply, childPrefix+"├─", childPrefix+"│ ")
+		}
+	}
+}
+
+func (o *output) convo(convo *client.Convo) error {
+	if o.json {
+		return o.writeJson(convo)
+	}
+
+	return o.convoList([]*client.Convo{convo})
+}
+
+func (o *output) message(message string) error {
+	if o.json {
+		return o.writeJson(map[string]string{"message": message})
+	}
+
+	_, err := fmt.Fprintln(o.w, message)
+	return err
+}
+
+func (o *output) writeJson(v interface{}) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
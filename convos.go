@@ -3,20 +3,107 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
+	"net/mail"
+	"time"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/blobs"
 	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/email"
 	"github.com/nt3rp/convos/handlers"
+	"github.com/nt3rp/convos/rpc"
+	"github.com/nt3rp/convos/webhooks"
 )
 
 var (
-	httpPort int = 8080
+	httpPort                int           = 8080
+	grpcPort                int           = 9090
+	scheduledDeliveryPeriod time.Duration = 15 * time.Second
+	undoSendDelay           time.Duration = 10 * time.Second
+	editWindow              time.Duration = 15 * time.Minute
+	attachmentPurgePeriod   time.Duration = 10 * time.Minute
+	idempotencyKeyRetention time.Duration = 24 * time.Hour
+	idempotencyKeyPurge     time.Duration = time.Hour
+	eventPurgePeriod        time.Duration = time.Hour
+	tombstonePurgePeriod    time.Duration = time.Hour
+	webhookDeliveryPeriod   time.Duration = 5 * time.Second
+	webhookPurgePeriod      time.Duration = time.Hour
+	outboxRelayPeriod       time.Duration = time.Second
+	outboxPurgePeriod       time.Duration = time.Hour
+	emailDeliveryPeriod     time.Duration = 15 * time.Second
+	emailPurgePeriod        time.Duration = time.Hour
+	emailDigestWindow       time.Duration = 10 * time.Minute
+
+	// Attachments are kept in `attachmentsDir`, unless an S3-compatible endpoint is provided
+	attachmentsDir string = "./attachments"
+	s3Endpoint     string = ""
+	s3Bucket       string = "convos-attachments"
+	s3Region       string = "us-east-1"
+	s3AccessKey    string = ""
+	s3SecretKey    string = ""
+
+	// Email notifications are only sent if an SMTP server is provided
+	smtpAddr     string = ""
+	smtpUsername string = ""
+	smtpPassword string = ""
+	emailFrom    string = "Convos <convos@localhost>"
+
+	// Notifications can be replied to by email if a secret is provided to sign reply addresses, which are in
+	// `replyDomain`. Replies are received by an SMTP server on `inboundSMTPAddr`, to which that domain's mail is relayed.
+	replySecret     string = ""
+	replyDomain     string = "localhost"
+	inboundSMTPAddr string = ""
+
+	// The unversioned routes are kept as aliases of the v1 routes until `Sunset`
+	legacyRoutes = &handlers.Deprecation{
+		Since:     time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:    time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC),
+		Successor: handlers.PathPrefixSuccessor(handlers.V1.Prefix),
+	}
 )
 
+func newBlobStore() blobs.Store {
+	if s3Endpoint != "" {
+		return blobs.NewS3Store(s3Endpoint, s3Bucket, s3Region, s3AccessKey, s3SecretKey)
+	}
+
+	store, err := blobs.NewFileStore(attachmentsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return store
+}
+
 func main() {
 	// Initialize Database
 	db.Initialize("convos")
+	db.UndoSendDelay = undoSendDelay
+	db.EditWindow = editWindow
+	db.IdempotencyKeyRetention = idempotencyKeyRetention
+
+	// Initialize attachment storage
+	handlers.BlobStore = newBlobStore()
+
+	// Start background jobs
+	db.StartScheduledDelivery(scheduledDeliveryPeriod)
+	db.StartAttachmentPurge(handlers.BlobStore, attachmentPurgePeriod)
+	db.StartIdempotencyKeyPurge(idempotencyKeyPurge)
+	db.StartEventPurge(eventPurgePeriod)
+	db.StartTombstonePurge(tombstonePurgePeriod)
+	webhooks.StartDelivery(webhookDeliveryPeriod)
+	db.StartWebhookDeliveryPurge(webhookPurgePeriod)
+	email.ReplySecret = replySecret
+	email.ReplyDomain = replyDomain
+	startEmailNotifications()
+	startInboundEmail()
+	db.StartOutboxRelay(outboxRelayPeriod)
+	db.StartOutboxPurge(outboxPurgePeriod)
+
+	// Serve the gRPC API alongside the HTTP one
+	go serveGRPC()
 
 	m := martini.Classic()
 
@@ -24,16 +111,126 @@ func main() {
 	m.Use(render.Renderer())
 
 	// Define Routes
-	m.Group("/convos", func(r martini.Router) {
-		r.Get("/", handlers.GetConvos)
-		r.Post("/", handlers.CreateConvo)
-		r.Get("/:id/", handlers.GetConvo)
-		r.Patch("/:id/", handlers.UpdateConvo)
-		r.Delete("/:id/", handlers.DeleteConvo)
-		r.Post("/:id/reply/", handlers.CreateConvo)
-	}, handlers.UserAuthorizationMiddleware)
+	registerRoutes(m)
 
 	log.Printf("listening on %v\n", httpPort)
 	httpAddr := fmt.Sprintf(":%d", httpPort)
 	m.RunOnAddr(httpAddr)
 }
+
+// startEmailNotifications subscribes to new convos and sends their notifications, if an SMTP server is provided.
+// It must run before the outbox relay starts.
+func startEmailNotifications() {
+	if smtpAddr == "" {
+		return
+	}
+
+	from, err := mail.ParseAddress(emailFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	email.SMTPAddr = smtpAddr
+	email.SMTPUsername = smtpUsername
+	email.SMTPPassword = smtpPassword
+	email.From = from
+	email.DigestWindow = emailDigestWindow
+
+	email.Subscribe()
+	email.StartDelivery(emailDeliveryPeriod)
+	db.StartEmailNotificationPurge(emailPurgePeriod)
+}
+
+// startInboundEmail accepts replies to notifications by email, if an address is provided to listen on
+func startInboundEmail() {
+	if inboundSMTPAddr == "" {
+		return
+	}
+
+	if replySecret == "" {
+		log.Fatal("A reply secret is required to accept replies by email")
+	}
+
+	go func() {
+		log.Printf("inbound SMTP listening on %v\n", inboundSMTPAddr)
+		log.Fatal(email.ListenAndServeInbound(inboundSMTPAddr))
+	}()
+}
+
+func serveGRPC() {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("gRPC listening on %v\n", grpcPort)
+	log.Fatal(rpc.NewServer().Serve(listener))
+}
+
+// registerRoutes registers every route of the server. Each one must also be described in `handlers/openapi.go`.
+func registerRoutes(r martini.Router) {
+	r.Group(handlers.V1.Prefix+"/convos", convoRoutes,
+		handlers.VersionMiddleware(handlers.V1),
+		handlers.UserAuthorizationMiddleware,
+		handlers.IdParamsMiddleware,
+	)
+	r.Group(handlers.V1.Prefix, rootRoutes,
+		handlers.VersionMiddleware(handlers.V1),
+		handlers.UserAuthorizationMiddleware,
+		handlers.IdParamsMiddleware,
+	)
+
+	// A new version only needs its own groups, e.g.:
+	//   r.Group("/v2/convos", convoRoutes, handlers.VersionMiddleware(v2), handlers.UserAuthorizationMiddleware, ...)
+	r.Group("/convos", convoRoutes,
+		handlers.DeprecationMiddleware(legacyRoutes),
+		handlers.VersionMiddleware(handlers.V1),
+		handlers.UserAuthorizationMiddleware,
+		handlers.IdParamsMiddleware,
+	)
+	r.Group("", rootRoutes,
+		handlers.DeprecationMiddleware(legacyRoutes),
+		handlers.VersionMiddleware(handlers.V1),
+		handlers.UserAuthorizationMiddleware,
+		handlers.IdParamsMiddleware,
+	)
+
+	r.Post("/graphql", handlers.UserAuthorizationMiddleware, handlers.GraphQL)
+	r.Get("/openapi.json", handlers.GetOpenAPI)
+}
+
+// rootRoutes registers the routes of an API version which are not under `/convos`, relative to the version's prefix
+func rootRoutes(r martini.Router) {
+	r.Get("/sync/", handlers.SyncConvos)
+}
+
+// convoRoutes registers the routes of the convos API, relative to the group of an API version
+func convoRoutes(r martini.Router) {
+	r.Get("/scheduled/", handlers.GetScheduledConvos)
+	r.Patch("/scheduled/:id/", handlers.UpdateScheduledConvo)
+	r.Delete("/scheduled/:id/", handlers.DeleteScheduledConvo)
+
+	r.Post("/batch/", handlers.BatchConvos)
+	r.Get("/stream/", handlers.StreamEvents)
+	r.Get("/ws/", handlers.WebSocket)
+
+	r.Get("/webhooks/", handlers.GetWebhooks)
+	r.Post("/webhooks/", handlers.CreateWebhook)
+	r.Delete("/webhooks/:id/", handlers.DeleteWebhook)
+	r.Get("/webhooks/:id/deliveries/", handlers.GetWebhookDeliveries)
+	r.Post("/webhooks/:id/deliveries/:did/redeliver/", handlers.RedeliverWebhook)
+
+	r.Get("/settings/email/", handlers.GetEmailSettings)
+	r.Patch("/settings/email/", handlers.UpdateEmailSettings)
+
+	r.Get("/", handlers.GetConvos)
+	r.Post("/", handlers.CreateConvo)
+	r.Get("/:id/", handlers.GetConvo)
+	r.Patch("/:id/", handlers.UpdateConvo)
+	r.Delete("/:id/", handlers.DeleteConvo)
+	r.Post("/:id/reply/", handlers.CreateConvo)
+	r.Post("/:id/forward/", handlers.ForwardConvo)
+	r.Post("/:id/undo/", handlers.UndoConvo)
+	r.Get("/:id/revisions/", handlers.GetConvoRevisions)
+	r.Get("/:id/attachments/:aid/", handlers.GetAttachment)
+}
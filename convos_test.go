@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+	"github.com/nt3rp/convos/handlers"
+)
+
+func Test_OpenAPIDescribesAllRoutes(t *testing.T) {
+	router := martini.NewRouter()
+	registerRoutes(router)
+
+	doc := handlers.NewOpenAPIDocument()
+	routes := router.(martini.Routes).All()
+
+	if len(routes) == 0 {
+		t.Fatalf("No routes were registered")
+	}
+
+	for _, route := range routes {
+		path := handlers.OpenAPIPath(route.Pattern())
+		if _, ok := doc.Paths[path][strings.ToLower(route.Method())]; !ok {
+			t.Errorf("Route is missing from the OpenAPI document: %s %s", route.Method(), path)
+		}
+	}
+}
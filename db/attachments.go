@@ -0,0 +1,235 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+	"github.com/nt3rp/convos/blobs"
+)
+
+type Attachment struct {
+	Id          int    `json:"id"`
+	Convo       int    `json:"convo"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+
+	// Where the contents are kept in the blob store; never shown to users
+	StorageKey string `json:"-"`
+}
+
+func getAttachments(convoId int) ([]*Attachment, error) {
+	as, err := GetAttachmentsByConvo([]int{convoId})
+	return as[convoId], err
+}
+
+// GetAttachmentsByConvo returns the attachments of many convos at once, by convo id.
+// It does not check whether the user can see the convos, so callers must already have done so.
+func GetAttachmentsByConvo(convoIds []int) (map[int][]*Attachment, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, convo_id, filename, content_type, size, storage_key
+		FROM attachments
+		WHERE convo_id = ANY($1)
+		ORDER BY id
+	`, pq.Array(convoIds))
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving attachments")
+	}
+	defer rows.Close()
+
+	as := map[int][]*Attachment{}
+	for rows.Next() {
+		a := &Attachment{}
+		if err := rows.Scan(&a.Id, &a.Convo, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey); err != nil {
+			return as, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		as[a.Convo] = append(as[a.Convo], a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return as, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return as, nil
+}
+
+// GetAttachment returns an attachment of a convo, as long as the user can see the convo itself
+func GetAttachment(userId, convoId, attachmentId string) (*Attachment, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	if _, err := LookupConvo(userId, convoId); err != nil {
+		return nil, err
+	}
+
+	a := &Attachment{}
+	err = db.QueryRow(`
+		SELECT id, convo_id, filename, content_type, size, storage_key
+		FROM attachments
+		WHERE id = $1
+		AND convo_id = $2
+	`, attachmentId, convoId).Scan(&a.Id, &a.Convo, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey)
+
+	if err == sql.ErrNoRows {
+		return nil, errgo.WithCausef(err, ErrNoRows, "Unable to find attachment with id '%s'.", attachmentId)
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return a, nil
+}
+
+// GetAttachmentUsage returns the total size of the attachments the user has sent, in bytes
+func GetAttachmentUsage(userId string) (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	var usage int64
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(a.size), 0)
+		FROM attachments AS a
+		JOIN convos AS c ON c.id = a.convo_id
+		WHERE c.sender_id = $1
+	`, userId).Scan(&usage)
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return usage, nil
+}
+
+// insertAttachments links attachments, whose contents are already in the blob store, to a convo sent by the user.
+// The user is locked until the transaction ends, so that concurrent uploads cannot exceed `AttachmentQuota` together.
+func insertAttachments(tx *sql.Tx, userId string, convoId int, attachments []*Attachment) ([]*Attachment, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec("SELECT id FROM users WHERE id = $1 FOR UPDATE", userId); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error locking user")
+	}
+
+	// The new attachments are not inserted yet, so they are not counted
+	var usage int64
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(a.size), 0)
+		FROM attachments AS a
+		JOIN convos AS c ON c.id = a.convo_id
+		WHERE c.sender_id = $1
+	`, userId).Scan(&usage)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	for _, attachment := range attachments {
+		usage += attachment.Size
+	}
+
+	if usage > AttachmentQuota {
+		return nil, errgo.WithCausef(nil, ErrQuotaExceeded, "Attachments would exceed the quota of %d bytes.", AttachmentQuota)
+	}
+
+	var as []*Attachment
+	for _, attachment := range attachments {
+		a := &Attachment{}
+		err := tx.QueryRow(`
+			INSERT INTO
+			attachments (convo_id, filename, content_type, size, storage_key)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, convo_id, filename, content_type, size, storage_key
+		`, convoId, attachment.Filename, attachment.ContentType, attachment.Size, attachment.StorageKey).Scan(
+			&a.Id, &a.Convo, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey,
+		)
+
+		if err != nil {
+			return nil, errgo.WithCausef(err, ErrRowCreate, "Error creating attachment")
+		}
+
+		as = append(as, a)
+	}
+
+	return as, nil
+}
+
+// PurgeDeletedAttachments removes attachments whose convo has been deleted, and returns how many were removed.
+// The contents are only removed from the blob store once no other attachment refers to them.
+func PurgeDeletedAttachments(store blobs.Store) (int, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		DELETE
+		FROM attachments
+		WHERE convo_id IS NULL
+		RETURNING storage_key
+	`)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting attachments")
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return 0, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	for _, key := range keys {
+		var inUse bool
+		err := db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM attachments WHERE storage_key = $1)
+		`, key).Scan(&inUse)
+
+		if err != nil {
+			return 0, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		if inUse {
+			continue
+		}
+
+		if err := store.Delete(key); err != nil && err != blobs.ErrNotFound {
+			log.Printf("Error deleting blob '%s': %v\n", key, err)
+		}
+	}
+
+	return len(keys), nil
+}
+
+// StartAttachmentPurge removes the attachments of deleted convos every `interval` until the process exits
+func StartAttachmentPurge(store blobs.Store, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeDeletedAttachments(store); err != nil {
+				log.Printf("Error purging deleted attachments: %v\n", err)
+			}
+		}
+	}()
+}
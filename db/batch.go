@@ -0,0 +1,113 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/juju/errgo"
+)
+
+// Actions which can be applied to many convos at once with `BatchConvos`
+const (
+	BatchRead      = "read"
+	BatchUnread    = "unread"
+	BatchArchive   = "archive"
+	BatchUnarchive = "unarchive"
+	BatchDelete    = "delete"
+)
+
+// Statuses of a single convo in a batch
+const (
+	BatchSucceeded = "succeeded"
+	BatchNotFound  = "not_found"
+)
+
+const MaxBatchSize = 100
+
+type BatchResult struct {
+	Id     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// BatchConvos applies an action to every convo in `ids`, in a single transaction.
+// Convos which the user cannot see are skipped and reported as not found, exactly as `UpdateConvo` and `DeleteConvo`
+// would; any other error undoes the whole batch.
+func BatchConvos(userId string, ids []int, action string) (results []*BatchResult, err error) {
+	var patch *ConvoPatch
+	yes, no := true, false
+
+	switch action {
+	case BatchRead:
+		patch = &ConvoPatch{Read: &yes}
+	case BatchUnread:
+		patch = &ConvoPatch{Read: &no}
+	case BatchArchive:
+		patch = &ConvoPatch{Archived: &yes}
+	case BatchUnarchive:
+		patch = &ConvoPatch{Archived: &no}
+	case BatchDelete:
+	default:
+		return nil, errgo.WithCausef(nil, ErrInvalid, "Unknown batch action '%s'.", action)
+	}
+
+	if len(ids) == 0 || len(ids) > MaxBatchSize {
+		return nil, errgo.WithCausef(nil, ErrInvalid, "A batch must have between 1 and %d ids.", MaxBatchSize)
+	}
+
+	// Always lock convos in the same order, so that concurrent batches cannot deadlock
+	ids = uniqueSortedIds(ids)
+
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	for _, id := range ids {
+		convoId := strconv.Itoa(id)
+		if action == BatchDelete {
+			err = deleteConvo(tx, userId, convoId, nil)
+		} else {
+			_, err = updateConvo(tx, userId, convoId, patch, nil)
+		}
+
+		status := BatchSucceeded
+		if errgo.Cause(err) == ErrNoRows {
+			status, err = BatchNotFound, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, &BatchResult{Id: id, Status: status})
+	}
+
+	return results, nil
+}
+
+func uniqueSortedIds(ids []int) []int {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	unique := sorted[:0]
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
@@ -3,21 +3,33 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/juju/errgo"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Convo struct {
-	Id        int      `json:"id"`
-	Sender    int      `json:"sender"`
-	Recipient int      `json:"recipient"`
-	Parent    int      `json:"parent"`
-	Subject   string   `json:"subject"`
-	Body      string   `json:"body"`
-	Read      bool     `json:"read"`
-	Children  []*Convo `json:"replies"`
+	Id        int        `json:"id"`
+	Sender    int        `json:"sender"`
+	Recipient int        `json:"recipient"`
+	Parent    int        `json:"parent"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	Read      bool       `json:"read"`
+	Archived  bool       `json:"archived"`
+	Children  []*Convo   `json:"replies"`
+	EditedAt  *time.Time `json:"edited_at"`
+
+	Attachments []*Attachment `json:"attachments,omitempty"`
+
+	// Only set for the sender, while a newly created convo can still be undone
+	UndoUntil *time.Time `json:"undo_until,omitempty"`
+
+	// Only used when creating a convo, to schedule it to be sent later
+	SendAt *time.Time `json:"send_at,omitempty"`
 }
 
 func (c *Convo) ToJson() string {
@@ -25,11 +37,41 @@ func (c *Convo) ToJson() string {
 	return string(json)
 }
 
-func (c *Convo) Validate() bool {
-	return true
+// Validate checks that the convo can be sent by the user, returning a `*ValidationError` listing every invalid field
+func (c *Convo) Validate(userId string) error {
+	verr := &ValidationError{}
+
+	verr.validateText("subject", c.Subject, MaxSubjectLength)
+	verr.validateText("body", c.Body, MaxBodyLength)
+
+	switch {
+	case c.Recipient == 0:
+		verr.add("recipient", "required", "The recipient is required.")
+	case strconv.Itoa(c.Recipient) == userId:
+		verr.add("recipient", "invalid", "You cannot send a convo to yourself.")
+	default:
+		exists, err := userExists(c.Recipient)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			verr.add("recipient", "not_found", fmt.Sprintf("Unable to find recipient with id '%d'.", c.Recipient))
+		}
+	}
+
+	return verr.orNil()
 }
 
-func GetConvos(userId string) ([]*Convo, error) {
+// GetConvos returns the threads the user can see. Archived threads are only returned if `archived` is set, and are
+// the only ones returned then.
+func GetConvos(userId string, archived bool) ([]*Convo, error) {
+	return GetConvosPage(userId, archived, 0, 0)
+}
+
+// GetConvosPage returns at most `limit` of the threads `GetConvos` does (all of them if it is 0), starting with the
+// first thread older than the one with id `after` (or the newest if it is 0)
+func GetConvosPage(userId string, archived bool, after, limit int) ([]*Convo, error) {
 	db, err := DB()
 	if err != nil {
 		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
@@ -37,19 +79,27 @@ func GetConvos(userId string) ([]*Convo, error) {
 
 	rows, err := db.Query(`
 		SELECT
-		c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null
+		c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null,
+		a.user_id is not null, c.edited_at, CASE WHEN c.visible_at > now() THEN c.visible_at END
 		FROM convos AS c
 		LEFT JOIN read_status AS r ON r.thread_id = c.id AND r.user_id = $1
+		LEFT JOIN archive_status AS a ON a.thread_id = c.id AND a.user_id = $1
 		WHERE c.parent_id = c.id
-		AND (c.sender_id = $1 OR c.recipient_id = $1)
+		AND (c.sender_id = $1 OR (c.recipient_id = $1 AND c.visible_at <= now()))
+		AND (a.user_id is not null) = $2
+		AND ($3 = 0 OR c.id < $3)
 		ORDER BY c.id DESC
-	`, userId)
+		LIMIT NULLIF($4, 0)
+	`, userId, archived, after, limit)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving conversations")
+	}
 	defer rows.Close()
 
 	var cs []*Convo
 	for rows.Next() {
 		c := &Convo{}
-		if err := rows.Scan(&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read); err != nil {
+		if err := rows.Scan(&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read, &c.Archived, &c.EditedAt, &c.UndoUntil); err != nil {
 			return cs, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
 		}
 
@@ -64,21 +114,39 @@ func GetConvos(userId string) ([]*Convo, error) {
 }
 
 func GetConvo(userId, convoId string) (*Convo, error) {
-	c := &Convo{}
+	c, err := LookupConvo(userId, convoId)
+	if err != nil {
+		return c, err
+	}
+
+	return c, loadConvoTree(userId, c)
+}
 
+// LookupConvo returns a convo the user can see, without its attachments and replies. It is enough to check that the
+// user can see a convo, or to compute its ETag.
+func LookupConvo(userId, convoId string) (*Convo, error) {
 	db, err := DB()
 	if err != nil {
-		return c, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+		return &Convo{}, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
 	}
 
-	err = db.QueryRow(`
-		SELECT c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null
+	return lookupConvo(db, userId, convoId)
+}
+
+func lookupConvo(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userId, convoId string) (*Convo, error) {
+	c := &Convo{}
+	err := q.QueryRow(`
+		SELECT c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null,
+		a.user_id is not null, c.edited_at, CASE WHEN c.visible_at > now() THEN c.visible_at END
 		FROM convos AS c
 		LEFT JOIN read_status AS r ON r.thread_id = c.id AND r.user_id = $2
+		LEFT JOIN archive_status AS a ON a.thread_id = c.id AND a.user_id = $2
 		WHERE id = $1
-		AND (c.sender_id = $2 OR c.recipient_id = $2)
+		AND (c.sender_id = $2 OR (c.recipient_id = $2 AND c.visible_at <= now()))
 	`, convoId, userId).Scan(
-		&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read,
+		&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read, &c.Archived, &c.EditedAt, &c.UndoUntil,
 	)
 
 	if err == sql.ErrNoRows {
@@ -92,47 +160,96 @@ func GetConvo(userId, convoId string) (*Convo, error) {
 	return c, nil
 }
 
-func DeleteConvo(userId, convoId string) error {
-	db, err := DB()
+// loadConvoTree adds the attachments and the replies which the user can see to a convo
+func loadConvoTree(userId string, c *Convo) error {
+	var err error
+	c.Attachments, err = getAttachments(c.Id)
 	if err != nil {
 		return err
 	}
 
-	// No need to update read status on delete, should be handled by DB
+	replies, err := GetReplies(userId, []int{c.Id})
+	if err != nil {
+		return err
+	}
+	c.Children = replies[c.Id]
 
-	result, err := db.Exec(`
-		DELETE
-		FROM convos
-		WHERE id = $1
-		AND (sender_id = $2 OR recipient_id = $2)
-	`, convoId, userId)
+	return nil
+}
 
+// GetReplies returns the replies to each of the convos which the user can see, each with their own replies.
+// It does not check whether the user can see the convos themselves.
+func GetReplies(userId string, convoIds []int) (map[int][]*Convo, error) {
+	db, err := DB()
 	if err != nil {
-		return errgo.WithCausef(err, ErrRowDelete, "Error deleting convo.")
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
 	}
 
-	count, err := result.RowsAffected()
-
+	rows, err := db.Query(`
+		WITH RECURSIVE thread (id) AS (
+			SELECT id FROM convos WHERE parent_id = ANY($1) AND id != parent_id
+			UNION ALL
+			SELECT c.id FROM convos AS c JOIN thread AS t ON c.parent_id = t.id AND c.id != c.parent_id
+		)
+		SELECT
+		c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null,
+		a.user_id is not null, c.edited_at, CASE WHEN c.visible_at > now() THEN c.visible_at END
+		FROM convos AS c
+		JOIN thread USING (id)
+		LEFT JOIN read_status AS r ON r.thread_id = c.id AND r.user_id = $2
+		LEFT JOIN archive_status AS a ON a.thread_id = c.id AND a.user_id = $2
+		WHERE (c.sender_id = $2 OR (c.recipient_id = $2 AND c.visible_at <= now()))
+		ORDER BY c.id
+	`, pq.Array(convoIds), userId)
 	if err != nil {
-		return errgo.WithCausef(err, ErrRowDelete, "Error deleting convo.")
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving replies")
 	}
+	defer rows.Close()
 
-	if count == 0 {
-		return errgo.WithCausef(err, ErrNoRows, "Unable to find convo with id '%s'.", convoId)
+	// Replies always have a greater id than what they reply to, so parents are seen before their replies
+	seen := map[int]*Convo{}
+	for _, id := range convoIds {
+		seen[id] = &Convo{}
 	}
 
-	return nil
+	for rows.Next() {
+		c := &Convo{}
+		if err := rows.Scan(&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read, &c.Archived, &c.EditedAt, &c.UndoUntil); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		// Replies to a convo the user cannot see are hidden along with it
+		parent, ok := seen[c.Parent]
+		if !ok {
+			continue
+		}
+
+		parent.Children = append(parent.Children, c)
+		seen[c.Id] = c
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	replies := map[int][]*Convo{}
+	for _, id := range convoIds {
+		replies[id] = seen[id].Children
+	}
+
+	return replies, nil
 }
 
-func CreateConvo(userId string, convo *Convo) (*Convo, error) {
+// DeleteConvo deletes a convo the user can see. If a precondition is given, the convo is only deleted if it holds.
+func DeleteConvo(userId, convoId string, precondition Precondition) (err error) {
 	db, err := DB()
 	if err != nil {
-		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+		return err
 	}
 
 	tx, err := db.Begin()
 	if err != nil {
-		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+		return errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
 	}
 
 	defer func() {
@@ -143,20 +260,88 @@ func CreateConvo(userId string, convo *Convo) (*Convo, error) {
 		err = tx.Commit()
 	}()
 
+	return deleteConvo(tx, userId, convoId, precondition)
+}
+
+func deleteConvo(tx *sql.Tx, userId, convoId string, precondition Precondition) error {
+	if _, err := checkConvoPrecondition(tx, userId, convoId, precondition); err != nil {
+		return err
+	}
+
+	// No need to update read status on delete, should be handled by DB
+
+	if err := recordChangedEvents(tx, EventConvoDeleted, convoId); err != nil {
+		return err
+	}
+
+	if err := recordDeletedChanges(tx, convoId); err != nil {
+		return err
+	}
+
+	if err := enqueueWebhooks(tx, EventConvoDeleted, convoId); err != nil {
+		return err
+	}
+
+	if err := recordOutboxEvent(tx, EventConvoDeleted, userId, convoId, nil); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		DELETE
+		FROM convos
+		WHERE id = $1
+		AND (sender_id = $2 OR (recipient_id = $2 AND visible_at <= now()))
+	`, convoId, userId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting convo.")
+	}
+
+	count, err := result.RowsAffected()
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting convo.")
+	}
+
+	if count == 0 {
+		return errgo.WithCausef(nil, ErrNoRows, "Unable to find convo with id '%s'.", convoId)
+	}
+
+	if err := dropHiddenEvents(tx); err != nil {
+		return err
+	}
+
+	if err := dropHiddenWebhookDeliveries(tx); err != nil {
+		return err
+	}
+
+	return dropHiddenOutboxEvents(tx)
+}
+
+func CreateConvo(userId string, convo *Convo) (*Convo, error) {
+	c, _, err := CreateConvoWithIdempotencyKey(userId, convo, nil)
+	return c, err
+}
+
+// insertConvo writes a convo and marks it as read for its sender as part of an existing transaction.
+// The recipient will not see the convo until `undoDelay` has passed.
+func insertConvo(tx *sql.Tx, userId string, convo *Convo, undoDelay time.Duration) (*Convo, error) {
 	c := &Convo{}
-	err = tx.QueryRow(`
+	err := tx.QueryRow(`
 		INSERT INTO
-		convos (parent_id, sender_id, recipient_id, subject, body)
+		convos (parent_id, sender_id, recipient_id, subject, body, visible_at)
 		VALUES (
 			CASE
 			    WHEN $1=0 THEN lastval()
 				ELSE $1
 			END,
-			$2, $3, $4, $5
+			$2, $3, $4, $5,
+			now() + $6 * INTERVAL '1 millisecond'
 		)
-		RETURNING id, parent_id, sender_id, recipient_id, subject, body
-	`, convo.Parent, userId, convo.Recipient, convo.Subject, convo.Body).Scan(
-		&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body,
+		RETURNING id, parent_id, sender_id, recipient_id, subject, body,
+		CASE WHEN visible_at > now() THEN visible_at END
+	`, convo.Parent, userId, convo.Recipient, convo.Subject, convo.Body, int64(undoDelay/time.Millisecond)).Scan(
+		&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.UndoUntil,
 	)
 
 	if err != nil {
@@ -179,41 +364,318 @@ func CreateConvo(userId string, convo *Convo) (*Convo, error) {
 		return nil, errgo.WithCausef(err, ErrRowCreate, "Unable to update read status")
 	}
 
+	c.Attachments, err = insertAttachments(tx, userId, c.Id, convo.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordCreatedEvents(tx, c.Id); err != nil {
+		return nil, err
+	}
+
+	if err := recordCreatedChanges(tx, c.Id); err != nil {
+		return nil, err
+	}
+
+	eventType := EventConvoCreated
+	if c.Parent != c.Id {
+		eventType = EventReplyCreated
+	}
+
+	if err := enqueueWebhooks(tx, eventType, strconv.Itoa(c.Id)); err != nil {
+		return nil, err
+	}
+
+	if err := recordOutboxEvent(tx, eventType, userId, strconv.Itoa(c.Id), nil); err != nil {
+		return nil, err
+	}
+
 	c.Read = true
 
 	return c, nil
 }
 
-func UpdateConvo(userId, convoId string, patch map[string]string) (*Convo, error) {
+// GetReceivedConvos returns the convos, threads and replies alike, which became visible to the user as their
+// recipient after the event with the given cursor, oldest first. It also returns the cursor of the latest event
+// looked at, to be given on the next call; `CurrentEventCursor` gives the first one.
+func GetReceivedConvos(userId, cursor string) (cs []*Convo, latestCursor string, err error) {
+	position, err := parseEventCursor(cursor)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	db, err := DB()
+	if err != nil {
+		return nil, cursor, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, cursor, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if err := lockEvents(tx, userId); err != nil {
+		return nil, cursor, err
+	}
+
+	latest, err := assignEventPositions(tx, userId)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	// The sender's own event of a convo sent to themselves comes before the convo is visible, so it is skipped in
+	// favour of the recipient's
+	rows, err := tx.Query(`
+		SELECT
+		c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, r.user_id is not null,
+		a.user_id is not null, c.edited_at
+		FROM convo_events AS e
+		JOIN convos AS c ON c.id = e.convo_id
+		LEFT JOIN read_status AS r ON r.thread_id = c.id AND r.user_id = $1
+		LEFT JOIN archive_status AS a ON a.thread_id = c.id AND a.user_id = $1
+		WHERE e.user_id = $1
+		AND e.position > $2 AND e.position <= $3
+		AND e.type IN ($4, $5)
+		AND c.recipient_id = $1
+		AND c.visible_at <= now()
+		ORDER BY e.position
+	`, userId, position, latest, EventConvoCreated, EventReplyCreated)
+	if err != nil {
+		return nil, cursor, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving convos")
+	}
+	defer rows.Close()
+
+	seen := map[int]bool{}
+	for rows.Next() {
+		c := &Convo{}
+		if err := rows.Scan(&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read, &c.Archived, &c.EditedAt); err != nil {
+			return nil, cursor, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		if !seen[c.Id] {
+			seen[c.Id] = true
+			cs = append(cs, c)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, cursor, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return cs, eventCursor(latest), nil
+}
+
+// UndoConvo deletes a convo created by the user, as long as it is still hidden from its recipient
+func UndoConvo(userId, convoId string) (err error) {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// The recipient never saw the convo, so only the sender gets an event
+	if err := recordChangedEvents(tx, EventConvoDeleted, convoId); err != nil {
+		return err
+	}
+
+	if err := recordDeletedChanges(tx, convoId); err != nil {
+		return err
+	}
+
+	if err := enqueueWebhooks(tx, EventConvoDeleted, convoId); err != nil {
+		return err
+	}
+
+	if err := recordOutboxEvent(tx, EventConvoDeleted, userId, convoId, nil); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		DELETE
+		FROM convos
+		WHERE id = $1
+		AND sender_id = $2
+		AND visible_at > now()
+	`, convoId, userId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error undoing convo.")
+	}
+
+	count, err := result.RowsAffected()
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error undoing convo.")
+	}
+
+	if count == 0 {
+		return errgo.WithCausef(err, ErrNoRows, "Unable to find convo with id '%s' that can still be undone.", convoId)
+	}
+
+	if err := dropHiddenEvents(tx); err != nil {
+		return err
+	}
+
+	if err := dropHiddenWebhookDeliveries(tx); err != nil {
+		return err
+	}
+
+	return dropHiddenOutboxEvents(tx)
+}
+
+// ConvoPatch holds the changes to make to a convo. Fields left as nil are not changed.
+type ConvoPatch struct {
+	Read     *bool
+	Archived *bool
+	Subject  *string
+	Body     *string
+}
+
+// UpdateConvo applies every change in the patch in a single transaction: either all of them are made, or none are.
+// If a precondition is given, no change is made unless it holds.
+func UpdateConvo(userId, convoId string, patch *ConvoPatch, precondition Precondition) (convo *Convo, err error) {
 	db, err := DB()
 	if err != nil {
 		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
 	}
 
-	convo, err := GetConvo(userId, convoId)
+	tx, err := db.Begin()
 	if err != nil {
-		return convo, err
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
 	}
 
-	// Only proceed to update the read status if we were able to access the object
-	val, ok := patch["read"]
-	read, _ := strconv.ParseBool(val)
-	if ok {
-		var stmt string
-		if read {
-			stmt = "INSERT INTO read_status (user_id, thread_id) VALUES ($1, $2)"
-			convo.Read = true
-		} else {
-			stmt = "DELETE FROM read_status WHERE user_id = $1 AND thread_id = $2"
-			convo.Read = false
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
 		}
+		err = tx.Commit()
+	}()
 
-		_, err := db.Exec(stmt, userId, convoId)
+	convo, err = updateConvo(tx, userId, convoId, patch, precondition)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like `GetConvo`, the updated convo is returned with its attachments and replies
+	return convo, loadConvoTree(userId, convo)
+}
+
+func updateConvo(tx *sql.Tx, userId, convoId string, patch *ConvoPatch, precondition Precondition) (*Convo, error) {
+	// Only proceed to edit or update the read status if we were able to access the object
+	convo, err := checkConvoPrecondition(tx, userId, convoId, precondition)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch.Subject != nil || patch.Body != nil {
+		subject, body := convo.Subject, convo.Body
+		if patch.Subject != nil {
+			subject = *patch.Subject
+		}
 
+		if patch.Body != nil {
+			body = *patch.Body
+		}
+
+		editedAt, err := editConvo(tx, userId, convo, subject, body)
 		if err != nil {
-			return nil, errgo.WithCausef(err, ErrRowCreate, "Error updating read status")
+			return nil, err
+		}
+
+		convo.Subject = subject
+		convo.Body = body
+		convo.EditedAt = editedAt
+
+		if err := recordChangedEvents(tx, EventConvoUpdated, convoId); err != nil {
+			return nil, err
+		}
+
+		if err := recordChanges(tx, convoId); err != nil {
+			return nil, err
+		}
+
+		if err := enqueueWebhooks(tx, EventConvoUpdated, convoId); err != nil {
+			return nil, err
 		}
+
+		if err := recordOutboxEvent(tx, EventConvoUpdated, userId, convoId, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if patch.Read != nil {
+		if err := setConvoStatus(tx, "read_status", userId, convoId, *patch.Read); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowUpdate, "Error updating read status")
+		}
+
+		if err := recordReadEvent(tx, userId, convoId, *patch.Read); err != nil {
+			return nil, err
+		}
+
+		if err := enqueueReadWebhooks(tx, userId, convoId, *patch.Read); err != nil {
+			return nil, err
+		}
+
+		if err := recordOutboxEvent(tx, EventReadChanged, userId, convoId, patch.Read); err != nil {
+			return nil, err
+		}
+
+		if err := recordUserChange(tx, userId, convoId); err != nil {
+			return nil, err
+		}
+
+		convo.Read = *patch.Read
+	}
+
+	if patch.Archived != nil {
+		if err := setConvoStatus(tx, "archive_status", userId, convoId, *patch.Archived); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowUpdate, "Error updating archive status")
+		}
+
+		if err := recordUserChange(tx, userId, convoId); err != nil {
+			return nil, err
+		}
+
+		convo.Archived = *patch.Archived
+	}
+
+	return convo, nil
+}
+
+// setConvoStatus adds or removes the row for a thread in one of the per-user status tables, such as `read_status`
+func setConvoStatus(tx *sql.Tx, table, userId, convoId string, set bool) error {
+	var stmt string
+	if set {
+		stmt = `
+			INSERT INTO ` + table + ` (user_id, thread_id)
+			SELECT $1, $2
+			WHERE NOT EXISTS (SELECT 1 FROM ` + table + ` WHERE user_id = $1 AND thread_id = $2)
+		`
+	} else {
+		stmt = "DELETE FROM " + table + " WHERE user_id = $1 AND thread_id = $2"
 	}
 
-	return convo, err
+	_, err := tx.Exec(stmt, userId, convoId)
+	return err
 }
@@ -0,0 +1,323 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+)
+
+// Email notifications are queued for the recipients of new convos by an outbox subscriber (see the `email` package),
+// and sent as a digest: a user is sent the notifications queued since their last email once `window` has passed
+// since it, so that several convos arriving at once make a single email.
+
+const (
+	// The key of the advisory lock taken while claiming digests
+	emailLockKey = 49
+)
+
+// EmailSettings are the email notification settings of a user
+type EmailSettings struct {
+	// Provided along with the user, and not set through the API
+	Email *string `json:"email"`
+
+	Notifications bool `json:"notifications"`
+}
+
+// EmailSettingsPatch holds the changes to make to email settings. Fields left as nil are not changed.
+type EmailSettingsPatch struct {
+	Notifications *bool `json:"notifications"`
+}
+
+// EmailDigest is the set of notifications to send to a user in a single email
+type EmailDigest struct {
+	User  int
+	Name  string
+	Email string
+
+	// The most attempts made at sending any of the notifications
+	Attempts int
+
+	Notifications []*EmailNotification
+}
+
+// EmailNotification is a convo to notify its recipient of
+type EmailNotification struct {
+	Id         int64
+	Convo      *ConvoSnapshot
+	SenderName string
+}
+
+func GetEmailSettings(userId string) (*EmailSettings, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	s := &EmailSettings{}
+	err = db.QueryRow(`
+		SELECT u.email, NOT EXISTS (SELECT 1 FROM email_opt_outs AS o WHERE o.user_id = u.id)
+		FROM users AS u
+		WHERE u.id = $1
+	`, userId).Scan(&s.Email, &s.Notifications)
+
+	if err == sql.ErrNoRows {
+		return nil, errgo.WithCausef(err, ErrNoRows, "Unable to find user with id '%s'.", userId)
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return s, nil
+}
+
+// UpdateEmailSettings applies a patch to the user's email settings and returns them
+func UpdateEmailSettings(userId string, patch *EmailSettingsPatch) (*EmailSettings, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	if patch.Notifications != nil {
+		var stmt string
+		if *patch.Notifications {
+			stmt = "DELETE FROM email_opt_outs WHERE user_id = $1"
+		} else {
+			stmt = `
+				INSERT INTO email_opt_outs (user_id)
+				SELECT $1
+				WHERE NOT EXISTS (SELECT 1 FROM email_opt_outs WHERE user_id = $1)
+			`
+		}
+
+		if _, err := db.Exec(stmt, userId); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowUpdate, "Error updating email settings")
+		}
+	}
+
+	return GetEmailSettings(userId)
+}
+
+// QueueEmailNotification queues a notification of a convo for its recipient, unless they have no email or opted out
+func QueueEmailNotification(tx *sql.Tx, userId, convoId int) error {
+	_, err := tx.Exec(`
+		INSERT INTO email_notifications (user_id, convo_id)
+		SELECT u.id, $2::integer
+		FROM users AS u
+		WHERE u.id = $1
+		AND u.email IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM email_opt_outs AS o WHERE o.user_id = u.id)
+	`, userId, convoId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error queuing email notification")
+	}
+
+	return nil
+}
+
+// ClaimEmailDigests claims the notifications of up to `limit` users who were last sent an email at least `window`
+// ago, so that no other claim returns them for `lease`, and returns them as one digest per user
+func ClaimEmailDigests(limit int, window, lease time.Duration) (digests []*EmailDigest, err error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Claims are made one at a time, so that a user's notifications are only claimed together
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", emailLockKey); err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error locking email notifications")
+	}
+
+	// Convos deleted since, and users who can no longer be sent emails, are not notified
+	_, err = tx.Exec(`
+		DELETE
+		FROM email_notifications AS n
+		WHERE n.sent_at IS NULL
+		AND (
+			NOT EXISTS (SELECT 1 FROM convos AS c WHERE c.id = n.convo_id)
+			OR NOT EXISTS (SELECT 1 FROM users AS u WHERE u.id = n.user_id AND u.email IS NOT NULL)
+			OR EXISTS (SELECT 1 FROM email_opt_outs AS o WHERE o.user_id = n.user_id)
+		)
+	`)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowDelete, "Error deleting email notifications")
+	}
+
+	rows, err := tx.Query(`
+		UPDATE email_notifications
+		SET attempts = attempts + 1, next_attempt_at = now() + $3 * INTERVAL '1 millisecond'
+		WHERE sent_at IS NULL
+		AND user_id IN (
+			SELECT p.user_id
+			FROM email_notifications AS p
+			WHERE p.sent_at IS NULL
+			GROUP BY p.user_id
+			HAVING MAX(p.next_attempt_at) <= now()
+			AND COALESCE(
+				(SELECT MAX(s.sent_at) FROM email_notifications AS s WHERE s.user_id = p.user_id),
+				'-infinity'
+			) <= now() - $2 * INTERVAL '1 millisecond'
+			ORDER BY MIN(p.created_at)
+			LIMIT $1
+		)
+		RETURNING id
+	`, limit, int64(window/time.Millisecond), int64(lease/time.Millisecond))
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error claiming email notifications")
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return getEmailDigests(tx, ids)
+}
+
+func getEmailDigests(tx *sql.Tx, ids []int64) ([]*EmailDigest, error) {
+	rows, err := tx.Query(`
+		SELECT n.id, n.user_id, COALESCE(u.fullname, ''), u.email, n.attempts,
+		c.id, c.parent_id, c.sender_id, c.recipient_id, c.subject, c.body, c.edited_at, COALESCE(s.fullname, '')
+		FROM email_notifications AS n
+		JOIN users AS u ON u.id = n.user_id
+		JOIN convos AS c ON c.id = n.convo_id
+		LEFT JOIN users AS s ON s.id = c.sender_id
+		WHERE n.id = ANY($1)
+		ORDER BY n.user_id, n.created_at, n.id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving email notifications")
+	}
+	defer rows.Close()
+
+	var digests []*EmailDigest
+	var d *EmailDigest
+	for rows.Next() {
+		n := &EmailNotification{Convo: &ConvoSnapshot{}}
+		c := n.Convo
+		var user, attempts int
+		var name, email string
+		err := rows.Scan(&n.Id, &user, &name, &email, &attempts,
+			&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.EditedAt, &n.SenderName)
+		if err != nil {
+			return digests, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		if d == nil || d.User != user {
+			d = &EmailDigest{User: user, Name: name, Email: email}
+			digests = append(digests, d)
+		}
+
+		if attempts > d.Attempts {
+			d.Attempts = attempts
+		}
+
+		d.Notifications = append(d.Notifications, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return digests, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return digests, nil
+}
+
+// RecordEmailDigest saves the outcome of an attempt at sending a claimed digest. If it was not sent, its notifications
+// are attempted again in `retryIn`, or dropped if `retryIn` is 0.
+func RecordEmailDigest(d *EmailDigest, sent bool, retryIn time.Duration) error {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	ids := make([]int64, len(d.Notifications))
+	for i, n := range d.Notifications {
+		ids[i] = n.Id
+	}
+
+	switch {
+	case sent:
+		_, err = db.Exec(`
+			UPDATE email_notifications
+			SET sent_at = now()
+			WHERE id = ANY($1)
+		`, pq.Array(ids))
+	case retryIn > 0:
+		_, err = db.Exec(`
+			UPDATE email_notifications
+			SET next_attempt_at = now() + $2 * INTERVAL '1 millisecond'
+			WHERE id = ANY($1)
+		`, pq.Array(ids), int64(retryIn/time.Millisecond))
+	default:
+		_, err = db.Exec("DELETE FROM email_notifications WHERE id = ANY($1)", pq.Array(ids))
+	}
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error recording email digest")
+	}
+
+	return nil
+}
+
+// PurgeSentEmailNotifications removes notifications which were sent more than `EmailNotificationRetention` ago
+func PurgeSentEmailNotifications() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM email_notifications
+		WHERE sent_at <= now() - $1 * INTERVAL '1 millisecond'
+	`, int64(EmailNotificationRetention/time.Millisecond))
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting sent email notifications")
+	}
+
+	return result.RowsAffected()
+}
+
+// StartEmailNotificationPurge removes sent email notifications every `interval` until the process exits
+func StartEmailNotificationPurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeSentEmailNotifications(); err != nil {
+				log.Printf("Error purging sent email notifications: %v\n", err)
+			}
+		}
+	}()
+}
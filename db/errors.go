@@ -7,14 +7,20 @@ func (e DBError) Error() string {
 }
 
 const (
-	ErrConnection    DBError = "DB Connection"
-	ErrRowScan       DBError = "Row Scan"
-	ErrRowUnknown    DBError = "Row Unknown"
-	ErrRowDelete     DBError = "Row Delete"
-	ErrRowCreate     DBError = "Row Create"
-	ErrRowUpdate     DBError = "Row Update"
-	ErrNoRows        DBError = "No Rows Found"
-	ErrTransaction   DBError = "Transaction Problem"
-	ErrUninitialized DBError = "DB Uninitialized"
-	ErrTruncate      DBError = "Truncate Error"
+	ErrConnection         DBError = "DB Connection"
+	ErrRowScan            DBError = "Row Scan"
+	ErrRowUnknown         DBError = "Row Unknown"
+	ErrRowDelete          DBError = "Row Delete"
+	ErrRowCreate          DBError = "Row Create"
+	ErrRowUpdate          DBError = "Row Update"
+	ErrNoRows             DBError = "No Rows Found"
+	ErrForbidden          DBError = "Forbidden"
+	ErrConflict           DBError = "Conflict"
+	ErrPreconditionFailed DBError = "Precondition Failed"
+	ErrInvalid            DBError = "Invalid Request"
+	ErrGone               DBError = "Gone"
+	ErrQuotaExceeded      DBError = "Quota Exceeded"
+	ErrTransaction        DBError = "Transaction Problem"
+	ErrUninitialized      DBError = "DB Uninitialized"
+	ErrTruncate           DBError = "Truncate Error"
 )
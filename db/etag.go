@@ -0,0 +1,73 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+// Precondition decides whether a change may be made to an object, given the object's current ETag
+type Precondition func(etag string) bool
+
+// ETag returns a strong entity tag for an object, as it is seen by the user it was retrieved for.
+// Since the tag is derived from the JSON representation, it changes whenever anything the user can see changes,
+// including per-user state such as the read status.
+func ETag(obj interface{}) string {
+	b, _ := json.Marshal(obj)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ETag returns the tag of the convo itself, which `If-Match` is checked against: its attachments never change, and
+// replies are changed on their own, so neither is part of it.
+func (c *Convo) ETag() string {
+	own := *c
+	own.Children, own.Attachments = nil, nil
+	return ETag(&own)
+}
+
+// ThreadETag returns the tag of a convo along with its replies, for `If-None-Match`. It starts with the convo's own
+// `ETag`, so that it can also be given as `If-Match` (see `OwnETag`).
+func (c *Convo) ThreadETag() string {
+	own, thread := c.ETag(), ETag(c)
+	return own[:len(own)-1] + "." + thread[1:]
+}
+
+// OwnETag returns the part of a tag given as `If-Match` which is compared with `Convo.ETag`
+func OwnETag(etag string) string {
+	if i := strings.IndexByte(etag, '.'); i > 0 && strings.HasPrefix(etag, `"`) {
+		return etag[:i] + `"`
+	}
+
+	return etag
+}
+
+// checkConvoPrecondition locks a convo for the rest of the transaction and makes sure that the precondition holds for
+// the convo as it is currently seen by the user. The convo is returned without its attachments and replies.
+// The lock prevents another request from changing the convo between the check and the change.
+func checkConvoPrecondition(tx *sql.Tx, userId, convoId string, precondition Precondition) (*Convo, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM convos WHERE id = $1 FOR UPDATE", convoId).Scan(&id)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errgo.WithCausef(nil, ErrNoRows, "Unable to find convo with id '%s'.", convoId)
+	case err != nil:
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	convo, err := lookupConvo(tx, userId, convoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if precondition != nil && !precondition(convo.ETag()) {
+		return nil, errgo.WithCausef(nil, ErrPreconditionFailed, "Convo with id '%s' has changed since it was retrieved.", convoId)
+	}
+
+	return convo, nil
+}
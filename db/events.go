@@ -0,0 +1,312 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// Events are recorded in `convo_events`, in the same transaction as the change they describe, once for each user
+// who can see it. A recipient's events only become visible along with the convo, once it can no longer be undone.
+//
+// Like changes (see `Sync`), events are recorded without a position: positions are only given out when the events of
+// a user are read, in the order the events became visible. An event committed after a read is then always placed
+// after every event that read returned, which would not hold for the time it was recorded at, since transactions do
+// not commit in the order they started. Positions are the cursors of events.
+
+const (
+	EventConvoCreated = "convo.created"
+	EventReplyCreated = "reply.created"
+	EventConvoUpdated = "convo.updated"
+	EventReadChanged  = "read.changed"
+	EventConvoDeleted = "convo.deleted"
+
+	// The most events returned by a single call to `GetEvents`
+	maxEventsPerPage = 100
+
+	// The class of the advisory lock taken on the events of a user, whose id is the other half of the key
+	eventLockClass = 43
+)
+
+type Event struct {
+	// Opaque; events after this one are returned by `GetEvents(userId, cursor)`
+	Cursor string `json:"-"`
+
+	Type   string `json:"type"`
+	Convo  int    `json:"convo"`
+	Thread int    `json:"thread"`
+
+	// Only set for `read.changed` events
+	Read *bool `json:"read,omitempty"`
+}
+
+// CurrentEventCursor returns a cursor from which only the user's events which have not happened yet are returned
+func CurrentEventCursor(userId string) (cursor string, err error) {
+	db, err := DB()
+	if err != nil {
+		return "", errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if err := lockEvents(tx, userId); err != nil {
+		return "", err
+	}
+
+	latest, err := assignEventPositions(tx, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return eventCursor(latest), nil
+}
+
+// GetEvents returns the next events of the user after the one with the given cursor, oldest first
+func GetEvents(userId, cursor string) (events []*Event, err error) {
+	position, err := parseEventCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if err := lockEvents(tx, userId); err != nil {
+		return nil, err
+	}
+
+	latest, err := assignEventPositions(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if position > latest {
+		return nil, errgo.WithCausef(nil, ErrInvalid, "Invalid event id '%s'.", cursor)
+	}
+
+	rows, err := tx.Query(`
+		SELECT position, type, convo_id, thread_id, read
+		FROM convo_events
+		WHERE user_id = $1
+		AND position > $2
+		ORDER BY position
+		LIMIT $3
+	`, userId, position, maxEventsPerPage)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving events")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &Event{}
+		var read sql.NullBool
+		if err := rows.Scan(&position, &e.Type, &e.Convo, &e.Thread, &read); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		if read.Valid {
+			e.Read = &read.Bool
+		}
+		e.Cursor = eventCursor(position)
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return events, nil
+}
+
+func eventCursor(position int64) string {
+	return strconv.FormatInt(position, 10)
+}
+
+func parseEventCursor(cursor string) (int64, error) {
+	position, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || position < 0 {
+		return 0, errgo.WithCausef(nil, ErrInvalid, "Invalid event id '%s'.", cursor)
+	}
+
+	return position, nil
+}
+
+// lockEvents keeps positions from being given out to the events of the user until the end of the transaction
+func lockEvents(tx *sql.Tx, userId string) error {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2::integer)", eventLockClass, userId); err != nil {
+		return errgo.WithCausef(err, ErrTransaction, "Error locking events")
+	}
+
+	return nil
+}
+
+// assignEventPositions gives positions to the visible events of the user which do not have one yet, and returns the
+// latest position of the user's events. The events must be locked with `lockEvents`.
+func assignEventPositions(tx *sql.Tx, userId string) (int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO event_positions (user_id)
+		SELECT $1::integer
+		WHERE NOT EXISTS (SELECT 1 FROM event_positions WHERE user_id = $1::integer)
+	`, userId)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowCreate, "Error creating event position")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE convo_events AS e
+		SET position = s.position + p.n
+		FROM event_positions AS s, (
+			SELECT id, row_number() OVER (ORDER BY visible_at, id) AS n
+			FROM convo_events
+			WHERE user_id = $1 AND position IS NULL AND visible_at <= now()
+		) AS p
+		WHERE s.user_id = $1
+		AND e.id = p.id
+	`, userId)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUpdate, "Error assigning event positions")
+	}
+
+	// The latest position is kept once its events are purged, so that positions are never given out twice
+	var position int64
+	err = tx.QueryRow(`
+		UPDATE event_positions
+		SET position = GREATEST(position, (SELECT MAX(position) FROM convo_events WHERE user_id = $1))
+		WHERE user_id = $1
+		RETURNING position
+	`, userId).Scan(&position)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUpdate, "Error updating event position")
+	}
+
+	return position, nil
+}
+
+// recordCreatedEvents records the creation of a convo for its sender, and for its recipient once it is visible
+func recordCreatedEvents(tx *sql.Tx, convoId int) error {
+	_, err := tx.Exec(`
+		INSERT INTO convo_events (user_id, type, convo_id, thread_id, visible_at)
+		SELECT sender_id, CASE WHEN id = parent_id THEN $2 ELSE $3 END, id, parent_id, now()
+		FROM convos WHERE id = $1
+		UNION ALL
+		SELECT recipient_id, CASE WHEN id = parent_id THEN $2 ELSE $3 END, id, parent_id, visible_at
+		FROM convos WHERE id = $1
+	`, convoId, EventConvoCreated, EventReplyCreated)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error recording events")
+	}
+
+	return nil
+}
+
+// recordChangedEvents records a change to a convo, which must not have been deleted yet, for the users who can see it
+func recordChangedEvents(tx *sql.Tx, eventType, convoId string) error {
+	_, err := tx.Exec(`
+		INSERT INTO convo_events (user_id, type, convo_id, thread_id)
+		SELECT sender_id, $2, id, parent_id
+		FROM convos WHERE id = $1
+		UNION ALL
+		SELECT recipient_id, $2, id, parent_id
+		FROM convos WHERE id = $1 AND visible_at <= now()
+	`, convoId, eventType)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error recording events")
+	}
+
+	return nil
+}
+
+// dropHiddenEvents removes the events which are not visible yet of convos which have been deleted, e.g. the event of
+// the recipient of an undone convo
+func dropHiddenEvents(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DELETE
+		FROM convo_events AS e
+		WHERE e.visible_at > now()
+		AND NOT EXISTS (SELECT 1 FROM convos AS c WHERE c.id = e.convo_id)
+	`)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting events")
+	}
+
+	return nil
+}
+
+// recordReadEvent records a change of the read status of a convo, which only concerns the user who made it
+func recordReadEvent(tx *sql.Tx, userId, convoId string, read bool) error {
+	_, err := tx.Exec(`
+		INSERT INTO convo_events (user_id, type, convo_id, thread_id, read)
+		SELECT $1::integer, $2, id, parent_id, $4::boolean
+		FROM convos WHERE id = $3
+	`, userId, EventReadChanged, convoId, read)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error recording events")
+	}
+
+	return nil
+}
+
+// PurgeExpiredEvents removes events older than `EventRetention`
+func PurgeExpiredEvents() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM convo_events
+		WHERE visible_at <= now() - $1 * INTERVAL '1 millisecond'
+	`, int64(EventRetention/time.Millisecond))
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting expired events")
+	}
+
+	return result.RowsAffected()
+}
+
+// StartEventPurge removes expired events every `interval` until the process exits
+func StartEventPurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeExpiredEvents(); err != nil {
+				log.Printf("Error purging expired events: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	forwardSubjectPrefix = "Fwd: "
+
+	// Ends the body of a forward whose quote was cut to fit in `MaxBodyLength`
+	forwardTruncatedMarker = "\n\n[The forwarded message was too long and has been truncated.]"
+)
+
+// ForwardConvo starts a new thread from the user to `recipient` containing a quoted copy of a convo and its attachments.
+// The original thread is left untouched, so the new recipient does not gain access to it.
+func ForwardConvo(userId, convoId string, recipient int, note string) (*Convo, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	original, err := LookupConvo(userId, convoId)
+	if err != nil {
+		return nil, err
+	}
+
+	original.Attachments, err = getAttachments(original.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sender may not have a row in `users`, since API keys are not checked against it
+	var sentAt time.Time
+	var senderName string
+	err = db.QueryRow(`
+		SELECT c.created_at, COALESCE(u.fullname, '')
+		FROM convos AS c
+		LEFT JOIN users AS u ON u.id = c.sender_id
+		WHERE c.id = $1
+	`, original.Id).Scan(&sentAt, &senderName)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	// Attachments are shared with the original rather than copied in the blob store
+	var attachments []*Attachment
+	for _, a := range original.Attachments {
+		attachments = append(attachments, &Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			StorageKey:  a.StorageKey,
+		})
+	}
+
+	forward := &Convo{
+		Recipient:   recipient,
+		Subject:     forwardSubject(original.Subject),
+		Body:        forwardBody(original, senderName, sentAt, note),
+		Attachments: attachments,
+	}
+
+	return CreateConvo(userId, forward)
+}
+
+func forwardSubject(subject string) string {
+	if !strings.HasPrefix(subject, forwardSubjectPrefix) {
+		subject = forwardSubjectPrefix + subject
+	}
+
+	if runes := []rune(subject); len(runes) > MaxSubjectLength {
+		subject = string(runes[:MaxSubjectLength])
+	}
+
+	return subject
+}
+
+func forwardBody(original *Convo, senderName string, sentAt time.Time, note string) string {
+	from := fmt.Sprintf("user %d", original.Sender)
+	if senderName != "" {
+		from = fmt.Sprintf("%s (user %d)", senderName, original.Sender)
+	}
+
+	header := strings.Join([]string{
+		"---------- Forwarded message ----------",
+		"From: " + from,
+		"Date: " + sentAt.UTC().Format(time.RFC1123),
+		"Subject: " + original.Subject,
+		"",
+	}, "\n") + "\n"
+
+	if note != "" {
+		header = note + "\n\n" + header
+	}
+
+	quote := "> " + strings.Replace(original.Body, "\n", "\n> ", -1)
+
+	// Quoting makes the body longer than the original, so the end of the quote is cut rather than failing validation.
+	// A note which is too long on its own still does.
+	if available := MaxBodyLength - utf8.RuneCountInString(header); utf8.RuneCountInString(quote) > available {
+		keep := available - utf8.RuneCountInString(forwardTruncatedMarker)
+		if keep < 0 {
+			keep = 0
+		}
+
+		quote = string([]rune(quote)[:keep]) + forwardTruncatedMarker
+	}
+
+	return header + quote
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+)
+
+const (
+	// Postgres error code for a violated unique constraint
+	uniqueViolation = "23505"
+
+	MaxIdempotencyKeyLength = 255
+)
+
+// IdempotencyKey identifies a request which clients may retry. Retrying a request with the same key and
+// fingerprint (a hash of the request) returns the original result instead of creating a duplicate.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string
+}
+
+// CreateConvoWithIdempotencyKey creates a convo like `CreateConvo`. If `key` was already used to create a convo
+// within `IdempotencyKeyRetention`, the stored convo is returned instead and `replayed` is true.
+func CreateConvoWithIdempotencyKey(userId string, convo *Convo, key *IdempotencyKey) (c *Convo, replayed bool, err error) {
+	db, err := DB()
+	if err != nil {
+		return nil, false, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	if key != nil && len(key.Key) > MaxIdempotencyKeyLength {
+		return nil, false, errgo.WithCausef(nil, ErrInvalid, "The idempotency key must be at most %d characters.", MaxIdempotencyKeyLength)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if key != nil {
+		c, err = getIdempotentConvo(tx, userId, key)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if c != nil {
+			return c, true, nil
+		}
+	}
+
+	if err = convo.Validate(userId); err != nil {
+		return nil, false, err
+	}
+
+	c, err = insertConvo(tx, userId, convo, UndoSendDelay)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if key != nil {
+		if err = saveIdempotentConvo(tx, userId, key, c); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return c, false, nil
+}
+
+// getIdempotentConvo returns the convo previously created with the key, or nil if the key is new (or has expired)
+func getIdempotentConvo(tx *sql.Tx, userId string, key *IdempotencyKey) (*Convo, error) {
+	_, err := tx.Exec(`
+		DELETE
+		FROM idempotency_keys
+		WHERE user_id = $1
+		AND key = $2
+		AND created_at <= now() - $3 * INTERVAL '1 millisecond'
+	`, userId, key.Key, int64(IdempotencyKeyRetention/time.Millisecond))
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowDelete, "Error deleting expired idempotency key")
+	}
+
+	var fingerprint, response string
+	err = tx.QueryRow(`
+		SELECT fingerprint, response
+		FROM idempotency_keys
+		WHERE user_id = $1
+		AND key = $2
+	`, userId, key.Key).Scan(&fingerprint, &response)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	if fingerprint != key.Fingerprint {
+		return nil, errgo.WithCausef(nil, ErrConflict, "The idempotency key '%s' was already used for a different request.", key.Key)
+	}
+
+	c := &Convo{}
+	if err := json.Unmarshal([]byte(response), c); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error decoding stored response")
+	}
+
+	return c, nil
+}
+
+func saveIdempotentConvo(tx *sql.Tx, userId string, key *IdempotencyKey, c *Convo) error {
+	_, err := tx.Exec(`
+		INSERT INTO
+		idempotency_keys (user_id, key, fingerprint, response)
+		VALUES ($1, $2, $3, $4)
+	`, userId, key.Key, key.Fingerprint, c.ToJson())
+
+	// Another request with the same key got there first, and hasn't finished yet
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		return errgo.WithCausef(err, ErrConflict, "A request with the idempotency key '%s' is already in progress.", key.Key)
+	}
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error saving idempotency key")
+	}
+
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys removes keys older than `IdempotencyKeyRetention`
+func PurgeExpiredIdempotencyKeys() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM idempotency_keys
+		WHERE created_at <= now() - $1 * INTERVAL '1 millisecond'
+	`, int64(IdempotencyKeyRetention/time.Millisecond))
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting expired idempotency keys")
+	}
+
+	return result.RowsAffected()
+}
+
+// StartIdempotencyKeyPurge removes expired idempotency keys every `interval` until the process exits
+func StartIdempotencyKeyPurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeExpiredIdempotencyKeys(); err != nil {
+				log.Printf("Error purging expired idempotency keys: %v\n", err)
+			}
+		}
+	}()
+}
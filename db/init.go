@@ -4,12 +4,41 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/juju/errgo"
 )
 
 var (
 	dbSession *sql.DB
+
+	// Kept for the event listener, which needs a connection of its own
+	dbConnParams string
+
+	// How long a newly created convo stays hidden from its recipient, so that the sender can undo it
+	UndoSendDelay time.Duration = 0
+
+	// How long the sender of a convo is allowed to edit it for
+	EditWindow time.Duration = 0
+
+	// How long a retried request with the same idempotency key returns the original result
+	IdempotencyKeyRetention time.Duration = 24 * time.Hour
+
+	// How long events are kept, i.e. how long a stream of events can be resumed after it was interrupted
+	EventRetention time.Duration = 24 * time.Hour
+
+	// How long deleted convos are kept as tombstones, i.e. how long a client can go without syncing before having to
+	// sync again from scratch
+	TombstoneRetention time.Duration = 30 * 24 * time.Hour
+
+	// How long webhook deliveries are kept after they were delivered or failed
+	WebhookDeliveryRetention time.Duration = 7 * 24 * time.Hour
+
+	// Largest total size of all the attachments a user has sent, in bytes
+	AttachmentQuota int64 = 100 << 20
+
+	// How long email notifications are kept after they were sent, which must be longer than the digest window
+	EmailNotificationRetention time.Duration = 24 * time.Hour
 )
 
 func Initialize(dbName string) {
@@ -27,6 +56,7 @@ func Connect(dbName string) (*sql.DB, error) {
 		log.Fatal(err)
 	} else {
 		dbSession = db
+		dbConnParams = connParams
 	}
 
 	return db, err
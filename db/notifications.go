@@ -0,0 +1,200 @@
+package db
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Every insert into `convo_events` sends a notification on the `convo_events` channel (see the `convo_events_notify`
+// trigger), which is delivered to every server instance once the transaction commits. Each instance listens on the
+// channel and wakes up the streams of the user the event is for, wherever the change was made.
+
+const eventsChannel = "convo_events"
+
+var (
+	// How long the event listener waits before reconnecting after losing its connection, doubling after each failed
+	// attempt up to the maximum
+	ListenerMinReconnectInterval time.Duration = time.Second
+	ListenerMaxReconnectInterval time.Duration = time.Minute
+
+	// How often the listener checks that its connection is still alive when no notification is received
+	listenerPingInterval = 90 * time.Second
+
+	subscribers = &eventSubscribers{users: map[string]map[chan struct{}]bool{}}
+
+	// The listener is only started once a stream subscribes, so instances which never serve one don't keep a
+	// connection open for it
+	eventListener struct {
+		sync.Mutex
+		listener *pq.Listener
+	}
+)
+
+type eventNotification struct {
+	User      int       `json:"user"`
+	VisibleAt time.Time `json:"visible_at"`
+}
+
+type eventSubscribers struct {
+	mu    sync.Mutex
+	users map[string]map[chan struct{}]bool
+}
+
+// SubscribeEvents returns a channel which receives a value whenever the user may have new events, to be fetched with
+// `GetEvents`, and a function to call once done with it. Several notifications may be merged into a single value.
+// The event listener is started if it is not running yet.
+func SubscribeEvents(userId string) (<-chan struct{}, func()) {
+	startEventListenerOnce()
+
+	ch := make(chan struct{}, 1)
+
+	subscribers.mu.Lock()
+	if subscribers.users[userId] == nil {
+		subscribers.users[userId] = map[chan struct{}]bool{}
+	}
+	subscribers.users[userId][ch] = true
+	subscribers.mu.Unlock()
+
+	return ch, func() {
+		subscribers.mu.Lock()
+		delete(subscribers.users[userId], ch)
+		if len(subscribers.users[userId]) == 0 {
+			delete(subscribers.users, userId)
+		}
+		subscribers.mu.Unlock()
+	}
+}
+
+func (s *eventSubscribers) notify(userId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.users[userId] {
+		wake(ch)
+	}
+}
+
+func (s *eventSubscribers) notifyAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, chs := range s.users {
+		for ch := range chs {
+			wake(ch)
+		}
+	}
+}
+
+// wake sends a value unless one is already waiting to be received
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// handleNotification wakes up the streams of the user an event is for, once the user can see it
+func handleNotification(payload string) {
+	var n eventNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Printf("Invalid event notification %q: %v\n", payload, err)
+		return
+	}
+
+	userId := strconv.Itoa(n.User)
+	if delay := n.VisibleAt.Sub(time.Now()); delay > 0 {
+		time.AfterFunc(delay, func() { subscribers.notify(userId) })
+		return
+	}
+
+	subscribers.notify(userId)
+}
+
+// StartEventListener listens for the notifications sent by every server instance, and returns a function which
+// stops listening. It returns once listening, or right away if a listener is already running.
+// The connection is re-established whenever it drops; since notifications sent in the meantime are lost, every
+// subscriber is then woken up to check for events.
+func StartEventListener() func() {
+	eventListener.Lock()
+	if listener := eventListener.listener; listener != nil {
+		eventListener.Unlock()
+		return func() { stopEventListener(listener) }
+	}
+
+	listener := pq.NewListener(dbConnParams, ListenerMinReconnectInterval, ListenerMaxReconnectInterval,
+		func(event pq.ListenerEventType, err error) {
+			switch event {
+			case pq.ListenerEventDisconnected:
+				log.Printf("Event listener disconnected: %v\n", err)
+			case pq.ListenerEventConnectionAttemptFailed:
+				log.Printf("Event listener failed to reconnect: %v\n", err)
+			case pq.ListenerEventReconnected:
+				log.Println("Event listener reconnected")
+			}
+		},
+	)
+	eventListener.listener = listener
+	eventListener.Unlock()
+
+	stop := func() { stopEventListener(listener) }
+
+	go func() {
+		for {
+			select {
+			case n, ok := <-listener.Notify:
+				// Closed once the listener is stopped
+				if !ok {
+					return
+				}
+
+				// A nil notification is sent after reconnecting
+				if n == nil {
+					subscribers.notifyAll()
+					continue
+				}
+
+				handleNotification(n.Extra)
+			case <-time.After(listenerPingInterval):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	// Blocks until connected
+	if err := listener.Listen(eventsChannel); err != nil {
+		log.Printf("Error listening for events: %v\n", err)
+		return stop
+	}
+
+	// Streams which subscribed before the listener was connected may have missed a notification
+	subscribers.notifyAll()
+
+	return stop
+}
+
+// startEventListenerOnce starts the listener in the background unless it is already running
+func startEventListenerOnce() {
+	eventListener.Lock()
+	running := eventListener.listener != nil
+	eventListener.Unlock()
+
+	if !running {
+		go StartEventListener()
+	}
+}
+
+func stopEventListener(listener *pq.Listener) {
+	eventListener.Lock()
+	if eventListener.listener == listener {
+		eventListener.listener = nil
+	}
+	eventListener.Unlock()
+
+	// Fails if it was already stopped, which is fine
+	listener.Close()
+}
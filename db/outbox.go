@@ -0,0 +1,513 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// Domain events are written to `outbox` in the same transaction as the change they describe, so that side effects are
+// not lost if the process stops right after the change is committed. A relay publishes them to the subscribers
+// registered in the process, keeping a checkpoint for each subscriber.
+//
+// Events are only published once the convo is visible to its recipient, and the events of a convo deleted before then,
+// e.g. undone, are never published. Like the versions of changes, positions are only given out by the relay, in the
+// order events became visible, so that no event is committed behind a subscriber's checkpoint.
+
+const (
+	// The most events handled by a subscriber in a single transaction
+	outboxBatchSize = 100
+
+	// The key of the advisory lock taken while giving out positions, and the class of those taken while relaying to a
+	// subscriber
+	outboxLockKey = 48
+)
+
+var (
+	// How many times a subscriber is handed an event it fails to handle before the event is parked, i.e. skipped
+	OutboxMaxAttempts = 8
+
+	// How long a subscriber waits before it is handed a failed event again, doubled after each failed attempt up to
+	// `OutboxMaxRetryDelay`. Later events wait too.
+	OutboxRetryDelay    time.Duration = 5 * time.Second
+	OutboxMaxRetryDelay time.Duration = 10 * time.Minute
+)
+
+// OutboxEvent is a change to a convo, as published to outbox subscribers
+type OutboxEvent struct {
+	Position int64 `json:"-"`
+
+	Type  string         `json:"type"`
+	Time  time.Time      `json:"time"`
+	Convo *ConvoSnapshot `json:"convo"`
+
+	// The user who made the change
+	User int `json:"user"`
+
+	// Only set for `read.changed` events
+	Read *bool `json:"read,omitempty"`
+}
+
+// ConvoSnapshot is a convo as it was when an event happened, without the state of either user
+type ConvoSnapshot struct {
+	Id        int        `json:"id"`
+	Parent    int        `json:"parent"`
+	Sender    int        `json:"sender"`
+	Recipient int        `json:"recipient"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	EditedAt  *time.Time `json:"edited_at"`
+}
+
+// OutboxHandler handles an event for a subscriber. Changes it makes to the database in `tx`, which also moves the
+// subscriber's checkpoint past the event, are made exactly once; other side effects happen at least once, since the
+// process could stop before the checkpoint is committed. On error, the subscriber's changes for the event are rolled
+// back, and it is handled again once `OutboxRetryDelay` has passed, until it is parked after `OutboxMaxAttempts`.
+type OutboxHandler func(tx *sql.Tx, event *OutboxEvent) error
+
+var outboxSubscribers = struct {
+	sync.Mutex
+	handlers map[string]OutboxHandler
+}{handlers: map[string]OutboxHandler{}}
+
+// SubscribeOutbox registers a subscriber under a name, which its checkpoint is saved under. A new subscriber is
+// published the events which become visible after its first relay.
+func SubscribeOutbox(name string, handler OutboxHandler) {
+	outboxSubscribers.Lock()
+	defer outboxSubscribers.Unlock()
+
+	outboxSubscribers.handlers[name] = handler
+}
+
+// snapshotConvo returns a convo as it is in the transaction, along with the time its recipient can see it and the
+// time of the transaction
+func snapshotConvo(tx *sql.Tx, convoId string) (*ConvoSnapshot, time.Time, time.Time, error) {
+	c := &ConvoSnapshot{}
+	var visibleAt, now time.Time
+	err := tx.QueryRow(`
+		SELECT id, parent_id, sender_id, recipient_id, subject, body, edited_at, visible_at, now()
+		FROM convos
+		WHERE id = $1
+	`, convoId).Scan(&c.Id, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.EditedAt, &visibleAt, &now)
+
+	if err != nil {
+		return nil, visibleAt, now, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return c, visibleAt, now, nil
+}
+
+// recordOutboxEvent writes an event about a convo, which must not have been deleted yet, to the outbox
+func recordOutboxEvent(tx *sql.Tx, eventType, userId, convoId string, read *bool) error {
+	c, visibleAt, now, err := snapshotConvo(tx, convoId)
+	if err != nil {
+		return err
+	}
+
+	user, _ := strconv.Atoi(userId)
+	payload, err := json.Marshal(&OutboxEvent{Type: eventType, Time: now, Convo: c, User: user, Read: read})
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error encoding outbox event")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO outbox (type, convo_id, payload, visible_at)
+		VALUES ($1, $2, $3, GREATEST(now(), $4))
+	`, eventType, c.Id, string(payload), visibleAt)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error recording outbox event")
+	}
+
+	return nil
+}
+
+// dropHiddenOutboxEvents removes the events which are not visible yet of convos which have been deleted
+func dropHiddenOutboxEvents(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DELETE
+		FROM outbox AS o
+		WHERE o.position IS NULL
+		AND o.visible_at > now()
+		AND NOT EXISTS (SELECT 1 FROM convos AS c WHERE c.id = o.convo_id)
+	`)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting outbox events")
+	}
+
+	return nil
+}
+
+// RelayOutbox publishes the events which became visible, then relays every published event to the subscribers which
+// have not handled it yet
+func RelayOutbox() error {
+	if err := publishOutboxEvents(); err != nil {
+		return err
+	}
+
+	outboxSubscribers.Lock()
+	handlers := map[string]OutboxHandler{}
+	for name, handler := range outboxSubscribers.handlers {
+		handlers[name] = handler
+	}
+	outboxSubscribers.Unlock()
+
+	for name, handler := range handlers {
+		for {
+			more, err := relayOutboxBatch(name, handler)
+			if err != nil {
+				log.Printf("Error relaying outbox events to %s: %v\n", name, err)
+			}
+
+			if err != nil || !more {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// publishOutboxEvents gives positions to the visible events which do not have one yet
+func publishOutboxEvents() (err error) {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", outboxLockKey); err != nil {
+		return errgo.WithCausef(err, ErrTransaction, "Error locking outbox")
+	}
+
+	// The latest event is never purged, so positions keep increasing
+	_, err = tx.Exec(`
+		UPDATE outbox AS o
+		SET position = m.position + p.n
+		FROM (SELECT COALESCE(MAX(position), 0) AS position FROM outbox) AS m, (
+			SELECT id, row_number() OVER (ORDER BY visible_at, id) AS n
+			FROM outbox
+			WHERE position IS NULL AND visible_at <= now()
+		) AS p
+		WHERE o.id = p.id
+	`)
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error publishing outbox events")
+	}
+
+	return nil
+}
+
+// relayOutboxBatch hands the next published events to a subscriber, and moves its checkpoint past those it handled.
+// It returns whether there may be more events to relay.
+func relayOutboxBatch(name string, handler OutboxHandler) (more bool, err error) {
+	db, err := DB()
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Only one server relays to a subscriber at a time
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", outboxLockKey, name); err != nil {
+		return false, errgo.WithCausef(err, ErrTransaction, "Error locking outbox checkpoint")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO outbox_checkpoints (subscriber, position)
+		SELECT $1, COALESCE(MAX(position), 0)
+		FROM outbox
+		WHERE NOT EXISTS (SELECT 1 FROM outbox_checkpoints WHERE subscriber = $1)
+	`, name)
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowCreate, "Error creating outbox checkpoint")
+	}
+
+	var checkpoint int64
+	if err := tx.QueryRow("SELECT position FROM outbox_checkpoints WHERE subscriber = $1", name).Scan(&checkpoint); err != nil {
+		return false, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	// The subscriber waits after failing to handle the next event
+	failure, err := getOutboxFailure(tx, name)
+	if err != nil {
+		return false, err
+	}
+
+	if failure != nil && failure.waiting {
+		return false, nil
+	}
+
+	events, err := getOutboxEvents(tx, checkpoint)
+	if err != nil {
+		return false, err
+	}
+
+	more = len(events) == outboxBatchSize
+	for _, event := range events {
+		if _, err := tx.Exec("SAVEPOINT outbox_event"); err != nil {
+			return false, errgo.WithCausef(err, ErrTransaction, "Error creating savepoint")
+		}
+
+		retried := failure != nil && failure.position == event.Position
+		if handlerErr := handler(tx, event); handlerErr != nil {
+			log.Printf("Error handling outbox event %d for %s: %v\n", event.Position, name, handlerErr)
+
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT outbox_event"); err != nil {
+				return false, errgo.WithCausef(err, ErrTransaction, "Error rolling back to savepoint")
+			}
+
+			attempts := 1
+			if retried {
+				attempts = failure.attempts + 1
+			}
+
+			parked, err := recordOutboxFailure(tx, name, event.Position, attempts, handlerErr)
+			if err != nil {
+				return false, err
+			}
+
+			// Later events wait, so that a subscriber handles events in order, unless the event is given up on
+			if !parked {
+				more = false
+				break
+			}
+
+			log.Printf("Parked outbox event %d for %s after %d attempts\n", event.Position, name, attempts)
+		} else {
+			if _, err := tx.Exec("RELEASE SAVEPOINT outbox_event"); err != nil {
+				return false, errgo.WithCausef(err, ErrTransaction, "Error releasing savepoint")
+			}
+
+			if retried {
+				if err := deleteOutboxFailure(tx, name, event.Position); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		checkpoint = event.Position
+	}
+
+	_, err = tx.Exec(`
+		UPDATE outbox_checkpoints
+		SET position = $2, updated_at = now()
+		WHERE subscriber = $1
+	`, name, checkpoint)
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowUpdate, "Error updating outbox checkpoint")
+	}
+
+	return more, nil
+}
+
+// outboxFailure is an event a subscriber failed to handle, and has not given up on yet
+type outboxFailure struct {
+	position int64
+	attempts int
+
+	// Whether it is too early to hand the event to the subscriber again
+	waiting bool
+}
+
+// getOutboxFailure returns the event the subscriber failed to handle last, if it has not handled it since. Since
+// events are handled in order, there is at most one.
+func getOutboxFailure(tx *sql.Tx, name string) (*outboxFailure, error) {
+	f := &outboxFailure{}
+	err := tx.QueryRow(`
+		SELECT position, attempts, next_attempt_at > now()
+		FROM outbox_failures
+		WHERE subscriber = $1
+		AND parked_at IS NULL
+	`, name).Scan(&f.position, &f.attempts, &f.waiting)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return f, nil
+}
+
+// recordOutboxFailure saves a failed attempt at handling an event, and parks the event once the subscriber has made
+// `OutboxMaxAttempts`. It returns whether the event was parked.
+func recordOutboxFailure(tx *sql.Tx, name string, position int64, attempts int, handlerErr error) (bool, error) {
+	parked := attempts >= OutboxMaxAttempts
+	retryIn := int64(outboxRetryDelay(attempts) / time.Millisecond)
+
+	result, err := tx.Exec(`
+		UPDATE outbox_failures
+		SET attempts = $3, last_error = $4,
+		next_attempt_at = CASE WHEN $6 THEN NULL ELSE now() + $5 * INTERVAL '1 millisecond' END,
+		parked_at = CASE WHEN $6 THEN now() END
+		WHERE subscriber = $1
+		AND position = $2
+	`, name, position, attempts, handlerErr.Error(), retryIn, parked)
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowUpdate, "Error updating outbox failure")
+	}
+
+	// The subscriber is locked, so the failure cannot be recorded in the meantime
+	if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+		return parked, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+		outbox_failures (subscriber, position, payload, attempts, last_error, next_attempt_at, parked_at)
+		SELECT $1, position, payload, $3, $4,
+		CASE WHEN $6 THEN NULL ELSE now() + $5 * INTERVAL '1 millisecond' END,
+		CASE WHEN $6 THEN now() END
+		FROM outbox
+		WHERE position = $2
+	`, name, position, attempts, handlerErr.Error(), retryIn, parked)
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowCreate, "Error recording outbox failure")
+	}
+
+	return parked, nil
+}
+
+// deleteOutboxFailure forgets the failed attempts at an event once the subscriber has handled it
+func deleteOutboxFailure(tx *sql.Tx, name string, position int64) error {
+	_, err := tx.Exec(`
+		DELETE
+		FROM outbox_failures
+		WHERE subscriber = $1
+		AND position = $2
+	`, name, position)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting outbox failure")
+	}
+
+	return nil
+}
+
+// outboxRetryDelay returns how long to wait before handing an event to a subscriber again, after `attempts` failed
+// attempts
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := OutboxRetryDelay
+	for i := 1; i < attempts && delay < OutboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > OutboxMaxRetryDelay {
+		return OutboxMaxRetryDelay
+	}
+
+	return delay
+}
+
+func getOutboxEvents(tx *sql.Tx, after int64) ([]*OutboxEvent, error) {
+	rows, err := tx.Query(`
+		SELECT position, payload
+		FROM outbox
+		WHERE position > $1
+		ORDER BY position
+		LIMIT $2
+	`, after, outboxBatchSize)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving outbox events")
+	}
+	defer rows.Close()
+
+	var events []*OutboxEvent
+	for rows.Next() {
+		var position int64
+		var payload string
+		if err := rows.Scan(&position, &payload); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		e := &OutboxEvent{}
+		if err := json.Unmarshal([]byte(payload), e); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error decoding outbox event")
+		}
+		e.Position = position
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return events, nil
+}
+
+// PurgeRelayedOutboxEvents removes the events which every subscriber has handled. The checkpoint of a subscriber which
+// is no longer registered must be deleted, or its events are kept forever.
+func PurgeRelayedOutboxEvents() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM outbox
+		WHERE position <= COALESCE((SELECT MIN(position) FROM outbox_checkpoints), (SELECT MAX(position) FROM outbox))
+		AND position < (SELECT MAX(position) FROM outbox)
+	`)
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting relayed outbox events")
+	}
+
+	return result.RowsAffected()
+}
+
+// StartOutboxRelay relays outbox events to subscribers every `interval` until the process exits
+func StartOutboxRelay(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := RelayOutbox(); err != nil {
+				log.Printf("Error relaying outbox events: %v\n", err)
+			}
+		}
+	}()
+}
+
+// StartOutboxPurge removes relayed outbox events every `interval` until the process exits
+func StartOutboxPurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeRelayedOutboxEvents(); err != nil {
+				log.Printf("Error purging relayed outbox events: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"strconv"
+)
+
+// PrepareReply makes `reply` a reply from the user to a thread they can see: it takes the subject of the thread, and
+// goes to whoever is on the other end of the convo being replied to. A recipient given in `reply` must be that person.
+func PrepareReply(userId, parentId string, reply *Convo) error {
+	parent, err := LookupConvo(userId, parentId)
+	if err != nil {
+		return err
+	}
+
+	recipient := parent.Sender
+	if strconv.Itoa(parent.Sender) == userId {
+		recipient = parent.Recipient
+	}
+
+	if reply.Recipient != 0 && reply.Recipient != recipient {
+		verr := &ValidationError{}
+		verr.add("recipient", "invalid", "A reply can only be sent to the other user of the convo.")
+		return verr
+	}
+
+	reply.Parent = parent.Id
+	reply.Recipient = recipient
+	reply.Subject = parent.Subject
+
+	return nil
+}
+
+// Reply adds a reply from the user to a thread, as prepared by `PrepareReply`, and is how every API replies to a
+// convo. Only the body, attachments and recipient of `reply` are used. Like `CreateConvoWithIdempotencyKey`, it
+// returns whether the reply was created by an earlier request with the same key.
+func Reply(userId, parentId string, reply *Convo, key *IdempotencyKey) (*Convo, bool, error) {
+	if err := PrepareReply(userId, parentId, reply); err != nil {
+		return nil, false, err
+	}
+
+	return CreateConvoWithIdempotencyKey(userId, reply, key)
+}
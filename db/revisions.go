@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+type Revision struct {
+	Id        int       `json:"id"`
+	Convo     int       `json:"convo"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	RevisedAt time.Time `json:"revised_at"`
+}
+
+// GetConvoRevisions returns the previous versions of a convo, oldest first
+func GetConvoRevisions(userId, convoId string) ([]*Revision, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	// Revisions are only visible to those who can see the convo itself
+	if _, err := LookupConvo(userId, convoId); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, convo_id, subject, body, revised_at
+		FROM convo_revisions
+		WHERE convo_id = $1
+		ORDER BY revised_at, id
+	`, convoId)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving revisions")
+	}
+	defer rows.Close()
+
+	var rs []*Revision
+	for rows.Next() {
+		r := &Revision{}
+		if err := rows.Scan(&r.Id, &r.Convo, &r.Subject, &r.Body, &r.RevisedAt); err != nil {
+			return rs, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		rs = append(rs, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return rs, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return rs, nil
+}
+
+// editConvo replaces the subject and body of a convo as part of an existing transaction, keeping the previous
+// version as a revision.
+// Only the sender may edit a convo, and only within `EditWindow` of sending it.
+func editConvo(tx *sql.Tx, userId string, convo *Convo, subject, body string) (*time.Time, error) {
+	if strconv.Itoa(convo.Sender) != userId {
+		return nil, errgo.WithCausef(nil, ErrForbidden, "Only the sender can edit convo with id '%d'.", convo.Id)
+	}
+
+	// Replies share the subject of the thread
+	isRoot := convo.Id == convo.Parent
+	if subject != convo.Subject && !isRoot {
+		return nil, errgo.WithCausef(nil, ErrInvalid, "The subject can only be edited on the first convo of a thread.")
+	}
+
+	verr := &ValidationError{}
+	verr.validateText("subject", subject, MaxSubjectLength)
+	verr.validateText("body", body, MaxBodyLength)
+	if err := verr.orNil(); err != nil {
+		return nil, err
+	}
+
+	var editable bool
+	err := tx.QueryRow(`
+		SELECT created_at > now() - $2 * INTERVAL '1 millisecond'
+		FROM convos
+		WHERE id = $1
+		FOR UPDATE
+	`, convo.Id, int64(EditWindow/time.Millisecond)).Scan(&editable)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	if !editable {
+		return nil, errgo.WithCausef(nil, ErrForbidden, "Convo with id '%d' can no longer be edited.", convo.Id)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+		convo_revisions (convo_id, subject, body)
+		SELECT id, subject, body
+		FROM convos
+		WHERE id = $1
+	`, convo.Id)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowCreate, "Error saving revision")
+	}
+
+	editedAt := &time.Time{}
+	err = tx.QueryRow(`
+		UPDATE convos
+		SET subject = $2, body = $3, edited_at = now()
+		WHERE id = $1
+		RETURNING edited_at
+	`, convo.Id, subject, body).Scan(editedAt)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error editing convo")
+	}
+
+	if subject != convo.Subject {
+		_, err = tx.Exec(`
+			UPDATE convos
+			SET subject = $2
+			WHERE parent_id = $1
+			AND id != $1
+		`, convo.Id, subject)
+
+		if err != nil {
+			return nil, errgo.WithCausef(err, ErrRowUpdate, "Error updating subject of replies")
+		}
+	}
+
+	return editedAt, nil
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+type ScheduledConvo struct {
+	Id        int       `json:"id"`
+	Sender    int       `json:"sender"`
+	Recipient int       `json:"recipient"`
+	Parent    int       `json:"parent"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	SendAt    time.Time `json:"send_at"`
+}
+
+func scanScheduledConvo(row interface {
+	Scan(dest ...interface{}) error
+}) (*ScheduledConvo, error) {
+	s := &ScheduledConvo{}
+	err := row.Scan(&s.Id, &s.Parent, &s.Sender, &s.Recipient, &s.Subject, &s.Body, &s.SendAt)
+	return s, err
+}
+
+func GetScheduledConvos(userId string) ([]*ScheduledConvo, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, COALESCE(parent_id, 0), sender_id, recipient_id, subject, body, send_at
+		FROM scheduled_convos
+		WHERE sender_id = $1
+		AND delivered_at IS NULL
+		ORDER BY send_at, id
+	`, userId)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving scheduled convos")
+	}
+	defer rows.Close()
+
+	var ss []*ScheduledConvo
+	for rows.Next() {
+		s, err := scanScheduledConvo(rows)
+		if err != nil {
+			return ss, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		ss = append(ss, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ss, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return ss, nil
+}
+
+// ScheduleConvo stores a convo to be sent at `convo.SendAt` instead of creating it right away.
+// Access to the parent thread (if any) is expected to have been checked by the caller.
+func ScheduleConvo(userId string, convo *Convo) (*ScheduledConvo, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	if convo.SendAt == nil {
+		return nil, errgo.WithCausef(nil, ErrInvalid, "Scheduled convos require a `send_at` time")
+	}
+
+	if err := convo.Validate(userId); err != nil {
+		return nil, err
+	}
+
+	s, err := scanScheduledConvo(db.QueryRow(`
+		INSERT INTO
+		scheduled_convos (parent_id, sender_id, recipient_id, subject, body, send_at)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6)
+		RETURNING id, COALESCE(parent_id, 0), sender_id, recipient_id, subject, body, send_at
+	`, convo.Parent, userId, convo.Recipient, convo.Subject, convo.Body, *convo.SendAt))
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowCreate, "Error scheduling conversation")
+	}
+
+	return s, nil
+}
+
+func RescheduleConvo(userId, scheduledId string, sendAt time.Time) (*ScheduledConvo, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	s, err := scanScheduledConvo(db.QueryRow(`
+		UPDATE scheduled_convos
+		SET send_at = $3
+		WHERE id = $1
+		AND sender_id = $2
+		AND delivered_at IS NULL
+		RETURNING id, COALESCE(parent_id, 0), sender_id, recipient_id, subject, body, send_at
+	`, scheduledId, userId, sendAt))
+
+	if err == sql.ErrNoRows {
+		return nil, errgo.WithCausef(err, ErrNoRows, "Unable to find scheduled convo with id '%s'.", scheduledId)
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error rescheduling convo.")
+	}
+
+	return s, nil
+}
+
+func CancelScheduledConvo(userId, scheduledId string) error {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM scheduled_convos
+		WHERE id = $1
+		AND sender_id = $2
+		AND delivered_at IS NULL
+	`, scheduledId, userId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error cancelling scheduled convo.")
+	}
+
+	count, err := result.RowsAffected()
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error cancelling scheduled convo.")
+	}
+
+	if count == 0 {
+		return errgo.WithCausef(err, ErrNoRows, "Unable to find scheduled convo with id '%s'.", scheduledId)
+	}
+
+	return nil
+}
+
+// DeliverScheduledConvos turns every due scheduled convo into a real convo and returns how many were sent.
+// A convo which fails to be delivered is logged and left pending so it doesn't hold up the others.
+func DeliverScheduledConvos() (int, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		SELECT id
+		FROM scheduled_convos
+		WHERE delivered_at IS NULL
+		AND send_at <= now()
+		ORDER BY send_at, id
+	`)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving scheduled convos")
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	count := 0
+	for _, id := range ids {
+		delivered, err := deliverScheduledConvo(id)
+		if err != nil {
+			log.Printf("Error delivering scheduled convo %d: %v\n", id, err)
+			continue
+		}
+
+		if delivered {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// deliverScheduledConvo creates the convo for a single scheduled convo.
+//
+// The scheduled row is locked with `FOR UPDATE` and marked as delivered in the same transaction that creates
+// the convo. If several servers try to deliver it at once, the others block on the lock and then find
+// `delivered_at` already set, so each message is only ever sent once.
+func deliverScheduledConvo(scheduledId int) (delivered bool, err error) {
+	db, err := DB()
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	s, err := scanScheduledConvo(tx.QueryRow(`
+		SELECT id, COALESCE(parent_id, 0), sender_id, recipient_id, subject, body, send_at
+		FROM scheduled_convos
+		WHERE id = $1
+		AND delivered_at IS NULL
+		FOR UPDATE
+	`, scheduledId))
+
+	if err == sql.ErrNoRows {
+		// Delivered (or cancelled) by someone else in the meantime
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	convo := &Convo{Parent: s.Parent, Recipient: s.Recipient, Subject: s.Subject, Body: s.Body}
+	c, err := insertConvo(tx, strconv.Itoa(s.Sender), convo, 0)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE scheduled_convos
+		SET delivered_at = now(), convo_id = $2
+		WHERE id = $1
+	`, s.Id, c.Id)
+
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowUpdate, "Error marking scheduled convo as delivered")
+	}
+
+	return true, nil
+}
+
+// StartScheduledDelivery delivers due scheduled convos every `interval` until the process exits
+func StartScheduledDelivery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			count, err := DeliverScheduledConvos()
+			if err != nil {
+				log.Printf("Error delivering scheduled convos: %v\n", err)
+				continue
+			}
+
+			if count > 0 {
+				log.Printf("Delivered %d scheduled convos\n", count)
+			}
+		}
+	}()
+}
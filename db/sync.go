@@ -0,0 +1,388 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+)
+
+// Changes are recorded in `convo_changes`, in the same transaction as the change they describe, as a single row for
+// each user and convo which is marked as changed again on every change. A recipient's row only becomes visible along
+// with the convo, once it can no longer be undone. Deleted convos are kept as tombstones.
+//
+// Changed rows are left without a version: versions are only given out by `Sync`, one user at a time, in the order the
+// rows became visible. A change committed after a sync is then always given a higher version than every change that
+// sync returned, which would not hold for versions given out when the change is made, since transactions do not
+// commit in the order they started.
+
+const (
+	// The most changes returned by a single call to `Sync`
+	maxChangesPerPage = 100
+
+	// The class of the advisory lock taken on the changes of a user, whose id is the other half of the key
+	syncLockClass = 46
+)
+
+type Change struct {
+	Id      int  `json:"id"`
+	Deleted bool `json:"deleted"`
+
+	// The convo as the user sees it, unless it was deleted
+	Convo *Convo `json:"convo,omitempty"`
+}
+
+type SyncResult struct {
+	// Oldest first
+	Changes []*Change `json:"changes"`
+
+	// Opaque; changes after those are returned by `Sync(userId, token)`
+	Token string `json:"token"`
+
+	// Whether there are more changes than a single page, to be fetched right away with the new token
+	More bool `json:"more"`
+}
+
+// Sync returns the changes to the convos the user can see since the sync which returned `token`. Without a token,
+// every convo the user can see is returned, without tombstones.
+func Sync(userId, token string) (result *SyncResult, err error) {
+	var since int64
+	if token != "" {
+		if since, err = strconv.ParseInt(token, 10, 64); err != nil || since < 0 {
+			return nil, errgo.WithCausef(nil, ErrInvalid, "Invalid sync token '%s'.", token)
+		}
+	}
+
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if err := lockChanges(tx, userId); err != nil {
+		return nil, err
+	}
+
+	latest, purged, err := assignChangeVersions(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if since > latest {
+		return nil, errgo.WithCausef(nil, ErrInvalid, "Invalid sync token '%s'.", token)
+	}
+
+	// Tombstones the client may not have received are gone
+	if since != 0 && since < purged {
+		return nil, errgo.WithCausef(nil, ErrGone, "Sync token '%s' has expired. Sync again without a token.", token)
+	}
+
+	// Every change up to the latest one is returned, unless there is more than a page of them
+	result = &SyncResult{Changes: []*Change{}, Token: strconv.FormatInt(latest, 10)}
+
+	rows, err := tx.Query(`
+		SELECT
+		ch.convo_id, ch.version, ch.deleted OR c.id IS NULL, COALESCE(c.parent_id, 0), COALESCE(c.sender_id, 0),
+		COALESCE(c.recipient_id, 0), COALESCE(c.subject, ''), COALESCE(c.body, ''), r.user_id is not null,
+		a.user_id is not null, c.edited_at, CASE WHEN c.visible_at > now() THEN c.visible_at END
+		FROM convo_changes AS ch
+		LEFT JOIN convos AS c ON c.id = ch.convo_id
+		LEFT JOIN read_status AS r ON r.thread_id = ch.convo_id AND r.user_id = ch.user_id
+		LEFT JOIN archive_status AS a ON a.thread_id = ch.convo_id AND a.user_id = ch.user_id
+		WHERE ch.user_id = $1
+		AND ch.version > $2
+		AND NOT ($2 = 0 AND ch.deleted)
+		ORDER BY ch.version
+		LIMIT $3
+	`, userId, since, maxChangesPerPage+1)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving changes")
+	}
+	defer rows.Close()
+
+	var convoIds []int
+	convos := map[int]*Convo{}
+	for rows.Next() {
+		if len(result.Changes) == maxChangesPerPage {
+			result.More = true
+			break
+		}
+
+		var version int64
+		ch := &Change{}
+		c := &Convo{}
+		if err := rows.Scan(&ch.Id, &version, &ch.Deleted, &c.Parent, &c.Sender, &c.Recipient, &c.Subject, &c.Body, &c.Read, &c.Archived, &c.EditedAt, &c.UndoUntil); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		if !ch.Deleted {
+			c.Id = ch.Id
+			ch.Convo = c
+			convoIds = append(convoIds, c.Id)
+			convos[c.Id] = c
+		}
+
+		result.Changes = append(result.Changes, ch)
+		if len(result.Changes) == maxChangesPerPage {
+			result.Token = strconv.FormatInt(version, 10)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+	rows.Close()
+
+	attachments, err := GetAttachmentsByConvo(convoIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, c := range convos {
+		c.Attachments = attachments[id]
+	}
+
+	return result, nil
+}
+
+// lockChanges keeps versions from being given out to the changes of the user, or tombstones from being purged, until
+// the end of the transaction
+func lockChanges(tx *sql.Tx, userId string) error {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2::integer)", syncLockClass, userId); err != nil {
+		return errgo.WithCausef(err, ErrTransaction, "Error locking changes")
+	}
+
+	return nil
+}
+
+// assignChangeVersions gives versions to the visible changes of the user which do not have one yet, and returns the
+// latest version of the user's changes along with the latest version of the purged tombstones
+func assignChangeVersions(tx *sql.Tx, userId string) (int64, int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO sync_versions (user_id)
+		SELECT $1::integer
+		WHERE NOT EXISTS (SELECT 1 FROM sync_versions WHERE user_id = $1::integer)
+	`, userId)
+	if err != nil {
+		return 0, 0, errgo.WithCausef(err, ErrRowCreate, "Error creating sync version")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE convo_changes AS ch
+		SET version = s.version + p.n
+		FROM sync_versions AS s, (
+			SELECT convo_id, row_number() OVER (ORDER BY visible_at, convo_id) AS n
+			FROM convo_changes
+			WHERE user_id = $1 AND version IS NULL AND visible_at <= now()
+		) AS p
+		WHERE s.user_id = $1
+		AND ch.user_id = $1 AND ch.convo_id = p.convo_id
+	`, userId)
+	if err != nil {
+		return 0, 0, errgo.WithCausef(err, ErrRowUpdate, "Error assigning change versions")
+	}
+
+	var version, purged int64
+	err = tx.QueryRow(`
+		UPDATE sync_versions
+		SET version = GREATEST(version, (SELECT MAX(version) FROM convo_changes WHERE user_id = $1))
+		WHERE user_id = $1
+		RETURNING version, purged
+	`, userId).Scan(&version, &purged)
+	if err != nil {
+		return 0, 0, errgo.WithCausef(err, ErrRowUpdate, "Error updating sync version")
+	}
+
+	return version, purged, nil
+}
+
+// recordCreatedChanges records a new convo for its sender, and for its recipient once it is visible
+func recordCreatedChanges(tx *sql.Tx, convoId int) error {
+	_, err := tx.Exec(`
+		INSERT INTO convo_changes (user_id, convo_id, visible_at)
+		SELECT sender_id, id, now()
+		FROM convos WHERE id = $1
+		UNION ALL
+		SELECT recipient_id, id, visible_at
+		FROM convos WHERE id = $1
+	`, convoId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error recording changes")
+	}
+
+	return nil
+}
+
+// recordChanges marks a convo as changed for every user who has it. The recipient of a convo which is not visible
+// yet still gets it once it is.
+func recordChanges(tx *sql.Tx, convoId string) error {
+	_, err := tx.Exec(`
+		UPDATE convo_changes
+		SET version = NULL, visible_at = GREATEST(visible_at, now())
+		WHERE convo_id = $1
+	`, convoId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error recording changes")
+	}
+
+	return nil
+}
+
+// recordUserChange marks a convo as changed for a single user, e.g. when its read status changes
+func recordUserChange(tx *sql.Tx, userId, convoId string) error {
+	_, err := tx.Exec(`
+		UPDATE convo_changes
+		SET version = NULL, visible_at = GREATEST(visible_at, now())
+		WHERE user_id = $1 AND convo_id = $2
+	`, userId, convoId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error recording changes")
+	}
+
+	return nil
+}
+
+// convoSubtree selects the id of convo `$1` and of its replies, nested to any depth, as `subtree`
+const convoSubtree = `
+	WITH RECURSIVE subtree (id) AS (
+		SELECT id FROM convos WHERE id = $1
+		UNION ALL
+		SELECT c.id FROM convos AS c JOIN subtree AS s ON c.parent_id = s.id AND c.id != c.parent_id
+	)
+`
+
+// recordDeletedChanges turns a convo which is about to be deleted, along with all of its replies, into tombstones for
+// the users who could see them, and forgets them for the users who never could, e.g. the recipient of an undone convo
+func recordDeletedChanges(tx *sql.Tx, convoId string) error {
+	_, err := tx.Exec(convoSubtree+`
+		DELETE
+		FROM convo_changes
+		WHERE convo_id IN (SELECT id FROM subtree)
+		AND visible_at > now()
+	`, convoId)
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error recording changes")
+	}
+
+	_, err = tx.Exec(convoSubtree+`
+		UPDATE convo_changes
+		SET version = NULL, deleted = true, visible_at = now()
+		WHERE convo_id IN (SELECT id FROM subtree)
+	`, convoId)
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error recording changes")
+	}
+
+	return nil
+}
+
+// PurgeExpiredTombstones removes the tombstones of convos deleted more than `TombstoneRetention` ago. Syncing from a
+// token older than the purged tombstones then fails, so that clients know to sync again from scratch.
+func PurgeExpiredTombstones() (count int64, err error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	retention := int64(TombstoneRetention / time.Millisecond)
+
+	// Locks are taken in the same order by every purge, and a sync only ever takes one
+	rows, err := tx.Query(`
+		SELECT user_id, pg_advisory_xact_lock($1, user_id)
+		FROM (
+			SELECT DISTINCT user_id
+			FROM convo_changes
+			WHERE deleted AND version IS NOT NULL
+			AND visible_at <= now() - $2 * INTERVAL '1 millisecond'
+			ORDER BY user_id
+		) AS u
+	`, syncLockClass, retention)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrTransaction, "Error locking changes")
+	}
+
+	var userIds []int
+	for rows.Next() {
+		var id int
+		var locked interface{}
+		if err := rows.Scan(&id, &locked); err != nil {
+			rows.Close()
+			return 0, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		userIds = append(userIds, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	if len(userIds) == 0 {
+		return 0, nil
+	}
+
+	// Versions are only given out by `Sync`, which creates the user's row in `sync_versions` first
+	err = tx.QueryRow(`
+		WITH purged AS (
+			DELETE
+			FROM convo_changes
+			WHERE user_id = ANY($1)
+			AND deleted AND version IS NOT NULL
+			AND visible_at <= now() - $2 * INTERVAL '1 millisecond'
+			RETURNING user_id, version
+		), latest AS (
+			UPDATE sync_versions AS s
+			SET purged = p.version
+			FROM (SELECT user_id, MAX(version) AS version FROM purged GROUP BY user_id) AS p
+			WHERE s.user_id = p.user_id AND s.purged < p.version
+		)
+		SELECT COUNT(*) FROM purged
+	`, pq.Array(userIds), retention).Scan(&count)
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting expired tombstones")
+	}
+
+	return count, nil
+}
+
+// StartTombstonePurge removes expired tombstones every `interval` until the process exits
+func StartTombstonePurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeExpiredTombstones(); err != nil {
+				log.Printf("Error purging expired tombstones: %v\n", err)
+			}
+		}
+	}()
+}
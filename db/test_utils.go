@@ -24,6 +24,36 @@ func TruncateTable(name string) error {
 	return nil
 }
 
+// allTables lists every table, in the order the migrations create them. A migration which adds a table must add it
+// here too.
+var allTables = []string{
+	"convos",
+	"users", "read_status",
+	"scheduled_convos",
+	"convo_revisions",
+	"attachments",
+	"idempotency_keys",
+	"archive_status",
+	"convo_events",
+	"convo_changes", "sync_versions",
+	"webhooks", "webhook_deliveries",
+	"outbox", "outbox_checkpoints",
+	"email_opt_outs", "email_notifications",
+	"event_positions",
+	"outbox_failures",
+}
+
+// TruncateAllTables empties every table, latest first so that rows are deleted before those they refer to
+func TruncateAllTables() error {
+	for i := len(allTables) - 1; i >= 0; i-- {
+		if err := TruncateTable(allTables[i]); err != nil {
+			return errgo.WithCausef(err, ErrTruncate, "Error truncating table %s", allTables[i])
+		}
+	}
+
+	return nil
+}
+
 func AddUser(userId, name string) error {
 	db, err := DB()
 	if err != nil {
@@ -40,3 +70,20 @@ func AddUser(userId, name string) error {
 
 	return nil
 }
+
+func SetUserEmail(userId, email string) error {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	_, err = db.Exec(`
+		UPDATE users SET email = $2 WHERE id = $1
+	`, userId, email)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error setting user email.")
+	}
+
+	return nil
+}
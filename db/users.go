@@ -0,0 +1,45 @@
+package db
+
+import (
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+)
+
+type User struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetUsers returns many users at once, by id. Users which do not exist are left out.
+func GetUsers(userIds []int) (map[int]*User, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, COALESCE(fullname, '')
+		FROM users
+		WHERE id = ANY($1)
+	`, pq.Array(userIds))
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving users")
+	}
+	defer rows.Close()
+
+	users := map[int]*User{}
+	for rows.Next() {
+		u := &User{}
+		if err := rows.Scan(&u.Id, &u.Name); err != nil {
+			return users, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		users[u.Id] = u
+	}
+
+	if err := rows.Err(); err != nil {
+		return users, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return users, nil
+}
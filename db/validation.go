@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	MaxSubjectLength = 140
+	MaxBodyLength    = 64000
+)
+
+// FieldError describes why the value of a single field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when an object can't be saved because one or more of its fields are invalid
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+
+	return strings.Join(messages, " ")
+}
+
+func (e *ValidationError) add(field, code, message string) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Code: code, Message: message})
+}
+
+// orNil returns the validation error, or nil if no field was invalid
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// validateText checks that a required text field is present, valid UTF-8 and at most `max` characters long
+func (e *ValidationError) validateText(field, value string, max int) {
+	switch {
+	case !utf8.ValidString(value):
+		e.add(field, "invalid_utf8", fmt.Sprintf("The %s must be valid UTF-8.", field))
+	case strings.TrimSpace(value) == "":
+		e.add(field, "required", fmt.Sprintf("The %s is required.", field))
+	case utf8.RuneCountInString(value) > max:
+		e.add(field, "too_long", fmt.Sprintf("The %s must be at most %d characters.", field, max))
+	}
+}
+
+func userExists(userId int) (bool, error) {
+	db, err := DB()
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	var exists bool
+	err = db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)
+	`, userId).Scan(&exists)
+
+	if err != nil {
+		return false, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,614 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/lib/pq"
+)
+
+// Webhooks are sent the events of a user's convos, or of every convo for global webhooks, which have no user and are
+// added by operators directly in the `webhooks` table. Deliveries are queued in `webhook_deliveries`, in the same
+// transaction as the change they describe, and sent by the `webhooks` package. Like events, deliveries to the
+// recipient's webhooks only become due once the convo can no longer be undone, and undoing it drops them.
+
+const (
+	// Deliveries are pending until they are delivered, or until every attempt has failed
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+
+	MaxWebhookURLLength = 2048
+
+	// The most deliveries returned by `GetWebhookDeliveries`
+	maxDeliveriesPerPage = 100
+)
+
+// The events webhooks can subscribe to
+var WebhookEvents = []string{EventConvoCreated, EventReplyCreated, EventConvoUpdated, EventReadChanged, EventConvoDeleted}
+
+var (
+	// Whether webhooks may be sent to loopback, private and other non-public addresses. Convos are only sent to
+	// public addresses otherwise, so that users cannot make the server reach internal services.
+	AllowPrivateWebhooks = false
+
+	// Address ranges which are not public, besides those the `net.IP` methods tell apart
+	nonPublicNetworks = parseCIDRs(
+		"0.0.0.0/8",     // "This" network
+		"100.64.0.0/10", // Shared address space, used for carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // Benchmarking
+		"240.0.0.0/4",   // Reserved, and broadcast
+		"64:ff9b::/96",  // NAT64, which can reach any IPv4 address
+	)
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// IsPublicAddress reports whether webhooks may be sent to an IP address
+func IsPublicAddress(ip net.IP) bool {
+	if AllowPrivateWebhooks {
+		return true
+	}
+
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPublicHost reports whether every address of a host is public, and whether it could be resolved at all
+func isPublicHost(host string) (public, found bool) {
+	if AllowPrivateWebhooks {
+		return true, true
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil || len(ips) == 0 {
+			return false, false
+		}
+	}
+
+	for _, ip := range ips {
+		if !IsPublicAddress(ip) {
+			return false, true
+		}
+	}
+
+	return true, true
+}
+
+type Webhook struct {
+	Id     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+
+	// Only returned when the webhook is created. Generated if none is given.
+	Secret string `json:"secret,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	Id       int64           `json:"id"`
+	Webhook  int             `json:"webhook"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Status   string          `json:"status"`
+	Attempts int             `json:"attempts"`
+
+	// Only set while the delivery is pending
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+
+	// The outcome of the last attempt; the status code is only set if the webhook responded
+	LastStatusCode *int    `json:"last_status_code"`
+	LastError      *string `json:"last_error"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// Only set on the deliveries returned by `ClaimWebhookDeliveries`
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookEvent is the payload sent to webhooks
+type WebhookEvent struct {
+	Type  string         `json:"type"`
+	Time  time.Time      `json:"time"`
+	Convo *ConvoSnapshot `json:"convo"`
+
+	// Only set for `read.changed` events: who read the convo, and whether it is now read
+	User int   `json:"user,omitempty"`
+	Read *bool `json:"read,omitempty"`
+}
+
+// Validate checks that the webhook can be saved, returning a `*ValidationError` listing every invalid field
+func (w *Webhook) Validate() error {
+	verr := &ValidationError{}
+
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		verr.add("url", "invalid", "The url must be an absolute http or https URL.")
+	} else if len(w.URL) > MaxWebhookURLLength {
+		verr.add("url", "too_long", fmt.Sprintf("The url must be at most %d characters.", MaxWebhookURLLength))
+	} else if public, found := isPublicHost(u.Hostname()); !found {
+		verr.add("url", "not_found", fmt.Sprintf("Unable to find host '%s'.", u.Hostname()))
+	} else if !public {
+		verr.add("url", "not_public", "The url must be on a public address.")
+	}
+
+	if len(w.Events) == 0 {
+		verr.add("events", "required", "At least one event is required.")
+	}
+
+	for _, event := range w.Events {
+		if !isWebhookEvent(event) {
+			verr.add("events", "invalid", fmt.Sprintf("Unknown event '%s'.", event))
+		}
+	}
+
+	if w.Secret != "" && len(w.Secret) < 16 {
+		verr.add("secret", "too_short", "The secret must be at least 16 characters.")
+	} else if len(w.Secret) > 255 {
+		verr.add("secret", "too_long", "The secret must be at most 255 characters.")
+	}
+
+	return verr.orNil()
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func CreateWebhook(userId string, webhook *Webhook) (*Webhook, error) {
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	secret := webhook.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errgo.WithCausef(err, ErrRowCreate, "Error generating webhook secret")
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	w := &Webhook{URL: webhook.URL, Events: webhook.Events, Secret: secret}
+	err = db.QueryRow(`
+		INSERT INTO webhooks (user_id, url, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, userId, w.URL, pq.Array(w.Events), w.Secret).Scan(&w.Id, &w.CreatedAt)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowCreate, "Error creating webhook")
+	}
+
+	return w, nil
+}
+
+func GetWebhooks(userId string) ([]*Webhook, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, url, events, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id
+	`, userId)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving webhooks")
+	}
+	defer rows.Close()
+
+	ws := []*Webhook{}
+	for rows.Next() {
+		w := &Webhook{}
+		if err := rows.Scan(&w.Id, &w.URL, pq.Array(&w.Events), &w.CreatedAt); err != nil {
+			return ws, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		ws = append(ws, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ws, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return ws, nil
+}
+
+// DeleteWebhook deletes one of the user's webhooks, along with its deliveries
+func DeleteWebhook(userId, webhookId string) error {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM webhooks
+		WHERE id = $1
+		AND user_id = $2
+	`, webhookId, userId)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting webhook.")
+	}
+
+	count, err := result.RowsAffected()
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting webhook.")
+	}
+
+	if count == 0 {
+		return errgo.WithCausef(nil, ErrNoRows, "Unable to find webhook with id '%s'.", webhookId)
+	}
+
+	return nil
+}
+
+func scanWebhookDelivery(row interface {
+	Scan(dest ...interface{}) error
+}, extra ...interface{}) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var payload string
+	dest := append([]interface{}{
+		&d.Id, &d.Webhook, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode,
+		&d.LastError, &d.CreatedAt, &d.FinishedAt,
+	}, extra...)
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	d.Payload = json.RawMessage(payload)
+	return d, nil
+}
+
+const webhookDeliveryColumns = `
+	d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts,
+	CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.last_status_code, d.last_error, d.created_at,
+	d.finished_at
+`
+
+// GetWebhookDeliveries returns the latest deliveries of one of the user's webhooks, newest first
+func GetWebhookDeliveries(userId, webhookId string) ([]*WebhookDelivery, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	var exists bool
+	err = db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)
+	`, webhookId, userId).Scan(&exists)
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	if !exists {
+		return nil, errgo.WithCausef(nil, ErrNoRows, "Unable to find webhook with id '%s'.", webhookId)
+	}
+
+	// Deliveries which are not due yet may be for a convo the recipient cannot see yet
+	rows, err := db.Query(`
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries AS d
+		WHERE d.webhook_id = $1
+		AND (d.attempts > 0 OR d.next_attempt_at <= now())
+		ORDER BY d.id DESC
+		LIMIT $2
+	`, webhookId, maxDeliveriesPerPage)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUnknown, "Error retrieving webhook deliveries")
+	}
+	defer rows.Close()
+
+	ds := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return ds, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		ds = append(ds, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ds, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return ds, nil
+}
+
+// RedeliverWebhook queues a delivery which was delivered or failed to be sent again right away, with every attempt
+// available again
+func RedeliverWebhook(userId, webhookId, deliveryId string) (delivery *WebhookDelivery, err error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrTransaction, "Error starting transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT d.status
+		FROM webhook_deliveries AS d
+		JOIN webhooks AS w ON w.id = d.webhook_id
+		WHERE d.id = $1
+		AND d.webhook_id = $2
+		AND w.user_id = $3
+		AND (d.attempts > 0 OR d.next_attempt_at <= now())
+		FOR UPDATE OF d
+	`, deliveryId, webhookId, userId).Scan(&status)
+
+	if err == sql.ErrNoRows {
+		return nil, errgo.WithCausef(err, ErrNoRows, "Unable to find delivery with id '%s'.", deliveryId)
+	}
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+	}
+
+	if status == WebhookPending {
+		return nil, errgo.WithCausef(nil, ErrConflict, "Delivery with id '%s' is still pending.", deliveryId)
+	}
+
+	delivery, err = scanWebhookDelivery(tx.QueryRow(`
+		UPDATE webhook_deliveries AS d
+		SET status = $2, attempts = 0, next_attempt_at = now(), finished_at = NULL
+		WHERE d.id = $1
+		RETURNING `+webhookDeliveryColumns, deliveryId, WebhookPending))
+
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error redelivering webhook")
+	}
+
+	return delivery, nil
+}
+
+// ClaimWebhookDeliveries returns up to `limit` due deliveries, with the URL and secret of their webhook, and counts
+// an attempt for each. They are not due again until `lease` has passed, so that no other server sends them meanwhile.
+func ClaimWebhookDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	rows, err := db.Query(`
+		UPDATE webhook_deliveries AS d
+		SET attempts = d.attempts + 1, next_attempt_at = now() + $2 * INTERVAL '1 millisecond'
+		FROM webhooks AS w
+		WHERE w.id = d.webhook_id
+		AND d.id IN (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending'
+			AND next_attempt_at <= now()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE
+		)
+		RETURNING `+webhookDeliveryColumns+`, w.url, w.secret
+	`, limit, int64(lease/time.Millisecond))
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrRowUpdate, "Error claiming webhook deliveries")
+	}
+	defer rows.Close()
+
+	var ds []*WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return ds, errgo.WithCausef(err, ErrRowScan, "Error Scanning Row")
+		}
+
+		d.URL, d.Secret = url, secret
+		ds = append(ds, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ds, errgo.WithCausef(err, ErrRowUnknown, "Unknown problem with `rows` object")
+	}
+
+	return ds, nil
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt at a claimed delivery: `status` is `WebhookPending` if it is
+// to be attempted again in `retryIn`. Nothing is saved if the delivery was redelivered in the meantime.
+func RecordWebhookAttempt(d *WebhookDelivery, status string, statusCode int, attemptErr string, retryIn time.Duration) error {
+	db, err := DB()
+	if err != nil {
+		return errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	_, err = db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $3, last_status_code = NULLIF($4, 0), last_error = NULLIF($5, ''),
+		next_attempt_at = now() + $6 * INTERVAL '1 millisecond',
+		finished_at = CASE WHEN $3 = 'pending' THEN NULL ELSE now() END
+		WHERE id = $1
+		AND attempts = $2
+		AND status = 'pending'
+	`, d.Id, d.Attempts, status, statusCode, attemptErr, int64(retryIn/time.Millisecond))
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowUpdate, "Error recording webhook attempt")
+	}
+
+	return nil
+}
+
+// enqueueWebhooks queues an event about a convo, which must not have been deleted yet, for the webhooks of its
+// sender and recipient and for global webhooks
+func enqueueWebhooks(tx *sql.Tx, eventType, convoId string) error {
+	c, visibleAt, now, err := snapshotConvo(tx, convoId)
+	if err != nil {
+		return err
+	}
+
+	event := &WebhookEvent{Type: eventType, Time: now, Convo: c}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error encoding webhook payload")
+	}
+
+	// Only the sender's webhooks know about the convo before it is visible to the recipient
+	_, err = tx.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_type, convo_id, payload, next_attempt_at)
+		SELECT id, $1, $2, $3, CASE WHEN user_id = $4 THEN now() ELSE GREATEST(now(), $6) END
+		FROM webhooks
+		WHERE (user_id IS NULL OR user_id = $4 OR user_id = $5)
+		AND $1 = ANY(events)
+	`, eventType, c.Id, string(payload), c.Sender, c.Recipient, visibleAt)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error queueing webhook deliveries")
+	}
+
+	return nil
+}
+
+// enqueueReadWebhooks queues a change of the read status of a convo for the webhooks of the user who made it and for
+// global webhooks
+func enqueueReadWebhooks(tx *sql.Tx, userId, convoId string, read bool) error {
+	c, _, now, err := snapshotConvo(tx, convoId)
+	if err != nil {
+		return err
+	}
+
+	user, _ := strconv.Atoi(userId)
+	event := &WebhookEvent{Type: EventReadChanged, Time: now, Convo: c, User: user, Read: &read}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error encoding webhook payload")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_type, convo_id, payload)
+		SELECT id, $1, $2, $3
+		FROM webhooks
+		WHERE (user_id IS NULL OR user_id = $4)
+		AND $1 = ANY(events)
+	`, EventReadChanged, c.Id, string(payload), user)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowCreate, "Error queueing webhook deliveries")
+	}
+
+	return nil
+}
+
+// dropHiddenWebhookDeliveries removes the deliveries which are not due yet of convos which have been deleted, e.g.
+// those to the recipient's webhooks of an undone convo
+func dropHiddenWebhookDeliveries(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DELETE
+		FROM webhook_deliveries AS d
+		WHERE d.attempts = 0
+		AND d.next_attempt_at > now()
+		AND NOT EXISTS (SELECT 1 FROM convos AS c WHERE c.id = d.convo_id)
+	`)
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrRowDelete, "Error deleting webhook deliveries")
+	}
+
+	return nil
+}
+
+// PurgeFinishedWebhookDeliveries removes deliveries which were delivered or failed more than
+// `WebhookDeliveryRetention` ago
+func PurgeFinishedWebhookDeliveries() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrConnection, "Error retrieving DB Connection")
+	}
+
+	result, err := db.Exec(`
+		DELETE
+		FROM webhook_deliveries
+		WHERE finished_at <= now() - $1 * INTERVAL '1 millisecond'
+	`, int64(WebhookDeliveryRetention/time.Millisecond))
+
+	if err != nil {
+		return 0, errgo.WithCausef(err, ErrRowDelete, "Error deleting finished webhook deliveries")
+	}
+
+	return result.RowsAffected()
+}
+
+// StartWebhookDeliveryPurge removes finished webhook deliveries every `interval` until the process exits
+func StartWebhookDeliveryPurge(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := PurgeFinishedWebhookDeliveries(); err != nil {
+				log.Printf("Error purging finished webhook deliveries: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,293 @@
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+)
+
+// The inbound gateway is an SMTP server which accepts replies to notifications, sent to their reply address (see
+// `ReplyAddress`), and adds them to the thread as if the user had replied with `POST convos/:id/reply/`. It is meant
+// to receive mail relayed by the MX of `ReplyDomain` rather than to face the internet on its own.
+
+const (
+	// How many reply addresses a single email can be sent to
+	maxInboundRecipients = 10
+)
+
+var (
+	// The largest email accepted, attachments included
+	MaxInboundSize int64 = 10 << 20
+
+	// How long a client can take to send each command, or the whole email
+	InboundTimeout time.Duration = 5 * time.Minute
+)
+
+// inboundError is a reply to a client which failed to deliver an email, with its SMTP status code
+type inboundError struct {
+	code    int
+	message string
+}
+
+func (e *inboundError) Error() string {
+	return e.message
+}
+
+// ListenAndServeInbound accepts replies on `addr` until the listener fails
+func ListenAndServeInbound(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ServeInbound(listener)
+}
+
+// ServeInbound accepts replies on a listener until it is closed
+func ServeInbound(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go serveInboundConn(conn)
+	}
+}
+
+// inboundReply is a thread which the email being received is a reply to
+type inboundReply struct {
+	thread int
+	user   int
+}
+
+func serveInboundConn(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(code int, format string, args ...interface{}) {
+		tp.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+	}
+
+	conn.SetDeadline(time.Now().Add(InboundTimeout))
+	reply(220, "%s ESMTP convos", ReplyDomain)
+
+	var replies []*inboundReply
+	hasSender := false
+
+	for {
+		conn.SetDeadline(time.Now().Add(InboundTimeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-%s", ReplyDomain)
+			tp.PrintfLine("250-8BITMIME")
+			tp.PrintfLine("250 SIZE %d", MaxInboundSize)
+		case "HELO":
+			reply(250, "%s", ReplyDomain)
+		case "MAIL":
+			// Who is replying is checked against the email's From header instead
+			if _, ok := pathArgument(arg, "FROM:"); !ok {
+				reply(501, "Syntax: MAIL FROM:<address>")
+				continue
+			}
+
+			replies, hasSender = nil, true
+			reply(250, "OK")
+		case "RCPT":
+			address, ok := pathArgument(arg, "TO:")
+			switch {
+			case !hasSender:
+				reply(503, "MAIL first")
+			case !ok:
+				reply(501, "Syntax: RCPT TO:<address>")
+			case len(replies) >= maxInboundRecipients:
+				reply(452, "Too many recipients")
+			default:
+				thread, user, err := ParseReplyAddress(address)
+				if err != nil {
+					reply(550, "%s", err.Error())
+					continue
+				}
+
+				replies = append(replies, &inboundReply{thread: thread, user: user})
+				reply(250, "OK")
+			}
+		case "DATA":
+			if len(replies) == 0 {
+				reply(503, "RCPT first")
+				continue
+			}
+
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			conn.SetDeadline(time.Now().Add(InboundTimeout))
+
+			data, err := ioutil.ReadAll(io.LimitReader(tp.DotReader(), MaxInboundSize+1))
+			if err != nil {
+				return
+			}
+
+			if int64(len(data)) > MaxInboundSize {
+				// The rest of the email must be read before replying
+				io.Copy(ioutil.Discard, tp.DotReader())
+				reply(552, "The email is too large")
+			} else if err := receiveReplies(replies, data); err != nil {
+				if ierr, ok := err.(*inboundError); ok {
+					reply(ierr.code, "%s", ierr.message)
+				} else {
+					log.Printf("Error receiving email reply: %v\n", err)
+					reply(451, "Try again later")
+				}
+			} else {
+				reply(250, "OK")
+			}
+
+			replies, hasSender = nil, false
+		case "RSET":
+			replies, hasSender = nil, false
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+// pathArgument returns the address of a MAIL or RCPT command's argument, e.g. "FROM:<alice@example.com> SIZE=123"
+func pathArgument(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	start, end := strings.IndexByte(path, '<'), strings.IndexByte(path, '>')
+	if start != 0 || end < 0 {
+		return "", false
+	}
+
+	return path[1:end], true
+}
+
+// receiveReplies adds an email as a reply to each of the threads it was sent to. It returns an `*inboundError` if the
+// email must not be sent again, and any other error if it may succeed later: replies which were already added are
+// not added again (see `receiveReply`).
+func receiveReplies(replies []*inboundReply, data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return &inboundError{554, "The email could not be parsed"}
+	}
+
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 {
+		return &inboundError{554, "The email must be from a single address"}
+	}
+
+	text, err := textBody(msg)
+	if err != nil {
+		return &inboundError{554, "The email has no text"}
+	}
+
+	body := stripQuotes(text)
+	if body == "" {
+		return &inboundError{554, "The reply is empty"}
+	}
+
+	// Servers retry emails they could not deliver, which must not be added twice
+	messageId := msg.Header.Get("Message-Id")
+	if messageId == "" {
+		messageId = string(data)
+	}
+
+	var failed error
+	for _, r := range replies {
+		err := receiveReply(r, from[0].Address, body, messageId)
+		if err == nil {
+			continue
+		}
+
+		// Permanent failures win, so that the email is not sent again in vain
+		if _, ok := err.(*inboundError); ok || failed == nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+// receiveReply adds a reply to a thread, made by the user the reply address was given to, unless the email with
+// `messageId` was already added to it
+func receiveReply(r *inboundReply, from, body, messageId string) error {
+	userId := strconv.Itoa(r.user)
+
+	// Anyone who knows a reply address can send to it, so the email must also come from the user
+	settings, err := db.GetEmailSettings(userId)
+	if errgo.Cause(err) == db.ErrNoRows {
+		return &inboundError{550, "The reply address is not valid"}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Email == nil || !strings.EqualFold(*settings.Email, from) {
+		return &inboundError{550, "The email must be sent from the address the notification was sent to"}
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d", messageId, r.thread)))
+	fingerprint := sha256.Sum256([]byte(fmt.Sprintf("email-reply\n%d\n%s", r.thread, body)))
+
+	idempotencyKey := &db.IdempotencyKey{
+		Key:         "email:" + hex.EncodeToString(key[:]),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+
+	// Replies are added like those sent with `POST /convos/:id/reply/`
+	thread := strconv.Itoa(r.thread)
+	_, _, err = db.Reply(userId, thread, &db.Convo{Body: body}, idempotencyKey)
+
+	// The same email may have been added by another delivery in the meantime, in which case trying again finds it
+	if errgo.Cause(err) == db.ErrConflict {
+		_, _, err = db.Reply(userId, thread, &db.Convo{Body: body}, idempotencyKey)
+	}
+
+	if errgo.Cause(err) == db.ErrNoRows {
+		return &inboundError{550, "The convo no longer exists"}
+	}
+
+	if verr, ok := errgo.Cause(err).(*db.ValidationError); ok {
+		return &inboundError{550, verr.Error()}
+	}
+
+	// Another email with the same Message-Id was added, which sending this one again will not change
+	if errgo.Cause(err) == db.ErrConflict {
+		return &inboundError{554, "An email with the same Message-Id was already received"}
+	}
+
+	return err
+}
@@ -0,0 +1,197 @@
+package email
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+/* Utilities */
+
+func withReplySecret() func() {
+	ReplySecret, ReplyDomain = "a secret of some length", "reply.example.com"
+	return func() { ReplySecret, ReplyDomain = "", "localhost" }
+}
+
+func replyEmail(from, to, messageId, body string) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: Re: Hello\r\n" +
+		"Message-ID: " + messageId + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		body)
+}
+
+/* Tests */
+
+func Test_ReplyAddress(t *testing.T) {
+	defer withReplySecret()()
+
+	address := ReplyAddress(123456, 42, time.Now().Add(ReplyAddressTTL)).Address
+	if at := strings.LastIndex(address, "@"); at > 64 || address[at+1:] != ReplyDomain {
+		t.Errorf("Wrong reply address: %s", address)
+	}
+
+	// Some servers change the case of addresses
+	for _, a := range []string{address, strings.ToUpper(address)} {
+		if thread, user, err := ParseReplyAddress(a); err != nil || thread != 123456 || user != 42 {
+			t.Errorf("Wrong result for %s. Expected: 123456 42 <nil>. Actual: %d %d %v", a, thread, user, err)
+		}
+	}
+
+	local := address[:strings.LastIndex(address, "@")]
+	forged := map[string]string{
+		"other user":   strings.Replace(local, "-16-", "-17-", 1) + "@" + ReplyDomain,
+		"other domain": local + "@example.com",
+		"no signature": local[:strings.LastIndex(local, "-")] + "@" + ReplyDomain,
+		"not a reply":  "alice@" + ReplyDomain,
+	}
+
+	for name, a := range forged {
+		if _, _, err := ParseReplyAddress(a); err != ErrForgedReplyAddress {
+			t.Errorf("Wrong error for %s (%s). Expected: %v. Actual: %v", name, a, ErrForgedReplyAddress, err)
+		}
+	}
+
+	expired := ReplyAddress(123456, 42, time.Now().Add(-time.Minute)).Address
+	if _, _, err := ParseReplyAddress(expired); err != ErrExpiredReplyAddress {
+		t.Errorf("Wrong error for an expired address. Expected: %v. Actual: %v", ErrExpiredReplyAddress, err)
+	}
+
+	// Addresses cannot be used once the secret changes
+	ReplySecret = "another secret entirely"
+	if _, _, err := ParseReplyAddress(address); err != ErrForgedReplyAddress {
+		t.Errorf("Address signed with another secret was accepted: %v", err)
+	}
+}
+
+func Test_StripQuotes(t *testing.T) {
+	tests := map[string]string{
+		"Sounds good!\n\nOn Mon, Oct 19, 2026 at 12:00 PM, Alice <alice@example.com> wrote:\n> Lunch?\n":        "Sounds good!",
+		"Sounds good!\r\n\r\nOn Mon, Oct 19, 2026 at 12:00 PM, Alice\r\n<alice@example.com> wrote:\r\n> Lunch?": "Sounds good!",
+		"Sounds good!\n> Lunch?":                                                            "Sounds good!",
+		"Sounds good!\n\n-- \nBob\nBob's Company":                                           "Sounds good!",
+		"Sounds good!\n\nSent from my iPhone":                                               "Sounds good!",
+		"Sounds good!\n\n-----Original Message-----\nFrom: Alice":                           "Sounds good!",
+		"Sounds good!\n\n________________________________\nFrom: Alice\nSent: Monday":       "Sounds good!",
+		"Sounds good!\n\nFrom: Alice <alice@example.com>\nDate: Mon, 19 Oct 2026\n\nLunch?": "Sounds good!",
+		"First line  \nOn second thought, from: here\n\nThird":                              "First line\nOn second thought, from: here\n\nThird",
+		"> Lunch?\n": "",
+	}
+
+	for text, expected := range tests {
+		if actual := stripQuotes(text); actual != expected {
+			t.Errorf("Wrong reply for %q. Expected: %q. Actual: %q", text, expected, actual)
+		}
+	}
+}
+
+func Test_TextBody(t *testing.T) {
+	tests := map[string]string{
+		// Plain text is preferred
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>HTML</p>\r\n" +
+			"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9 =\r\nlatte\r\n" +
+			"--b--\r\n": "Café latte",
+		// Attachments are ignored
+		"Content-Type: multipart/mixed; boundary=m\r\n\r\n" +
+			"--m\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\nNotes\r\n" +
+			"--m\r\nContent-Type: text/html\r\n\r\n<html><head><style>p {}</style></head><p>Hello &amp; bye</p><br>Bob</html>\r\n" +
+			"--m--\r\n": "Hello & bye\n\nBob",
+		"Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: base64\r\n\r\nQ2Fm6SBs\r\nYXR0ZQ==\r\n": "Café latte",
+		"\r\nNo content type": "No content type",
+	}
+
+	for raw, expected := range tests {
+		msg, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if text, err := textBody(msg); err != nil || strings.TrimSpace(text) != expected {
+			t.Errorf("Wrong text. Expected: %q. Actual: %q (%v)", expected, text, err)
+		}
+	}
+}
+
+func Test_ServeInbound(t *testing.T) {
+	setupEmailTest(t)
+	defer tearDownEmailTest(t)
+	defer withReplySecret()()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go ServeInbound(listener)
+
+	if err := db.SetUserEmail("2", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	thread, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "Hello", Body: "Lunch?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := ReplyAddress(thread.Id, 2, time.Now().Add(ReplyAddressTTL)).Address
+	body := "Sounds good!\r\n\r\nOn Mon, Oct 19, 2026, Alice wrote:\r\n> Lunch?\r\n"
+	sendBody := func(from, to, messageId, body string) error {
+		return smtp.SendMail(listener.Addr().String(), nil, from, []string{to}, replyEmail(from, to, messageId, body))
+	}
+	send := func(from, to, messageId string) error {
+		return sendBody(from, to, messageId, body)
+	}
+
+	if err := send("bob@example.com", to, "<1@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Servers retrying an email do not add the reply twice
+	if err := send("bob@example.com", to, "<1@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err := db.GetConvo("1", strconv.Itoa(thread.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(convo.Children) != 1 {
+		t.Fatalf("Wrong replies. Expected: 1. Actual: %+v", convo.Children)
+	}
+
+	reply := convo.Children[0]
+	if reply.Sender != 2 || reply.Recipient != 1 || reply.Body != "Sounds good!" || reply.Subject != "Hello" {
+		t.Errorf("Wrong reply: %+v", reply)
+	}
+
+	// Emails to made up addresses, or from someone else, are rejected
+	forged := strings.Replace(to, "reply+", "reply+1", 1)
+	if err := send("bob@example.com", forged, "<2@example.com>"); err == nil {
+		t.Errorf("Email to a forged address was accepted")
+	}
+
+	if err := send("mallory@example.com", to, "<3@example.com>"); err == nil {
+		t.Errorf("Email from another address was accepted")
+	}
+
+	// Reusing a Message-Id for another email is rejected for good, instead of being retried
+	err = sendBody("bob@example.com", to, "<1@example.com>", "Actually, no.")
+	if perr, ok := err.(*textproto.Error); !ok || perr.Code != 554 {
+		t.Errorf("Wrong error for a reused Message-Id. Expected: 554. Actual: %v", err)
+	}
+
+	if convo, _ := db.GetConvo("1", strconv.Itoa(thread.Id)); len(convo.Children) != 1 {
+		t.Errorf("Rejected emails were added: %+v", convo.Children)
+	}
+}
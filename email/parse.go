@@ -0,0 +1,204 @@
+package email
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+var errNoTextBody = errors.New("The email has no text.")
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlHidden = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+
+	// The lines which start the quoted history of a reply, or the sender's signature: everything from them on is left
+	// out of the reply
+	cutLines = []*regexp.Regexp{
+		// Signatures, as delimited by RFC 3676, and those added by mobile clients
+		regexp.MustCompile(`^-- ?$`),
+		regexp.MustCompile(`^Sent from my \S+`),
+		// Quoted text
+		regexp.MustCompile(`^>`),
+		regexp.MustCompile(`(?i)^-+\s*Original Message\s*-+$`),
+		regexp.MustCompile(`^_{10,}$`),
+	}
+
+	// The attribution line before quoted text, e.g. "On Mon, Oct 19, 2026 at 12:00 PM, Alice <...> wrote:", which
+	// clients may wrap over two lines
+	attributionStart = regexp.MustCompile(`^On\s`)
+	attributionEnd   = regexp.MustCompile(`wrote:\s*$`)
+
+	// The headers Outlook quotes the original message with
+	quotedHeaders = regexp.MustCompile(`^(From|De|Von):\s`)
+	quotedDate    = regexp.MustCompile(`^(Sent|Date|Envoyé|Gesendet):\s`)
+)
+
+// textBody returns the text of an email, preferring its plain-text part to its HTML one
+func textBody(msg *mail.Message) (string, error) {
+	text, html, err := readPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case text != "":
+		return text, nil
+	case html != "":
+		return htmlToText(html), nil
+	}
+
+	return "", errNoTextBody
+}
+
+// readPart returns the first plain-text and HTML content found in a part of an email, looking into multipart parts
+func readPart(contentType, encoding string, body io.Reader) (text, html string, err error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Emails without a content type are plain text
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextPart()
+			if err == io.EOF {
+				return text, html, nil
+			}
+
+			if err != nil {
+				return text, html, err
+			}
+
+			// Attachments are ignored
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+
+			// Quoted-printable parts are decoded by the reader, which removes their encoding header
+			partText, partHTML, err := readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return text, html, err
+			}
+
+			if text == "" {
+				text = partText
+			}
+
+			if html == "" {
+				html = partHTML
+			}
+		}
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", "", nil
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineStripper{body})
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", "", err
+	}
+
+	decoded := decodeCharset(content, params["charset"])
+	if mediaType == "text/html" {
+		return "", decoded, nil
+	}
+
+	return decoded, "", nil
+}
+
+// decodeCharset converts text to UTF-8. Only Latin-1 needs converting besides UTF-8 and ASCII; text in other charsets
+// is kept as it is, and rejected if it is not valid UTF-8.
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+
+		return string(runes)
+	}
+
+	return string(content)
+}
+
+// newlineStripper removes line breaks from base64 content, which the decoder does not expect
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+
+	return kept, err
+}
+
+func htmlToText(content string) string {
+	content = htmlHidden.ReplaceAllString(content, "")
+	content = htmlBreaks.ReplaceAllString(content, "\n")
+	content = htmlTags.ReplaceAllString(content, "")
+	return html.UnescapeString(content)
+}
+
+// stripQuotes returns the text of a reply without the quoted history and signature which clients add below it
+func stripQuotes(text string) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	end := len(lines)
+	for i, line := range lines {
+		if isCutLine(lines, i) {
+			end = i
+			break
+		}
+
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+
+	return strings.TrimSpace(strings.Join(lines[:end], "\n"))
+}
+
+func isCutLine(lines []string, i int) bool {
+	line := strings.TrimSpace(lines[i])
+	for _, cut := range cutLines {
+		if cut.MatchString(line) {
+			return true
+		}
+	}
+
+	next := ""
+	if i+1 < len(lines) {
+		next = strings.TrimSpace(lines[i+1])
+	}
+
+	if attributionStart.MatchString(line) && (attributionEnd.MatchString(line) || attributionEnd.MatchString(next)) {
+		return true
+	}
+
+	return quotedHeaders.MatchString(line) && quotedDate.MatchString(next)
+}
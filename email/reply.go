@@ -0,0 +1,95 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifications of a single convo can be replied to by email: they are sent with a reply address which is unique to
+// the thread and the user, and signed so that it cannot be made up or altered:
+//
+//	reply+<thread>-<user>-<expiry>-<signature>@<ReplyDomain>
+//
+// Numbers are in base 36, so that the local part stays within the 64 characters allowed, and the signature is the
+// start of the hex-encoded HMAC-SHA256 of "<thread>-<user>-<expiry>" keyed with `ReplySecret`.
+
+const (
+	replyPrefix = "reply+"
+
+	// How many hex characters of the HMAC are kept
+	replySignatureLength = 24
+)
+
+var (
+	// Reply addresses are only given, and replies only accepted, if a secret is set
+	ReplySecret = ""
+	ReplyDomain = "localhost"
+
+	// How long a reply address is accepted after its notification was sent
+	ReplyAddressTTL time.Duration = 30 * 24 * time.Hour
+
+	ErrForgedReplyAddress  = errors.New("The reply address is not valid.")
+	ErrExpiredReplyAddress = errors.New("The reply address has expired.")
+)
+
+func signReply(payload string) string {
+	mac := hmac.New(sha256.New, []byte(ReplySecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))[:replySignatureLength]
+}
+
+// ReplyAddress returns the address which `userId` can reply to a thread by, valid until `expires`
+func ReplyAddress(threadId, userId int, expires time.Time) *mail.Address {
+	payload := fmt.Sprintf("%s-%s-%s",
+		strconv.FormatInt(int64(threadId), 36),
+		strconv.FormatInt(int64(userId), 36),
+		strconv.FormatInt(expires.Unix(), 36),
+	)
+
+	return &mail.Address{Address: replyPrefix + payload + "-" + signReply(payload) + "@" + ReplyDomain}
+}
+
+// ParseReplyAddress returns the thread and user a reply address was given for, or `ErrForgedReplyAddress` or
+// `ErrExpiredReplyAddress` if it cannot be used
+func ParseReplyAddress(address string) (threadId, userId int, err error) {
+	at := strings.LastIndex(address, "@")
+	if ReplySecret == "" || at < 0 || !strings.EqualFold(address[at+1:], ReplyDomain) {
+		return 0, 0, ErrForgedReplyAddress
+	}
+
+	// Some servers change the case of the local part, which only holds lowercase characters
+	local := strings.ToLower(address[:at])
+	if !strings.HasPrefix(local, replyPrefix) {
+		return 0, 0, ErrForgedReplyAddress
+	}
+
+	fields := strings.Split(strings.TrimPrefix(local, replyPrefix), "-")
+	if len(fields) != 4 {
+		return 0, 0, ErrForgedReplyAddress
+	}
+
+	payload := strings.Join(fields[:3], "-")
+	if !hmac.Equal([]byte(fields[3]), []byte(signReply(payload))) {
+		return 0, 0, ErrForgedReplyAddress
+	}
+
+	var numbers [3]int64
+	for i, field := range fields[:3] {
+		if numbers[i], err = strconv.ParseInt(field, 36, 64); err != nil {
+			return 0, 0, ErrForgedReplyAddress
+		}
+	}
+
+	if time.Now().After(time.Unix(numbers[2], 0)) {
+		return 0, 0, ErrExpiredReplyAddress
+	}
+
+	return int(numbers[0]), int(numbers[1]), nil
+}
@@ -0,0 +1,205 @@
+package email
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+// Recipients of new convos are notified by email. Notifications are queued by an outbox subscriber (see `Subscribe`),
+// in the same transaction as the relay's checkpoint, and sent from here by every server instance: each digest is
+// claimed by a single instance at a time (see `db.ClaimEmailDigests`).
+
+const (
+	// The name of the outbox subscriber which queues notifications
+	subscriberName = "email"
+
+	// How many digests are claimed at once
+	claimBatchSize = 20
+)
+
+var (
+	// The SMTP server to send emails through, as host:port. Authentication is only used if a username is set, and
+	// STARTTLS whenever the server supports it.
+	SMTPAddr     = "localhost:25"
+	SMTPUsername = ""
+	SMTPPassword = ""
+
+	From = &mail.Address{Name: "Convos", Address: "convos@localhost"}
+
+	// Convos arriving within this long of a user's last email are sent together in the next one
+	DigestWindow time.Duration = 10 * time.Minute
+
+	// How many times a digest is attempted before its notifications are dropped, and how long to wait between them
+	MaxAttempts               = 5
+	RetryDelay  time.Duration = time.Minute
+
+	// How long the SMTP server has to accept an email
+	Timeout time.Duration = 30 * time.Second
+)
+
+// Subscribe queues a notification for the recipient of each new convo, once it is visible to them
+func Subscribe() {
+	db.SubscribeOutbox(subscriberName, queueNotification)
+}
+
+func queueNotification(tx *sql.Tx, event *db.OutboxEvent) error {
+	if event.Type != db.EventConvoCreated && event.Type != db.EventReplyCreated {
+		return nil
+	}
+
+	return db.QueueEmailNotification(tx, event.Convo.Recipient, event.Convo.Id)
+}
+
+// Send sends a message through the SMTP server at `addr`
+func Send(addr string, auth smtp.Auth, m *Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.From.Address); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(m.To.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// newDigestMessage renders the email of a digest
+func newDigestMessage(d *db.EmailDigest) (*Message, error) {
+	data := newDigestData(d)
+
+	// Replies to a digest could be meant for any of its threads
+	var replyTo *mail.Address
+	if ReplySecret != "" && len(d.Notifications) == 1 {
+		replyTo = ReplyAddress(d.Notifications[0].Convo.Parent, d.User, time.Now().Add(ReplyAddressTTL))
+		data.CanReply = true
+	}
+
+	text, html, err := render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:    From,
+		To:      &mail.Address{Name: d.Name, Address: d.Email},
+		ReplyTo: replyTo,
+		Subject: subject(data),
+		Text:    text,
+		HTML:    html,
+	}, nil
+}
+
+// DeliverDue sends every due digest, and returns how many were sent
+func DeliverDue() (int, error) {
+	// Claimed digests must be sent before another instance may claim them again
+	lease := Timeout + time.Minute
+
+	var auth smtp.Auth
+	if SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(SMTPAddr)
+		auth = smtp.PlainAuth("", SMTPUsername, SMTPPassword, host)
+	}
+
+	count := 0
+	for {
+		ds, err := db.ClaimEmailDigests(claimBatchSize, DigestWindow, lease)
+		if err != nil {
+			return count, err
+		}
+
+		for _, d := range ds {
+			if deliver(auth, d) {
+				count++
+			}
+		}
+
+		if len(ds) < claimBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// deliver attempts to send a claimed digest and records the outcome, returning whether it was sent
+func deliver(auth smtp.Auth, d *db.EmailDigest) bool {
+	m, err := newDigestMessage(d)
+	if err == nil {
+		err = Send(SMTPAddr, auth, m)
+	}
+
+	retryIn := time.Duration(0)
+	if err != nil {
+		log.Printf("Error sending email notifications to user %d: %v\n", d.User, err)
+
+		if d.Attempts < MaxAttempts {
+			retryIn = RetryDelay
+		}
+	}
+
+	if err := db.RecordEmailDigest(d, err == nil, retryIn); err != nil {
+		log.Printf("Error recording email notifications to user %d: %v\n", d.User, err)
+	}
+
+	return err == nil
+}
+
+// StartDelivery sends due digests every `interval` until the process exits
+func StartDelivery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := DeliverDue(); err != nil {
+				log.Printf("Error sending email notifications: %v\n", err)
+			}
+		}
+	}()
+}
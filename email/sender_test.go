@@ -0,0 +1,354 @@
+package email
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+/* Utilities */
+
+// fakeSMTPServer is a local SMTP server which records the messages it accepts, or rejects them with `status` if set
+type fakeSMTPServer struct {
+	sync.Mutex
+	listener net.Listener
+	status   int
+	messages []*mail.Message
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTPServer) SetStatus(status int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.status = status
+}
+
+func (s *fakeSMTPServer) Messages() []*mail.Message {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]*mail.Message{}, s.messages...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost fake SMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			s.Lock()
+			status := s.status
+			s.Unlock()
+
+			if status != 0 {
+				tp.PrintfLine("%d Unavailable", status)
+				continue
+			}
+
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+			if err != nil {
+				tp.PrintfLine("554 Invalid message")
+				continue
+			}
+
+			s.Lock()
+			s.messages = append(s.messages, msg)
+			s.Unlock()
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Unknown command")
+		}
+	}
+}
+
+// parts returns the content of each part of a multipart message, by content type
+func parts(t *testing.T, msg *mail.Message) map[string]string {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Wrong content type: %s", msg.Header.Get("Content-Type"))
+	}
+
+	contents := map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			break
+		}
+
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		content, _ := ioutil.ReadAll(part)
+		contents[contentType] = string(content)
+	}
+
+	return contents
+}
+
+func setupEmailTest(t *testing.T) {
+	db.Initialize("test_convos")
+
+	// In case we had paniced previously
+	tearDownEmailTest(t)
+
+	for id, name := range map[string]string{"1": "Alice", "2": "Bob"} {
+		if err := db.AddUser(id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tearDownEmailTest(t *testing.T) {
+	if err := db.TruncateAllTables(); err != nil {
+		t.Fatalf("Truncate tables: %s\n", err)
+	}
+}
+
+/* Tests */
+
+func Test_Send(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+
+	m := &Message{
+		From:    &mail.Address{Name: "Convos", Address: "convos@example.com"},
+		To:      &mail.Address{Name: "Bob", Address: "bob@example.com"},
+		Subject: "New convo from Alice: Café",
+		Text:    "Hi Bob,\n\nA line long enough to be wrapped by the quoted-printable encoding, which limits lines to 76 characters.",
+		HTML:    "<p>Hi Bob,</p>",
+	}
+
+	if err := Send(server.Addr(), nil, m); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Wrong number of messages received: %d", len(messages))
+	}
+
+	msg := messages[0]
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != m.Subject {
+		t.Errorf("Wrong subject. Expected: %q. Actual: %q", m.Subject, subject)
+	}
+
+	if to, err := msg.Header.AddressList("To"); err != nil || to[0].Address != "bob@example.com" {
+		t.Errorf("Wrong recipient: %v %v", to, err)
+	}
+
+	contents := parts(t, msg)
+	if contents["text/plain"] != m.Text || contents["text/html"] != m.HTML {
+		t.Errorf("Wrong parts: %+v", contents)
+	}
+
+	// Rejected messages are errors
+	server.SetStatus(451)
+	if err := Send(server.Addr(), nil, m); err == nil {
+		t.Errorf("Rejected message was not an error")
+	}
+}
+
+func Test_Render(t *testing.T) {
+	data := &DigestData{Name: "Bob", Convos: []*DigestConvo{
+		{Sender: "Alice", Subject: "<b>Hello</b>", Excerpt: "First"},
+	}}
+
+	text, html, err := render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(text, "Convo from Alice: <b>Hello</b>") || !strings.Contains(text, "You have a new convo.") {
+		t.Errorf("Wrong text: %s", text)
+	}
+
+	// Convos are escaped in HTML
+	if !strings.Contains(html, "&lt;b&gt;Hello&lt;/b&gt;") {
+		t.Errorf("Wrong HTML: %s", html)
+	}
+
+	if s := subject(data); s != "New convo from Alice: <b>Hello</b>" {
+		t.Errorf("Wrong subject: %s", s)
+	}
+
+	data.Convos = append(data.Convos, &DigestConvo{Sender: "Alice", Subject: "Again", Excerpt: "Second", Reply: true})
+	if text, _, _ := render(data); !strings.Contains(text, "You have 2 new convos.") || !strings.Contains(text, "Reply from Alice: Again") {
+		t.Errorf("Wrong text: %s", text)
+	}
+
+	if s := subject(data); s != "2 new convos" {
+		t.Errorf("Wrong subject: %s", s)
+	}
+}
+
+func Test_Excerpt(t *testing.T) {
+	if e := excerpt("  short  ", 10); e != "short" {
+		t.Errorf("Wrong excerpt: %q", e)
+	}
+
+	if e := excerpt("ééééé ééééé", 6); e != "ééééé…" {
+		t.Errorf("Wrong excerpt: %q", e)
+	}
+}
+
+func Test_DeliverDue(t *testing.T) {
+	setupEmailTest(t)
+	defer tearDownEmailTest(t)
+
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+
+	SMTPAddr = server.Addr()
+	DigestWindow = time.Hour
+	defer func() { DigestWindow = 10 * time.Minute }()
+
+	if err := db.SetUserEmail("2", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	Subscribe()
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	createConvo := func(subject string) *db.Convo {
+		c, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: subject, Body: "Message Body"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.RelayOutbox(); err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	deliverDue := func(expected int) {
+		if count, err := DeliverDue(); err != nil || count != expected {
+			t.Fatalf("Wrong number of emails sent. Expected: %d. Actual: %d (%v)", expected, count, err)
+		}
+	}
+
+	// The first convo is sent right away
+	createConvo("First")
+	deliverDue(1)
+
+	// The next ones wait for the window to pass since the last email
+	createConvo("Second")
+	deleted := createConvo("Deleted")
+	createConvo("Third")
+	deliverDue(0)
+
+	if err := db.DeleteConvo("1", strconv.Itoa(deleted.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	DigestWindow = 0
+	deliverDue(1)
+
+	messages := server.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("Wrong number of messages received: %d", len(messages))
+	}
+
+	if subject := messages[0].Header.Get("Subject"); !strings.Contains(subject, "First") {
+		t.Errorf("Wrong subject: %s", subject)
+	}
+
+	// Deleted convos are left out of the digest
+	text := parts(t, messages[1])["text/plain"]
+	if !strings.Contains(text, "You have 2 new convos.") || !strings.Contains(text, "Second") || strings.Contains(text, "Deleted") {
+		t.Errorf("Wrong digest: %s", text)
+	}
+
+	// Users who opted out are not notified
+	disabled := false
+	if _, err := db.UpdateEmailSettings("2", &db.EmailSettingsPatch{Notifications: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	createConvo("Unwanted")
+	deliverDue(0)
+
+	// Failed emails are retried
+	enabled := true
+	if _, err := db.UpdateEmailSettings("2", &db.EmailSettingsPatch{Notifications: &enabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	RetryDelay = time.Millisecond
+	defer func() { RetryDelay = time.Minute }()
+
+	createConvo("Retried")
+	server.SetStatus(451)
+	deliverDue(0)
+
+	server.SetStatus(0)
+	time.Sleep(10 * time.Millisecond)
+	deliverDue(1)
+
+	if messages := server.Messages(); len(messages) != 3 || !strings.Contains(messages[2].Header.Get("Subject"), "Retried") {
+		t.Errorf("Failed email was not retried: %d messages", len(messages))
+	}
+}
@@ -0,0 +1,124 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"unicode/utf8"
+
+	"github.com/nt3rp/convos/db"
+)
+
+// How much of the body of each convo is included in notifications
+const excerptLength = 500
+
+// The templates of notifications, which can be replaced before delivery starts. Both are executed with a
+// `*DigestData`, and hold a single convo unless several arrived within the digest window.
+var (
+	TextTemplate = texttemplate.Must(texttemplate.New("text").Parse(`Hi {{.Name}},
+{{if eq (len .Convos) 1}}
+You have a new convo.
+{{else}}
+You have {{len .Convos}} new convos.
+{{end}}{{range .Convos}}
+{{if .Reply}}Reply{{else}}Convo{{end}} from {{.Sender}}: {{.Subject}}
+
+{{.Excerpt}}
+{{end}}{{if .CanReply}}
+Reply to this email to answer.
+{{end}}
+--
+You are receiving this email because email notifications are turned on in your settings.
+`))
+
+	HTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>Hi {{.Name}},</p>
+{{if eq (len .Convos) 1}}<p>You have a new convo.</p>{{else}}<p>You have {{len .Convos}} new convos.</p>{{end}}
+{{range .Convos}}<div>
+<h3>{{if .Reply}}Reply{{else}}Convo{{end}} from {{.Sender}}: {{.Subject}}</h3>
+<p style="white-space: pre-wrap">{{.Excerpt}}</p>
+</div>
+{{end}}{{if .CanReply}}<p>Reply to this email to answer.</p>
+{{end}}<hr>
+<p><small>You are receiving this email because email notifications are turned on in your settings.</small></p>
+</body>
+</html>
+`))
+)
+
+// DigestData is what the templates are executed with
+type DigestData struct {
+	// The name of the recipient
+	Name   string
+	Convos []*DigestConvo
+
+	// Whether the email can be replied to
+	CanReply bool
+}
+
+// DigestConvo is a convo in a notification
+type DigestConvo struct {
+	Sender  string
+	Subject string
+	Excerpt string
+
+	// Whether the convo is a reply to a thread
+	Reply bool
+}
+
+func newDigestData(d *db.EmailDigest) *DigestData {
+	data := &DigestData{Name: d.Name}
+	for _, n := range d.Notifications {
+		data.Convos = append(data.Convos, &DigestConvo{
+			Sender:  n.SenderName,
+			Subject: n.Convo.Subject,
+			Excerpt: excerpt(n.Convo.Body, excerptLength),
+			Reply:   n.Convo.Parent != n.Convo.Id,
+		})
+	}
+
+	return data
+}
+
+// excerpt returns the start of a text, cut to at most `max` characters
+func excerpt(text string, max int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max])) + "…"
+}
+
+// subject returns the subject of the email of a digest
+func subject(data *DigestData) string {
+	if len(data.Convos) > 1 {
+		return fmt.Sprintf("%d new convos", len(data.Convos))
+	}
+
+	c := data.Convos[0]
+	if c.Reply {
+		return fmt.Sprintf("New reply from %s: %s", c.Sender, c.Subject)
+	}
+
+	return fmt.Sprintf("New convo from %s: %s", c.Sender, c.Subject)
+}
+
+// render executes both templates with a digest
+func render(data *DigestData) (text, html string, err error) {
+	var textBuf, htmlBuf bytes.Buffer
+	if err := TextTemplate.Execute(&textBuf, data); err != nil {
+		return "", "", err
+	}
+
+	if err := HTMLTemplate.Execute(&htmlBuf, data); err != nil {
+		return "", "", err
+	}
+
+	return textBuf.String(), htmlBuf.String(), nil
+}
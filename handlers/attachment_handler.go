@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-martini/martini"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/blobs"
+	"github.com/nt3rp/convos/db"
+)
+
+var (
+	// Where the contents of attachments are kept
+	BlobStore blobs.Store
+
+	// Largest size of a single attachment, in bytes
+	MaxAttachmentSize int64 = 10 << 20
+
+	// Most attachments a single convo can be sent with
+	MaxAttachments = 10
+
+	ErrAttachmentTooLarge = errgo.New("Attachment Too Large")
+)
+
+const (
+	// How much of a multipart form is kept in memory before the rest is written to temporary files
+	multipartMemory int64 = 1 << 20
+
+	// How many bytes `http.DetectContentType` looks at
+	sniffLength = 512
+
+	// Room left in a multipart request for the convo itself and the headers of each part
+	multipartOverhead int64 = 1 << 20
+)
+
+func isMultipartRequest(req *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// parseMultipartForm reads a multipart request, which is refused before any of it is written to disk if it is larger
+// than the most attachments a convo can be sent with
+func parseMultipartForm(req *http.Request) error {
+	req.Body = http.MaxBytesReader(nil, req.Body, int64(MaxAttachments)*MaxAttachmentSize+multipartOverhead)
+
+	err := req.ParseMultipartForm(multipartMemory)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errgo.WithCausef(err, ErrAttachmentTooLarge, "Attachments can be at most %d bytes each, and at most %d can be sent at once.", MaxAttachmentSize, MaxAttachments)
+	}
+
+	if err != nil {
+		return errgo.WithCausef(err, ErrInvalidJson, "Unable to read multipart form.")
+	}
+
+	return nil
+}
+
+// storeAttachments saves the files uploaded in the `attachments` field of a multipart request to the blob store
+func storeAttachments(req *http.Request) ([]*db.Attachment, error) {
+	if req.MultipartForm == nil || len(req.MultipartForm.File["attachments"]) == 0 {
+		return nil, nil
+	}
+
+	if len(req.MultipartForm.File["attachments"]) > MaxAttachments {
+		return nil, errgo.WithCausef(nil, ErrAttachmentTooLarge, "At most %d attachments can be sent at once.", MaxAttachments)
+	}
+
+	// Checked again when the convo is created, but this avoids storing attachments which are bound to be refused
+	usage, err := db.GetAttachmentUsage(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*db.Attachment
+	for _, fh := range req.MultipartForm.File["attachments"] {
+		if fh.Size > MaxAttachmentSize {
+			deleteAttachments(attachments)
+			return nil, errgo.WithCausef(nil, ErrAttachmentTooLarge, "Attachment '%s' is larger than %d bytes.", fh.Filename, MaxAttachmentSize)
+		}
+
+		usage += fh.Size
+		if usage > db.AttachmentQuota {
+			deleteAttachments(attachments)
+			return nil, errgo.WithCausef(nil, ErrAttachmentTooLarge, "Attachments would exceed the quota of %d bytes.", db.AttachmentQuota)
+		}
+
+		a, err := storeAttachment(fh)
+		if err != nil {
+			deleteAttachments(attachments)
+			return nil, err
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, nil
+}
+
+func storeAttachment(fh *multipart.FileHeader) (*db.Attachment, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, errgo.Notef(err, "Error reading attachment '%s'", fh.Filename)
+	}
+	defer f.Close()
+
+	// Don't trust the content type provided by the client
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, errgo.Notef(err, "Error reading attachment '%s'", fh.Filename)
+	}
+	head = head[:n]
+
+	key, err := blobs.NewKey()
+	if err != nil {
+		return nil, errgo.Notef(err, "Error generating attachment key")
+	}
+
+	if err := BlobStore.Put(key, io.MultiReader(bytes.NewReader(head), f)); err != nil {
+		return nil, errgo.Notef(err, "Error storing attachment '%s'", fh.Filename)
+	}
+
+	return &db.Attachment{
+		Filename:    filepath.Base(fh.Filename),
+		ContentType: http.DetectContentType(head),
+		Size:        fh.Size,
+		StorageKey:  key,
+	}, nil
+}
+
+// deleteAttachments removes attachments from the blob store which ended up not being used
+func deleteAttachments(attachments []*db.Attachment) {
+	for _, a := range attachments {
+		if err := BlobStore.Delete(a.StorageKey); err != nil {
+			log.Printf("Error deleting blob '%s': %v\n", a.StorageKey, err)
+		}
+	}
+}
+
+func GetAttachment(params martini.Params, w http.ResponseWriter, r render.Render) {
+	attachment, err := db.GetAttachment(userId, params["id"], params["aid"])
+	if err != nil {
+		returnEnvelope(r, attachment, err)
+		return
+	}
+
+	blob, err := BlobStore.Get(attachment.StorageKey)
+	if err != nil {
+		returnEnvelope(r, attachment, errgo.Notef(err, "Error retrieving attachment"))
+		return
+	}
+	defer blob.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error sending attachment %d: %v\n", attachment.Id, err)
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/blobs"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+var (
+	pdfContents = []byte("%PDF-1.4\nNot really a PDF")
+)
+
+/* Utilities */
+
+func setupAttachmentHandlerTest(t *testing.T) string {
+	setupConvoHandlerTest(t)
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	BlobStore, err = blobs.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func tearDownAttachmentHandlerTest(t *testing.T, dir string) {
+	tearDownConvoHandlerTest(t)
+	os.RemoveAll(dir)
+}
+
+func generateMultipartRequest(authKey string, convo *db.Convo, files map[string][]byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("convo", convo.ToJson())
+
+	for name, contents := range files {
+		part, _ := writer.CreateFormFile("attachments", name)
+		part.Write(contents)
+	}
+	writer.Close()
+
+	request := generateTestRequest(authKey, body.String())
+	request.Method = "POST"
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	UserAuthorizationMiddleware(request, &mocks.Render{})
+
+	return request
+}
+
+/* Tests */
+
+func Test_CreateConvo_WithAttachment(t *testing.T) {
+	dir := setupAttachmentHandlerTest(t)
+	defer tearDownAttachmentHandlerTest(t, dir)
+
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body",
+	}
+	req := generateMultipartRequest("1", post, map[string][]byte{"report.pdf": pdfContents})
+	p := generateHandlerPrerequisites(true, "")
+
+	CreateConvo(req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	convo := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if len(convo.Attachments) != 1 {
+		t.Fatalf("Wrong number of attachments. Expected: %v. Actual: %v", 1, len(convo.Attachments))
+	}
+
+	attachment := convo.Attachments[0]
+	if attachment.Filename != "report.pdf" || attachment.ContentType != "application/pdf" {
+		t.Errorf("Wrong attachment metadata. Actual: %#v", attachment)
+	}
+
+	// Download it again
+	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Params["aid"] = strconv.Itoa(attachment.Id)
+	w := httptest.NewRecorder()
+
+	GetAttachment(p.Params, w, p.Render)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, w.Code)
+	}
+
+	if !bytes.Equal(w.Body.Bytes(), pdfContents) {
+		t.Errorf("Attachment contents do not match.\nExpected: %q\nActual  : %q", pdfContents, w.Body.Bytes())
+	}
+}
+
+func Test_CreateConvo_AttachmentTooLarge(t *testing.T) {
+	dir := setupAttachmentHandlerTest(t)
+	defer tearDownAttachmentHandlerTest(t, dir)
+
+	MaxAttachmentSize = 4
+	defer func() { MaxAttachmentSize = 10 << 20 }()
+
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body",
+	}
+	req := generateMultipartRequest("1", post, map[string][]byte{"report.pdf": pdfContents})
+	p := generateHandlerPrerequisites(true, "")
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, ErrAttachmentTooLarge, "Attachment 'report.pdf' is larger than %d bytes.", 4))
+
+	CreateConvo(req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusRequestEntityTooLarge, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_CreateConvo_RequestTooLarge(t *testing.T) {
+	dir := setupAttachmentHandlerTest(t)
+	defer tearDownAttachmentHandlerTest(t, dir)
+
+	MaxAttachments, MaxAttachmentSize = 1, 4
+	defer func() { MaxAttachments, MaxAttachmentSize = 10, 10<<20 }()
+
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body",
+	}
+	req := generateMultipartRequest("1", post, map[string][]byte{"report.pdf": bytes.Repeat(pdfContents, 1<<16)})
+	p := generateHandlerPrerequisites(true, "")
+
+	CreateConvo(req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusRequestEntityTooLarge, renderer.StatusCode)
+	}
+}
+
+func Test_CreateConvo_AttachmentQuota(t *testing.T) {
+	dir := setupAttachmentHandlerTest(t)
+	defer tearDownAttachmentHandlerTest(t, dir)
+
+	db.AttachmentQuota = 6
+	defer func() { db.AttachmentQuota = 100 << 20 }()
+
+	attachment := func() []*db.Attachment {
+		return []*db.Attachment{{Filename: "report.pdf", ContentType: "application/pdf", Size: 4, StorageKey: "missing"}}
+	}
+
+	if _, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "First Post", Body: "Message Body", Attachments: attachment()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attachments stored before the first convo was created are refused when the second one is
+	_, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "Second Post", Body: "Message Body", Attachments: attachment()})
+	if errgo.Cause(err) != db.ErrQuotaExceeded {
+		t.Errorf("Wrong error. Expected: %v. Actual: %v", db.ErrQuotaExceeded, err)
+	}
+
+	convos, err := db.GetConvos("1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(convos) != 1 {
+		t.Errorf("Wrong number of convos. Expected: %v. Actual: %v", 1, len(convos))
+	}
+}
+
+func Test_GetAttachment_Unauthorized(t *testing.T) {
+	dir := setupAttachmentHandlerTest(t)
+	defer tearDownAttachmentHandlerTest(t, dir)
+
+	convo, err := db.CreateConvo("1", &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body",
+		Attachments: []*db.Attachment{
+			{Filename: "report.pdf", ContentType: "application/pdf", Size: 4, StorageKey: "missing"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(false, "")
+	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Params["aid"] = strconv.Itoa(convo.Attachments[0].Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
+
+	GetAttachment(p.Params, httptest.NewRecorder(), p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
@@ -2,16 +2,27 @@ package handlers
 
 import (
 	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
 )
 
-func UserAuthorizationMiddleware(req *http.Request) {
+func UserAuthorizationMiddleware(req *http.Request, r render.Render) {
 	// This isn't a real authorization middleware.
 	// It will act like one, in the sense that if will look for some key,
 	// and use it to restrict access to certain resources
 	userKey := req.Header.Get("X-USER-API-KEY")
-	if userKey != "" {
-		userId = userKey
-	} else {
+	switch {
+	case userKey == "":
 		userId = "0"
+
+		// Responding here stops martini from calling the route's handler
+		returnError(r, errgo.WithCausef(nil, ErrUnauthenticated, "The `X-USER-API-KEY` header is required."))
+	case !isId(userKey):
+		// The key is the id of the user
+		userId = "0"
+		returnError(r, errgo.WithCausef(nil, ErrUnauthenticated, "Invalid `X-USER-API-KEY` header."))
+	default:
+		userId = userKey
 	}
 }
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+type batchRequest struct {
+	Ids    []int  `json:"ids"`
+	Action string `json:"action"`
+}
+
+func BatchConvos(req *http.Request, r render.Render) {
+	var batch *batchRequest
+	err := json.NewDecoder(req.Body).Decode(&batch)
+
+	if err != nil || batch == nil {
+		returnError(r, errgo.WithCausef(err, ErrInvalidJson, "The request body must be a JSON object."))
+		return
+	}
+
+	results, err := db.BatchConvos(userId, batch.Ids, batch.Action)
+	returnEnvelope(r, results, err)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_BatchConvos_Archive(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	first, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := second.Id + 1
+	p := generateHandlerPrerequisites(true, fmt.Sprintf(`{"ids": [%d, %d, %d], "action": "archive"}`, missing, second.Id, first.Id))
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromObj([]*db.BatchResult{
+		{Id: first.Id, Status: db.BatchSucceeded},
+		{Id: second.Id, Status: db.BatchSucceeded},
+		{Id: missing, Status: db.BatchNotFound},
+	})
+
+	BatchConvos(p.Req, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+
+	// Archived threads are no longer in the inbox
+	inbox, err := db.GetConvos("1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inbox) != 0 {
+		t.Errorf("Wrong number of convos in the inbox. Expected: %v. Actual: %v", 0, len(inbox))
+	}
+
+	archived, err := db.GetConvos("1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 2 {
+		t.Errorf("Wrong number of archived convos. Expected: %v. Actual: %v", 2, len(archived))
+	}
+}
+
+func Test_BatchConvos_Delete(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Log in as someone who cannot see the convo
+	p := generateHandlerPrerequisites(false, fmt.Sprintf(`{"ids": [%d], "action": "delete"}`, convo.Id))
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromObj([]*db.BatchResult{
+		{Id: convo.Id, Status: db.BatchNotFound},
+	})
+
+	BatchConvos(p.Req, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+
+	if _, err := db.GetConvo("1", strconv.Itoa(convo.Id)); err != nil {
+		t.Errorf("Convo was deleted by a user who cannot see it: %v", err)
+	}
+}
+
+func Test_BatchConvos_UnknownAction(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, `{"ids": [1], "action": "shred"}`)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrInvalid, "Unknown batch action 'shred'."))
+
+	BatchConvos(p.Req, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -16,65 +18,147 @@ var (
 )
 
 func returnEnvelope(r render.Render, obj interface{}, err error) {
-	switch errgo.Cause(err) {
-	case nil:
-		// We could issue more specific http status codes for 'ok' (especially when creating objects)
-		// but `ok` should be good enough for now.
-		r.JSON(http.StatusOK, NewJsonEnvelopeFromObj(obj))
-	case db.ErrNoRows:
-		r.JSON(http.StatusNotFound, NewJsonEnvelopeFromError(err))
-	case db.ErrRowScan:
-		fallthrough
-	case db.ErrRowUnknown:
-		fallthrough
-	case db.ErrRowDelete:
-		fallthrough
-	case db.ErrRowCreate:
-		fallthrough
-	case db.ErrRowUpdate:
-		r.JSON(http.StatusBadRequest, NewJsonEnvelopeFromError(err))
-	default:
-		r.JSON(http.StatusInternalServerError, NewJsonEnvelopeFromError(err))
+	if err != nil {
+		returnError(r, err)
+		return
 	}
+
+	r.JSON(http.StatusOK, NewJsonEnvelopeFromObj(obj))
 }
 
-func getConvoFromRequest(req *http.Request) (*db.Convo, error) {
-	decoder := json.NewDecoder(req.Body)
+// returnCreatedEnvelope is like `returnEnvelope`, for requests which created a new object available at `location`
+func returnCreatedEnvelope(r render.Render, location string, obj interface{}, err error) {
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	if location != "" {
+		r.Header().Set("Location", versionedLocation(r, location))
+	}
+
+	r.JSON(http.StatusCreated, NewJsonEnvelopeFromObj(obj))
+}
 
+func returnError(r render.Render, err error) {
+	logInternalError(err)
+
+	status, _ := getErrorStatus(err)
+	r.JSON(status, NewJsonEnvelopeFromError(err))
+}
+
+func logInternalError(err error) {
+	if status, _ := getErrorStatus(err); status == http.StatusInternalServerError {
+		// The client only gets a generic message, so keep the details in the logs
+		log.Printf("Internal error: %v\n", err)
+	}
+}
+
+func convoLocation(convo *db.Convo) string {
+	return fmt.Sprintf("/convos/%d/", convo.Id)
+}
+
+func getConvoFromRequest(req *http.Request) (*db.Convo, error) {
 	var convo *db.Convo
-	err := decoder.Decode(&convo)
+	var err error
 
-	return convo, err
+	// Convos with attachments are sent as a multipart form, with the convo itself as JSON in the `convo` field
+	if isMultipartRequest(req) {
+		if err = parseMultipartForm(req); err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(req.FormValue("convo")), &convo)
+	} else {
+		decoder := json.NewDecoder(req.Body)
+		err = decoder.Decode(&convo)
+	}
+
+	if err != nil || convo == nil {
+		return nil, errgo.WithCausef(err, ErrInvalidJson, "The convo must be a JSON object.")
+	}
+
+	// Attachments can only be added by uploading them
+	convo.Attachments = nil
+
+	return convo, nil
 }
 
-func getJsonFromRequest(req *http.Request) (map[string]string, error) {
+func getJsonFromRequest(req *http.Request) (map[string]interface{}, error) {
 	decoder := json.NewDecoder(req.Body)
 
-	// TODO: string to interface?
-	var jsonObj map[string]string
+	var jsonObj map[string]interface{}
 	err := decoder.Decode(&jsonObj)
 
-	return jsonObj, err
+	if err != nil || jsonObj == nil {
+		return nil, errgo.WithCausef(err, ErrInvalidJson, "The request body must be a JSON object.")
+	}
+
+	return jsonObj, nil
 }
 
-func GetConvos(r render.Render) {
-	convos, err := db.GetConvos(userId)
-	returnEnvelope(r, convos, err)
+func GetConvos(req *http.Request, r render.Render) {
+	archived := req.FormValue("archived") == "true"
+	convos, err := db.GetConvos(userId, archived)
+	returnCacheableEnvelope(req, r, convos, err)
 }
 
-func GetConvo(params martini.Params, r render.Render) {
+func GetConvo(req *http.Request, params martini.Params, r render.Render) {
 	id := params["id"]
 	convo, err := db.GetConvo(userId, id)
-	returnEnvelope(r, convo, err)
+	returnCacheableEnvelope(req, r, convo, err)
 }
 
-func DeleteConvo(params martini.Params, r render.Render) {
+func GetConvoRevisions(params martini.Params, r render.Render) {
 	id := params["id"]
-	err := db.DeleteConvo(userId, id)
+	revisions, err := db.GetConvoRevisions(userId, id)
+	returnEnvelope(r, revisions, err)
+}
+
+func DeleteConvo(req *http.Request, params martini.Params, r render.Render) {
+	precondition, err := ifMatch(req)
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	id := params["id"]
+	err = db.DeleteConvo(userId, id, precondition)
+	returnEnvelope(r, "success", err)
+}
+
+func ForwardConvo(req *http.Request, params martini.Params, r render.Render) {
+	// Only the recipient and the (optional) body are used; the body is added as a note above the forwarded message
+	convo, err := getConvoFromRequest(req)
+
+	if err != nil {
+		returnEnvelope(r, convo, err)
+		return
+	}
+
+	id := params["id"]
+	newConvo, err := db.ForwardConvo(userId, id, convo.Recipient, convo.Body)
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	returnCreatedEnvelope(r, convoLocation(newConvo), newConvo, nil)
+}
+
+func UndoConvo(params martini.Params, r render.Render) {
+	id := params["id"]
+	err := db.UndoConvo(userId, id)
 	returnEnvelope(r, "success", err)
 }
 
 func UpdateConvo(req *http.Request, params martini.Params, r render.Render) {
+	precondition, err := ifMatch(req)
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
 	patch, err := getJsonFromRequest(req)
 
 	if err != nil {
@@ -82,12 +166,25 @@ func UpdateConvo(req *http.Request, params martini.Params, r render.Render) {
 		return
 	}
 
+	convoPatch, err := newConvoPatch(patch)
+	if err != nil {
+		returnEnvelope(r, patch, err)
+		return
+	}
+
 	id := params["id"]
-	convo, err := db.UpdateConvo(userId, id, patch)
+	convo, err := db.UpdateConvo(userId, id, convoPatch, precondition)
+	if err == nil {
+		r.Header().Set("ETag", convo.ThreadETag())
+	}
+
 	returnEnvelope(r, convo, err)
 }
 
 func CreateConvo(req *http.Request, params martini.Params, r render.Render) {
+	key := req.Header.Get("Idempotency-Key")
+	fingerprint := fingerprintRequest(req, "create", params["id"])
+
 	convo, err := getConvoFromRequest(req)
 
 	if err != nil {
@@ -99,19 +196,74 @@ func CreateConvo(req *http.Request, params martini.Params, r render.Render) {
 	id, _ := strconv.Atoi(params["id"])
 	if id > 0 {
 		convo.Parent = id
+	}
+
+	// The parent may also be given in the body
+	parentId := ""
+	if convo.Parent > 0 {
+		parentId = strconv.Itoa(convo.Parent)
+	}
+
+	if convo.SendAt != nil {
+		// Scheduled convos are not remembered under a key, so a retry would schedule the convo again
+		if key != "" {
+			returnEnvelope(r, convo, errgo.WithCausef(nil, db.ErrInvalid, "Scheduled convos cannot be sent with an Idempotency-Key"))
+			return
+		}
+
+		if isMultipartRequest(req) && len(req.MultipartForm.File["attachments"]) > 0 {
+			returnEnvelope(r, convo, errgo.WithCausef(nil, db.ErrInvalid, "Convos with attachments cannot be scheduled"))
+			return
+		}
+
+		if parentId != "" {
+			if err := db.PrepareReply(userId, parentId, convo); err != nil {
+				returnEnvelope(r, convo, err)
+				return
+			}
+		}
 
-		// This incurs an extra DB call, but it seems like the simplest course of action to maintain the subject
-		parent, err := db.GetConvo(userId, params["id"])
+		scheduledConvo, err := db.ScheduleConvo(userId, convo)
 		if err != nil {
-			returnEnvelope(r, convo, err)
+			returnError(r, err)
 			return
 		}
 
-		convo.Subject = parent.Subject
+		location := fmt.Sprintf("/convos/scheduled/%d/", scheduledConvo.Id)
+		returnCreatedEnvelope(r, location, scheduledConvo, nil)
+		return
+	}
+
+	convo.Attachments, err = storeAttachments(req)
+	if err != nil {
+		returnEnvelope(r, convo, err)
+		return
+	}
+
+	var idempotencyKey *db.IdempotencyKey
+	if key != "" {
+		idempotencyKey = &db.IdempotencyKey{Key: key, Fingerprint: fingerprint()}
 	}
 
 	// TODO: Need to return the saved object from the DB...
-	newConvo, err := db.CreateConvo(userId, convo)
+	var newConvo *db.Convo
+	var replayed bool
+	if parentId != "" {
+		newConvo, replayed, err = db.Reply(userId, parentId, convo, idempotencyKey)
+	} else {
+		newConvo, replayed, err = db.CreateConvoWithIdempotencyKey(userId, convo, idempotencyKey)
+	}
+	if err != nil {
+		deleteAttachments(convo.Attachments)
+		returnError(r, err)
+		return
+	}
+
+	if replayed {
+		// The attachments were stored with the original request
+		deleteAttachments(convo.Attachments)
+		r.Header().Set("Idempotent-Replayed", "true")
+	}
 
-	returnEnvelope(r, newConvo, err)
+	returnCreatedEnvelope(r, convoLocation(newConvo), newConvo, nil)
 }
@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-martini/martini"
 	"github.com/juju/errgo"
@@ -38,12 +41,8 @@ func setupConvoHandlerTest(t *testing.T) {
 }
 
 func tearDownConvoHandlerTest(t *testing.T) {
-	tables := []string{"read_status", "convos", "users"}
-
-	for _, table := range tables {
-		if err := db.TruncateTable(table); err != nil {
-			t.Fatalf("Truncate table (%s): %s\n", table, err)
-		}
+	if err := db.TruncateAllTables(); err != nil {
+		t.Fatalf("Truncate tables: %s\n", err)
 	}
 }
 
@@ -77,7 +76,7 @@ func generateHandlerPrerequisites(authorized bool, body string) HandlerPrerequis
 	}
 
 	request := generateTestRequest(userId, body)
-	UserAuthorizationMiddleware(request)
+	UserAuthorizationMiddleware(request, &mocks.Render{})
 
 	return HandlerPrerequisites{
 		request,
@@ -108,8 +107,12 @@ func Test_CreateConvo_Authorized(t *testing.T) {
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
-	if renderer.StatusCode != http.StatusOK {
-		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	if location := renderer.Header().Get("Location"); location != "/convos/1/" {
+		t.Errorf("Wrong Location set. Expected: %v. Actual: %v", "/convos/1/", location)
 	}
 
 	if !reflect.DeepEqual(renderer.Response, expected) {
@@ -121,18 +124,78 @@ func Test_CreateConvo_Unauthorized(t *testing.T) {
 	setupConvoHandlerTest(t)
 	defer tearDownConvoHandlerTest(t)
 
-	p := generateHandlerPrerequisites(false, "")
+	req := generateTestRequest("", "")
+	r := &mocks.Render{}
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, ErrUnauthenticated, "The `X-USER-API-KEY` header is required."))
+
+	// The middleware responds before the handler is called
+	UserAuthorizationMiddleware(req, r)
+
+	// Verify the result
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnauthorized, r.StatusCode)
+	}
+
+	if !reflect.DeepEqual(r.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, r.Response)
+	}
+}
+
+func Test_UserAuthorizationMiddleware_InvalidKey(t *testing.T) {
+	req := generateTestRequest("alice", "")
+	r := &mocks.Render{}
+
+	UserAuthorizationMiddleware(req, r)
+
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnauthorized, r.StatusCode)
+	}
+
+	if userId != "0" {
+		t.Errorf("Invalid key used as the user: %v", userId)
+	}
+}
+
+func Test_IdParamsMiddleware(t *testing.T) {
+	tests := []struct {
+		params martini.Params
+		status int
+	}{
+		{martini.Params{"id": "abc"}, http.StatusBadRequest},
+		{martini.Params{"id": "1", "aid": "1.5"}, http.StatusBadRequest},
+		{martini.Params{"id": "99999999999"}, http.StatusBadRequest},
+		// The handler is called, so the middleware does not respond
+		{martini.Params{"id": "12", "did": "3"}, 0},
+		{martini.Params{}, 0},
+	}
+
+	for _, test := range tests {
+		r := &mocks.Render{}
+		IdParamsMiddleware(test.params, r)
+
+		if r.StatusCode != test.status {
+			t.Errorf("Wrong Status Code set for %v. Expected: %v. Actual: %v", test.params, test.status, r.StatusCode)
+		}
+	}
+}
+
+func Test_CreateConvo_EmptyBody(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "")
 
-	// TODO: Should this 500, or should this return 403 / 401?
-	// TODO: Why EOF?
-	expected := NewJsonEnvelopeFromError(errgo.New("EOF"))
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, ErrInvalidJson, "The convo must be a JSON object."))
 
 	CreateConvo(p.Req, p.Params, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
-	if renderer.StatusCode != http.StatusInternalServerError {
-		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusInternalServerError, renderer.StatusCode)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
 	}
 
 	if !reflect.DeepEqual(renderer.Response, expected) {
@@ -153,7 +216,7 @@ func Test_GetConvos_Authorized(t *testing.T) {
 	// Set Expectations
 	expected := NewJsonEnvelopeFromObj([]*db.Convo{convo})
 
-	GetConvos(p.Render)
+	GetConvos(p.Req, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -181,7 +244,7 @@ func Test_GetConvos_Unauthorized(t *testing.T) {
 	var emptyList []*db.Convo
 	expected := NewJsonEnvelopeFromObj(emptyList)
 
-	GetConvos(p.Render)
+	GetConvos(p.Req, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -209,7 +272,7 @@ func Test_GetConvo_Authorized(t *testing.T) {
 	// Set Expectations
 	expected := NewJsonEnvelopeFromObj(convo)
 
-	GetConvo(p.Params, p.Render)
+	GetConvo(p.Req, p.Params, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -235,9 +298,9 @@ func Test_GetConvo_Unauthorized(t *testing.T) {
 	p.Params["id"] = strconv.Itoa(convo.Id)
 
 	// Set Expectations
-	expected := NewJsonEnvelopeFromError(errgo.Newf("Unable to find convo with id '%d'.: sql: no rows in result set", convo.Id))
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
 
-	GetConvo(p.Params, p.Render)
+	GetConvo(p.Req, p.Params, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -261,11 +324,12 @@ func Test_DeleteConvo_Authorized(t *testing.T) {
 		t.Error(err)
 	}
 	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
 
 	// Set Expectations
 	expected := NewJsonEnvelopeFromObj("success")
 
-	DeleteConvo(p.Params, p.Render)
+	DeleteConvo(p.Req, p.Params, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -289,12 +353,13 @@ func Test_DeleteConvo_Unauthorized(t *testing.T) {
 		t.Error(err)
 	}
 	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
 
 	// Set Expectations
-	expected := NewJsonEnvelopeFromError(errgo.Newf("Unable to find convo with id '%d'.", convo.Id))
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
 
 	// Do what we need to do
-	DeleteConvo(p.Params, p.Render)
+	DeleteConvo(p.Req, p.Params, p.Render)
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
@@ -334,8 +399,8 @@ func Test_ReplyConvo_Authorized(t *testing.T) {
 
 	// Verify the result
 	renderer, _ := p.Render.(*mocks.Render)
-	if renderer.StatusCode != http.StatusOK {
-		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
 	}
 
 	if !reflect.DeepEqual(renderer.Response, expected) {
@@ -343,6 +408,52 @@ func Test_ReplyConvo_Authorized(t *testing.T) {
 	}
 }
 
+func Test_ReplyConvo_Recipient(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	if err := db.AddUser("3", "Carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body      string
+		status    int
+		recipient int
+	}{
+		// The reply goes to the other user of the convo when no recipient is given
+		{`{"body": "Reply"}`, http.StatusCreated, 2},
+		{`{"recipient": 2, "body": "Reply"}`, http.StatusCreated, 2},
+		{`{"recipient": 3, "body": "Reply"}`, http.StatusUnprocessableEntity, 0},
+	}
+
+	for _, test := range tests {
+		p := generateHandlerPrerequisites(true, test.body)
+		p.Params["id"] = strconv.Itoa(convo.Id)
+
+		CreateConvo(p.Req, p.Params, p.Render)
+
+		renderer, _ := p.Render.(*mocks.Render)
+		if renderer.StatusCode != test.status {
+			t.Fatalf("Wrong Status Code set for %s. Expected: %v. Actual: %v", test.body, test.status, renderer.StatusCode)
+		}
+
+		if test.status != http.StatusCreated {
+			continue
+		}
+
+		reply := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+		if reply.Recipient != test.recipient || reply.Parent != convo.Id || reply.Subject != "First Post" {
+			t.Errorf("Wrong reply for %s: %#v", test.body, reply)
+		}
+	}
+}
+
 func Test_ReplyConvo_Unauthorized(t *testing.T) {
 	setupConvoHandlerTest(t)
 	defer tearDownConvoHandlerTest(t)
@@ -359,7 +470,7 @@ func Test_ReplyConvo_Unauthorized(t *testing.T) {
 	p.Params["id"] = strconv.Itoa(convo.Id)
 
 	// Set Expectations
-	expected := NewJsonEnvelopeFromError(errgo.Newf("Unable to find convo with id '%d'.: sql: no rows in result set", convo.Id))
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
 
 	CreateConvo(p.Req, p.Params, p.Render)
 
@@ -386,6 +497,7 @@ func Test_UpdateConvo_Authorized(t *testing.T) {
 		t.Error(err)
 	}
 	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
 
 	// Set Expectations
 	patchedConvo := convo
@@ -418,9 +530,10 @@ func Test_UpdateConvo_Unauthorized(t *testing.T) {
 		t.Error(err)
 	}
 	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
 
 	// Set Expectations
-	expected := NewJsonEnvelopeFromError(errgo.Newf("Unable to find convo with id '%d'.: sql: no rows in result set", convo.Id))
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
 
 	UpdateConvo(p.Req, p.Params, p.Render)
 
@@ -459,11 +572,12 @@ func Test_Persist_Delete(t *testing.T) {
 
 	// Delete
 	p.Params["id"] = parentId
-	DeleteConvo(p.Params, p.Render)
+	p.Req.Header.Set("If-Match", "*")
+	DeleteConvo(p.Req, p.Params, p.Render)
 
 	// Get the parent
 	p.Params["id"] = parentId
-	GetConvo(p.Params, p.Render)
+	GetConvo(p.Req, p.Params, p.Render)
 
 	// Verify it no longer exists
 	renderer, _ := p.Render.(*mocks.Render)
@@ -473,7 +587,7 @@ func Test_Persist_Delete(t *testing.T) {
 
 	// Get the child
 	p.Params["id"] = childId
-	GetConvo(p.Params, p.Render)
+	GetConvo(p.Req, p.Params, p.Render)
 
 	// Verify it no longer exists
 	renderer, _ = p.Render.(*mocks.Render)
@@ -481,3 +595,736 @@ func Test_Persist_Delete(t *testing.T) {
 		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
 	}
 }
+
+func Test_UndoConvo_Authorized(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	db.UndoSendDelay = time.Minute
+	defer func() { db.UndoSendDelay = 0 }()
+
+	p := generateHandlerPrerequisites(true, "")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	if convo.UndoUntil == nil {
+		t.Errorf("Expected convo to be undoable")
+	}
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromObj("success")
+
+	UndoConvo(p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+
+	// Verify it no longer exists
+	GetConvo(p.Req, p.Params, p.Render)
+	if renderer.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
+	}
+}
+
+func Test_UndoConvo_Expired(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d' that can still be undone.", convo.Id))
+
+	UndoConvo(p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_GetConvos_HiddenFromRecipientDuringUndo(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	db.UndoSendDelay = time.Minute
+	defer func() { db.UndoSendDelay = 0 }()
+
+	if _, err := db.CreateConvo("1", firstPost); err != nil {
+		t.Error(err)
+	}
+
+	// Log in as the recipient
+	req := generateTestRequest("2", "")
+	UserAuthorizationMiddleware(req, &mocks.Render{})
+	r := &mocks.Render{}
+
+	// Set Expectations
+	var emptyList []*db.Convo
+	expected := NewJsonEnvelopeFromObj(emptyList)
+
+	GetConvos(req, r)
+
+	// Verify the result
+	if r.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, r.StatusCode)
+	}
+
+	if !reflect.DeepEqual(r.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, r.Response)
+	}
+}
+
+func Test_UpdateConvo_Edit(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	db.EditWindow = time.Minute
+	defer func() { db.EditWindow = 0 }()
+
+	p := generateHandlerPrerequisites(true, "{\"body\": \"Edited Body\"}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
+
+	UpdateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	edited := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if edited.Body != "Edited Body" || edited.EditedAt == nil {
+		t.Errorf("Convo was not edited. Actual: %#v", edited)
+	}
+
+	// The original should be kept as a revision
+	expected := NewJsonEnvelopeFromObj([]*db.Revision{
+		{Convo: convo.Id, Subject: convo.Subject, Body: convo.Body},
+	})
+
+	GetConvoRevisions(p.Params, p.Render)
+
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	// Ids and timestamps are generated by the DB
+	revisions := renderer.Response.(JsonEnvelope).Response.([]*db.Revision)
+	for _, revision := range revisions {
+		revision.Id = 0
+		revision.RevisedAt = time.Time{}
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_UpdateConvo_EditNotSender(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	db.EditWindow = time.Minute
+	defer func() { db.EditWindow = 0 }()
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Log in as the recipient
+	req := generateTestRequest("2", "{\"body\": \"Edited Body\"}")
+	req.Header.Set("If-Match", "*")
+	UserAuthorizationMiddleware(req, &mocks.Render{})
+	params := martini.Params{"id": strconv.Itoa(convo.Id)}
+	r := &mocks.Render{}
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrForbidden, "Only the sender can edit convo with id '%d'.", convo.Id))
+
+	UpdateConvo(req, params, r)
+
+	// Verify the result
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusForbidden, r.StatusCode)
+	}
+
+	if !reflect.DeepEqual(r.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, r.Response)
+	}
+}
+
+func Test_UpdateConvo_EditWindowExpired(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "{\"body\": \"Edited Body\"}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrForbidden, "Convo with id '%d' can no longer be edited.", convo.Id))
+
+	UpdateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusForbidden, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_ForwardConvo_Authorized(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	if err := db.AddUser("3", "Carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "{\"recipient\": 3, \"body\": \"FYI\"}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	ForwardConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	forward := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if forward.Recipient != 3 || forward.Parent != forward.Id || forward.Subject != "Fwd: First Post" {
+		t.Errorf("Forward is not a new thread to the new recipient. Actual: %#v", forward)
+	}
+
+	if !strings.HasPrefix(forward.Body, "FYI\n\n") || !strings.Contains(forward.Body, "\n> Message Body") {
+		t.Errorf("Forward does not quote the original. Actual: %q", forward.Body)
+	}
+
+	// The new recipient should still not be able to see the original
+	if _, err := db.GetConvo("3", strconv.Itoa(convo.Id)); errgo.Cause(err) != db.ErrNoRows {
+		t.Errorf("Wrong error for original convo. Expected: %v. Actual: %v", db.ErrNoRows, err)
+	}
+}
+
+func Test_ForwardConvo_LongBody(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	// Quoting every line makes the forward longer than the longest valid body
+	body := strings.Repeat("line\n", db.MaxBodyLength/5)
+	convo, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "First Post", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "{\"recipient\": 2, \"body\": \"FYI\"}")
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	ForwardConvo(p.Req, p.Params, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	forward := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if length := utf8.RuneCountInString(forward.Body); length > db.MaxBodyLength {
+		t.Errorf("Forward is too long. Expected at most: %v. Actual: %v", db.MaxBodyLength, length)
+	}
+
+	if !strings.HasPrefix(forward.Body, "FYI\n\n") || !strings.HasSuffix(forward.Body, "has been truncated.]") {
+		t.Errorf("Forward does not end with a truncated quote. Actual: %q", forward.Body[len(forward.Body)-100:])
+	}
+}
+
+func Test_ForwardConvo_UnknownSender(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	// User 5 has no row in `users`, which is only needed to receive convos
+	convo, err := db.CreateConvo("5", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "{\"recipient\": 2}")
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	ForwardConvo(p.Req, p.Params, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	forward := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if !strings.Contains(forward.Body, "From: user 5\n") {
+		t.Errorf("Forward does not name the sender by id. Actual: %q", forward.Body)
+	}
+}
+
+func Test_ForwardConvo_Unauthorized(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(false, "{\"recipient\": 2}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '%d'.", convo.Id))
+
+	ForwardConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_UpdateConvo_InvalidPatch(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "{\"read\": false, \"recipient\": 3, \"colour\": \"red\"}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+	p.Req.Header.Set("If-Match", "*")
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, ErrInvalidPatch, "Unable to apply patch. Unknown fields: colour. Immutable fields: recipient."))
+
+	UpdateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnprocessableEntity, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+
+	// Nothing should have been applied
+	unchanged, err := db.GetConvo("1", strconv.Itoa(convo.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !unchanged.Read {
+		t.Errorf("Convo was marked as unread by a rejected patch")
+	}
+}
+
+func Test_CreateConvo_Invalid(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	post := &db.Convo{
+		Recipient: 1, Subject: "", Body: strings.Repeat("a", db.MaxBodyLength+1),
+	}
+	p := generateHandlerPrerequisites(true, post.ToJson())
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(&db.ValidationError{Fields: []*db.FieldError{
+		{Field: "subject", Code: "required", Message: "The subject is required."},
+		{Field: "body", Code: "too_long", Message: "The body must be at most 64000 characters."},
+		{Field: "recipient", Code: "invalid", Message: "You cannot send a convo to yourself."},
+	}})
+
+	CreateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnprocessableEntity, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_CreateConvo_UnknownRecipient(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	post := &db.Convo{
+		Recipient: 42, Subject: "First Post", Body: "Message Body",
+	}
+	p := generateHandlerPrerequisites(true, post.ToJson())
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(&db.ValidationError{Fields: []*db.FieldError{
+		{Field: "recipient", Code: "not_found", Message: "Unable to find recipient with id '42'."},
+	}})
+
+	CreateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnprocessableEntity, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_CreateConvo_IdempotencyKey(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body",
+	}
+
+	var responses []*db.Convo
+	for i := 0; i < 2; i++ {
+		p := generateHandlerPrerequisites(true, post.ToJson())
+		p.Req.Header.Set("Idempotency-Key", "retry-me")
+
+		CreateConvo(p.Req, p.Params, p.Render)
+
+		renderer, _ := p.Render.(*mocks.Render)
+		if renderer.StatusCode != http.StatusCreated {
+			t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+		}
+
+		replayed := renderer.Header().Get("Idempotent-Replayed") == "true"
+		if replayed != (i > 0) {
+			t.Errorf("Wrong replay status for request %d. Actual: %v", i, replayed)
+		}
+
+		responses = append(responses, renderer.Response.(JsonEnvelope).Response.(*db.Convo))
+	}
+
+	if responses[0].Id != responses[1].Id {
+		t.Errorf("Retry created a new convo. Expected: %v. Actual: %v", responses[0].Id, responses[1].Id)
+	}
+
+	convos, err := db.GetConvos("1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(convos) != 1 {
+		t.Errorf("Wrong number of convos. Expected: %v. Actual: %v", 1, len(convos))
+	}
+}
+
+func Test_CreateConvo_IdempotencyKeyConflict(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	first := generateHandlerPrerequisites(true, firstPost.ToJson())
+	first.Req.Header.Set("Idempotency-Key", "retry-me")
+	CreateConvo(first.Req, first.Params, first.Render)
+
+	post := &db.Convo{
+		Recipient: 2, Subject: "Second Post", Body: "Message Body",
+	}
+	p := generateHandlerPrerequisites(true, post.ToJson())
+	p.Req.Header.Set("Idempotency-Key", "retry-me")
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrConflict, "The idempotency key 'retry-me' was already used for a different request."))
+
+	CreateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusConflict {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusConflict, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_CreateConvo_IdempotencyKeyScheduled(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	sendAt := time.Now().Add(time.Hour)
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body", SendAt: &sendAt,
+	}
+	p := generateHandlerPrerequisites(true, post.ToJson())
+	p.Req.Header.Set("Idempotency-Key", "retry-me")
+
+	CreateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
+	}
+
+	scheduled, err := db.GetScheduledConvos("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scheduled) != 0 {
+		t.Errorf("Convo was scheduled: %+v", scheduled)
+	}
+}
+
+func Test_GetConvo_NotModified(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	GetConvo(p.Req, p.Params, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	etag := renderer.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("No ETag set")
+	}
+
+	// Asking again with the same ETag should not send the convo
+	p.Req.Header.Set("If-None-Match", etag)
+	renderer.Response = nil
+
+	GetConvo(p.Req, p.Params, p.Render)
+
+	if renderer.StatusCode != http.StatusNotModified {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotModified, renderer.StatusCode)
+	}
+
+	if renderer.Response != nil {
+		t.Errorf("Expected no response body. Actual: %#v", renderer.Response)
+	}
+}
+
+func Test_UpdateConvo_NewReply(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(convo.Id)
+
+	get := generateHandlerPrerequisites(true, "")
+	get.Params["id"] = id
+	GetConvo(get.Req, get.Params, get.Render)
+
+	renderer, _ := get.Render.(*mocks.Render)
+	etag := renderer.Header().Get("ETag")
+
+	if _, err := db.CreateConvo("2", &db.Convo{Parent: convo.Id, Recipient: 1, Subject: "Re", Body: "Reply"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The thread has changed, so it is sent again
+	get.Req.Header.Set("If-None-Match", etag)
+	GetConvo(get.Req, get.Params, get.Render)
+
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	// But the convo itself has not, so it can still be changed
+	p := generateHandlerPrerequisites(true, "{\"archived\": true}")
+	p.Params["id"] = id
+	p.Req.Header.Set("If-Match", etag)
+
+	UpdateConvo(p.Req, p.Params, p.Render)
+
+	renderer, _ = p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+}
+
+func Test_UpdateConvo_PreconditionRequired(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "{\"read\": false}")
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	p.Params["id"] = strconv.Itoa(convo.Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, ErrPreconditionRequired, "The `If-Match` header is required."))
+
+	UpdateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusPreconditionRequired, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_UpdateConvo_PreconditionFailed(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Error(err)
+	}
+	id := strconv.Itoa(convo.Id)
+
+	// Both devices start from the same version
+	current, err := db.GetConvo("1", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := current.ETag()
+
+	first := generateHandlerPrerequisites(true, "{\"read\": false}")
+	first.Params["id"] = id
+	first.Req.Header.Set("If-Match", etag)
+
+	UpdateConvo(first.Req, first.Params, first.Render)
+
+	renderer, _ := first.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	if renderer.Header().Get("ETag") == etag {
+		t.Errorf("ETag did not change after the convo was updated")
+	}
+
+	// The second device has not seen the first change
+	second := generateHandlerPrerequisites(true, "{\"read\": true}")
+	second.Params["id"] = id
+	second.Req.Header.Set("If-Match", etag)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrPreconditionFailed, "Convo with id '%s' has changed since it was retrieved.", id))
+
+	UpdateConvo(second.Req, second.Params, second.Render)
+
+	renderer, _ = second.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusPreconditionFailed, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_GetConvo_Replies(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	thread, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Parent: thread.Id, Subject: thread.Subject, Body: "Reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Parent: reply.Id, Subject: thread.Subject, Body: "Nested Reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "")
+	p.Params["id"] = strconv.Itoa(thread.Id)
+
+	GetConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	convo := renderer.Response.(JsonEnvelope).Response.(*db.Convo)
+	if len(convo.Children) != 1 || convo.Children[0].Id != reply.Id {
+		t.Fatalf("Wrong replies. Expected: [%d]. Actual: %#v", reply.Id, convo.Children)
+	}
+
+	if replies := convo.Children[0].Children; len(replies) != 1 || replies[0].Id != nested.Id {
+		t.Errorf("Wrong nested replies. Expected: [%d]. Actual: %#v", nested.Id, replies)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+func GetEmailSettings(r render.Render) {
+	settings, err := db.GetEmailSettings(userId)
+	returnEnvelope(r, settings, err)
+}
+
+func UpdateEmailSettings(req *http.Request, r render.Render) {
+	var patch *db.EmailSettingsPatch
+	err := json.NewDecoder(req.Body).Decode(&patch)
+
+	if err != nil || patch == nil {
+		returnError(r, errgo.WithCausef(err, ErrInvalidJson, "The settings must be a JSON object."))
+		return
+	}
+
+	settings, err := db.UpdateEmailSettings(userId, patch)
+	returnEnvelope(r, settings, err)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_UpdateEmailSettings_InvalidJson(t *testing.T) {
+	p := generateHandlerPrerequisites(true, `"off"`)
+
+	UpdateEmailSettings(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
+	}
+}
+
+func Test_UpdateEmailSettings(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	if err := db.SetUserEmail("1", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "")
+	GetEmailSettings(p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	settings := renderer.Response.(JsonEnvelope).Response.(*db.EmailSettings)
+	if settings.Email == nil || *settings.Email != "alice@example.com" || !settings.Notifications {
+		t.Errorf("Wrong settings: %+v", settings)
+	}
+
+	p = generateHandlerPrerequisites(true, `{"notifications":false}`)
+	UpdateEmailSettings(p.Req, p.Render)
+
+	renderer, _ = p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	if settings := renderer.Response.(JsonEnvelope).Response.(*db.EmailSettings); settings.Notifications {
+		t.Errorf("Notifications were not turned off: %+v", settings)
+	}
+
+	// Settings which are left out are not changed
+	p = generateHandlerPrerequisites(true, `{}`)
+	UpdateEmailSettings(p.Req, p.Render)
+
+	renderer, _ = p.Render.(*mocks.Render)
+	if settings := renderer.Response.(JsonEnvelope).Response.(*db.EmailSettings); settings.Notifications {
+		t.Errorf("Notifications were turned back on: %+v", settings)
+	}
+}
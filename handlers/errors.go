@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+)
+
+var (
+	ErrInvalidJson     = errgo.New("Invalid JSON")
+	ErrUnauthenticated = errgo.New("Unauthenticated")
+
+	ErrPreconditionRequired = errgo.New("Precondition Required")
+)
+
+const (
+	internalErrorMessage = "An unexpected error occurred."
+)
+
+// getErrorStatus returns the HTTP status code and the machine-readable error code to send to clients for an error.
+// Error codes are part of the API, so they must never change once added.
+func getErrorStatus(err error) (int, string) {
+	cause := errgo.Cause(err)
+	if _, ok := cause.(*db.ValidationError); ok {
+		return http.StatusUnprocessableEntity, "invalid_fields"
+	}
+
+	switch cause {
+	case db.ErrNoRows:
+		return http.StatusNotFound, "not_found"
+	case db.ErrForbidden:
+		return http.StatusForbidden, "forbidden"
+	case db.ErrConflict:
+		return http.StatusConflict, "conflict"
+	case db.ErrPreconditionFailed:
+		return http.StatusPreconditionFailed, "precondition_failed"
+	case ErrPreconditionRequired:
+		return http.StatusPreconditionRequired, "precondition_required"
+	case db.ErrInvalid:
+		return http.StatusBadRequest, "invalid_request"
+	case db.ErrGone:
+		return http.StatusGone, "gone"
+	case ErrInvalidJson:
+		return http.StatusBadRequest, "invalid_json"
+	case ErrUnauthenticated:
+		return http.StatusUnauthorized, "unauthenticated"
+	case ErrInvalidPatch:
+		return http.StatusUnprocessableEntity, "invalid_patch"
+	case ErrAttachmentTooLarge, db.ErrQuotaExceeded:
+		return http.StatusRequestEntityTooLarge, "too_large"
+	default:
+		return http.StatusInternalServerError, "internal_error"
+	}
+}
+
+// getErrorMessage returns the message to send to clients for an error.
+// Internal errors can contain details about the database, so their message is never sent to clients.
+func getErrorMessage(err error) string {
+	if status, _ := getErrorStatus(err); status == http.StatusInternalServerError {
+		return internalErrorMessage
+	}
+
+	// Only keep our own message, not the driver's message from the underlying error
+	if e, ok := err.(interface {
+		Message() string
+	}); ok && e.Message() != "" {
+		return e.Message()
+	}
+
+	return err.Error()
+}
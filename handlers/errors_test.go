@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+)
+
+func Test_NewJsonEnvelopeFromError(t *testing.T) {
+	driverErr := errors.New("pq: relation \"convos\" does not exist")
+
+	tests := []struct {
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{
+			errgo.WithCausef(driverErr, db.ErrNoRows, "Unable to find convo with id '1'."),
+			http.StatusNotFound, "not_found", "Unable to find convo with id '1'.",
+		},
+		{
+			errgo.WithCausef(driverErr, ErrInvalidJson, "The convo must be a JSON object."),
+			http.StatusBadRequest, "invalid_json", "The convo must be a JSON object.",
+		},
+		{
+			errgo.WithCausef(nil, ErrUnauthenticated, "The `X-USER-API-KEY` header is required."),
+			http.StatusUnauthorized, "unauthenticated", "The `X-USER-API-KEY` header is required.",
+		},
+		{
+			errgo.WithCausef(nil, db.ErrConflict, "Already exists."),
+			http.StatusConflict, "conflict", "Already exists.",
+		},
+		{
+			errgo.WithCausef(nil, db.ErrQuotaExceeded, "Attachments would exceed the quota of 100 bytes."),
+			http.StatusRequestEntityTooLarge, "too_large", "Attachments would exceed the quota of 100 bytes.",
+		},
+		{
+			errgo.WithCausef(nil, db.ErrPreconditionFailed, "Convo with id '1' has changed since it was retrieved."),
+			http.StatusPreconditionFailed, "precondition_failed", "Convo with id '1' has changed since it was retrieved.",
+		},
+		{
+			errgo.WithCausef(nil, db.ErrGone, "Sync token '1' has expired. Sync again without a token."),
+			http.StatusGone, "gone", "Sync token '1' has expired. Sync again without a token.",
+		},
+		{
+			errgo.WithCausef(nil, ErrPreconditionRequired, "The `If-Match` header is required."),
+			http.StatusPreconditionRequired, "precondition_required", "The `If-Match` header is required.",
+		},
+		{
+			&db.ValidationError{Fields: []*db.FieldError{{Field: "body", Code: "required", Message: "The body is required."}}},
+			http.StatusUnprocessableEntity, "invalid_fields", "The body is required.",
+		},
+		// Internal errors must not leak details about the database
+		{
+			errgo.WithCausef(driverErr, db.ErrRowScan, "Error Scanning Row"),
+			http.StatusInternalServerError, "internal_error", internalErrorMessage,
+		},
+		{
+			driverErr,
+			http.StatusInternalServerError, "internal_error", internalErrorMessage,
+		},
+	}
+
+	for _, test := range tests {
+		status, _ := getErrorStatus(test.err)
+		if status != test.status {
+			t.Errorf("Wrong status for %v. Expected: %v. Actual: %v", test.err, test.status, status)
+		}
+
+		envelope := NewJsonEnvelopeFromError(test.err)
+		jsonErr := envelope.Error.(*JsonError)
+		if jsonErr.Code != test.code || jsonErr.Message != test.message {
+			t.Errorf("Wrong error for %v.\nExpected: %v %q\nActual  : %v %q", test.err, test.code, test.message, jsonErr.Code, jsonErr.Message)
+		}
+	}
+}
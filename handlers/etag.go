@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+// returnCacheableEnvelope is like `returnEnvelope`, but tags the response with an ETag so that clients can make
+// conditional requests. If the client already has the current version, only a `304 Not Modified` is sent.
+func returnCacheableEnvelope(req *http.Request, r render.Render, obj interface{}, err error) {
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	etag := db.ETag(obj)
+	if convo, ok := obj.(*db.Convo); ok {
+		etag = convo.ThreadETag()
+	}
+	r.Header().Set("ETag", etag)
+
+	// Responses depend on the user, so shared caches must not store them
+	r.Header().Set("Cache-Control", "private, no-cache")
+
+	if header := req.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		r.Status(http.StatusNotModified)
+		return
+	}
+
+	r.JSON(http.StatusOK, NewJsonEnvelopeFromObj(obj))
+}
+
+// ifMatch returns the precondition given by the `If-Match` header.
+// The header is required to change a convo, so that a client cannot overwrite changes it has not seen yet.
+func ifMatch(req *http.Request) (db.Precondition, error) {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		return nil, errgo.WithCausef(nil, ErrPreconditionRequired, "The `If-Match` header is required.")
+	}
+
+	return convoPrecondition(header), nil
+}
+
+// convoPrecondition returns a precondition which holds if the convo still has one of the ETags listed in `header`.
+// The tag of a thread also covers its replies, which don't stop the convo itself from being changed.
+func convoPrecondition(header string) db.Precondition {
+	var own []string
+	for _, candidate := range strings.Split(header, ",") {
+		own = append(own, db.OwnETag(strings.TrimSpace(candidate)))
+	}
+
+	return func(etag string) bool {
+		return etagMatches(strings.Join(own, ","), etag, false)
+	}
+}
+
+// etagMatches reports whether an ETag is one of those listed in an `If-Match` or `If-None-Match` header.
+// `If-None-Match` uses the weak comparison, which ignores the `W/` prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
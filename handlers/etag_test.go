@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/nt3rp/convos/db"
+)
+
+func Test_EtagMatches(t *testing.T) {
+	etag := `"abc"`
+
+	tests := []struct {
+		header   string
+		weak     bool
+		expected bool
+	}{
+		{`"abc"`, false, true},
+		{`"xyz"`, false, false},
+		{`"xyz", "abc"`, false, true},
+		{`*`, false, true},
+		{`W/"abc"`, false, false},
+		{`W/"abc"`, true, true},
+		{`W/"xyz", W/"abc"`, true, true},
+	}
+
+	for _, test := range tests {
+		if actual := etagMatches(test.header, etag, test.weak); actual != test.expected {
+			t.Errorf("Wrong match for %q (weak: %v). Expected: %v. Actual: %v", test.header, test.weak, test.expected, actual)
+		}
+	}
+}
+
+func Test_ThreadETag(t *testing.T) {
+	convo := &db.Convo{Id: 1, Parent: 1, Subject: "Hello", Body: "Lunch?"}
+	own, thread := convo.ETag(), convo.ThreadETag()
+
+	if db.OwnETag(thread) != own || db.OwnETag(own) != own {
+		t.Errorf("Wrong own ETag for %s. Expected: %s. Actual: %s", thread, own, db.OwnETag(thread))
+	}
+
+	convo.Children = []*db.Convo{{Id: 2, Parent: 1, Subject: "Hello", Body: "Sure"}}
+	if convo.ETag() != own {
+		t.Errorf("ETag changed with a new reply")
+	}
+
+	if convo.ThreadETag() == thread {
+		t.Errorf("Thread ETag did not change with a new reply")
+	}
+
+	convo.Read = true
+	if convo.ETag() == own {
+		t.Errorf("ETag did not change with the read status")
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+)
+
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaString, &graphqlResolver{}, graphql.MaxParallelism(20))
+
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL runs a GraphQL query for the authenticated user.
+// As is usual for GraphQL, the response is not wrapped in an envelope, and errors are listed in its `errors` field.
+func GraphQL(req *http.Request, r render.Render) {
+	var params *graphqlParams
+	err := json.NewDecoder(req.Body).Decode(&params)
+
+	if err != nil || params == nil {
+		returnError(r, errgo.WithCausef(err, ErrInvalidJson, "The request body must be a JSON object."))
+		return
+	}
+
+	ctx := newGraphQLContext(req.Context(), userId)
+	r.JSON(http.StatusOK, graphqlSchema.Exec(ctx, params.Query, params.OperationName, params.Variables))
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+// runGraphQL runs a query as user 1, decoding its data into `data` and returning the codes of its errors
+func runGraphQL(t *testing.T, query string, data interface{}) []interface{} {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	p := generateHandlerPrerequisites(true, string(body))
+
+	GraphQL(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	response := renderer.Response.(*graphql.Response)
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []interface{}
+	for _, err := range response.Errors {
+		codes = append(codes, err.Extensions["code"])
+	}
+
+	return codes
+}
+
+func Test_GraphQL_Convo(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := db.CreateConvo("1", &db.Convo{Parent: convo.Id, Recipient: 2, Subject: "First Post", Body: "Reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data struct {
+		Convo struct {
+			Subject   string
+			Sender    struct{ Name string }
+			Recipient struct{ Name string }
+			Replies   []struct{ ID string }
+		}
+	}
+
+	query := fmt.Sprintf(`{ convo(id: "%d") { subject sender { name } recipient { name } replies { id } } }`, convo.Id)
+	if codes := runGraphQL(t, query, &data); len(codes) != 0 {
+		t.Fatalf("Unexpected errors: %v", codes)
+	}
+
+	if data.Convo.Subject != firstPost.Subject || data.Convo.Sender.Name != "Alice" || data.Convo.Recipient.Name != "Bob" {
+		t.Errorf("Wrong convo returned: %+v", data.Convo)
+	}
+
+	if len(data.Convo.Replies) != 1 || data.Convo.Replies[0].ID != fmt.Sprint(reply.Id) {
+		t.Errorf("Wrong replies returned: %+v", data.Convo.Replies)
+	}
+}
+
+func Test_GraphQL_OtherUsersConvo(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "Private", Body: "Not for you"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Log in as someone who is neither the sender nor the recipient
+	if err := db.AddUser("3", "Carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"query": fmt.Sprintf(`{ convo(id: "%d") { subject } }`, convo.Id)})
+	req := generateTestRequest("3", string(body))
+	UserAuthorizationMiddleware(req, &mocks.Render{})
+	renderer := &mocks.Render{}
+
+	GraphQL(req, renderer)
+
+	response := renderer.Response.(*graphql.Response)
+	if len(response.Errors) != 1 || response.Errors[0].Extensions["code"] != "not_found" {
+		t.Errorf("Wrong errors returned: %v", response.Errors)
+	}
+}
+
+func Test_GraphQL_Inbox(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		convo, err := db.CreateConvo("1", firstPost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append([]string{fmt.Sprint(convo.Id)}, ids...)
+	}
+
+	type page struct {
+		Inbox struct {
+			Edges    []struct{ Node struct{ ID string } }
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   string
+			}
+		}
+	}
+
+	var seen []string
+	after := ""
+	for i := 0; i < 2; i++ {
+		var data page
+		query := fmt.Sprintf(`{ inbox(first: 2, after: %q) { edges { node { id } } pageInfo { hasNextPage endCursor } } }`, after)
+		if after == "" {
+			query = `{ inbox(first: 2) { edges { node { id } } pageInfo { hasNextPage endCursor } } }`
+		}
+
+		if codes := runGraphQL(t, query, &data); len(codes) != 0 {
+			t.Fatalf("Unexpected errors: %v", codes)
+		}
+
+		for _, edge := range data.Inbox.Edges {
+			seen = append(seen, edge.Node.ID)
+		}
+
+		if data.Inbox.PageInfo.HasNextPage != (i == 0) {
+			t.Errorf("Wrong hasNextPage for page %d: %v", i, data.Inbox.PageInfo.HasNextPage)
+		}
+		after = data.Inbox.PageInfo.EndCursor
+	}
+
+	if !reflect.DeepEqual(seen, ids) {
+		t.Errorf("Wrong convos returned. Expected: %v. Actual: %v", ids, seen)
+	}
+}
+
+func Test_GraphQL_UpdateConvo_PreconditionFailed(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data interface{}
+	query := fmt.Sprintf(`mutation { updateConvo(id: "%d", etag: "\"stale\"", body: "Changed") { body } }`, convo.Id)
+	codes := runGraphQL(t, query, &data)
+
+	if !reflect.DeepEqual(codes, []interface{}{"precondition_failed"}) {
+		t.Errorf("Wrong errors returned: %v", codes)
+	}
+}
+
+func Test_GraphQL_InboxEtag(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data struct {
+		Inbox struct {
+			Edges []struct{ Node struct{ Etag string } }
+		}
+	}
+
+	if codes := runGraphQL(t, `{ inbox { edges { node { etag } } } }`, &data); len(codes) != 0 {
+		t.Fatalf("Unexpected errors: %v", codes)
+	}
+
+	// The tag of a convo in the inbox can be used to change it
+	current, err := db.GetConvo("1", fmt.Sprint(convo.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Inbox.Edges) != 1 || data.Inbox.Edges[0].Node.Etag != current.ETag() {
+		t.Errorf("Wrong etag returned. Expected: %v. Actual: %+v", current.ETag(), data.Inbox.Edges)
+	}
+}
+
+func Test_GraphQL_InvalidId(t *testing.T) {
+	var data interface{}
+	codes := runGraphQL(t, `{ convo(id: "abc") { subject } }`, &data)
+
+	if !reflect.DeepEqual(codes, []interface{}{"invalid_request"}) {
+		t.Errorf("Wrong errors returned: %v", codes)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// loaderWait is how long a loader collects keys before fetching them.
+// GraphQL resolves the fields of a list concurrently, so this is enough for every item of a list to ask for its key.
+var loaderWait = time.Millisecond
+
+type batchFunc func(keys []int) (map[int]interface{}, error)
+
+// batchLoader fetches the values loaded by concurrent resolvers with a single call, to avoid running one query per
+// item of a list. Values are cached for the lifetime of the loader, which is a single GraphQL request.
+type batchLoader struct {
+	fetch batchFunc
+
+	mu    sync.Mutex
+	cache map[int]*loaderBatch
+	next  *loaderBatch
+}
+
+type loaderBatch struct {
+	keys   []int
+	values map[int]interface{}
+	err    error
+	done   chan struct{}
+}
+
+func newBatchLoader(fetch batchFunc) *batchLoader {
+	return &batchLoader{fetch: fetch, cache: map[int]*loaderBatch{}}
+}
+
+// Load returns the value for a key, or nil if `fetch` did not return one
+func (l *batchLoader) Load(key int) (interface{}, error) {
+	l.mu.Lock()
+	b, ok := l.cache[key]
+	if !ok {
+		if l.next == nil {
+			l.next = &loaderBatch{done: make(chan struct{})}
+			time.AfterFunc(loaderWait, l.dispatch)
+		}
+
+		b = l.next
+		b.keys = append(b.keys, key)
+		l.cache[key] = b
+	}
+	l.mu.Unlock()
+
+	<-b.done
+	return b.values[key], b.err
+}
+
+// Prime caches a value which was already fetched some other way
+func (l *batchLoader) Prime(key int, value interface{}) {
+	b := &loaderBatch{values: map[int]interface{}{key: value}, done: make(chan struct{})}
+	close(b.done)
+
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok {
+		l.cache[key] = b
+	}
+	l.mu.Unlock()
+}
+
+func (l *batchLoader) dispatch() {
+	l.mu.Lock()
+	b := l.next
+	l.next = nil
+	l.mu.Unlock()
+
+	b.values, b.err = l.fetch(b.keys)
+	close(b.done)
+}
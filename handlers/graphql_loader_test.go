@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func Test_BatchLoader(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+
+	loader := newBatchLoader(func(keys []int) (map[int]interface{}, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+
+		values := map[int]interface{}{}
+		for _, key := range keys {
+			if key != 3 {
+				values[key] = key * 10
+			}
+		}
+		return values, nil
+	})
+	loader.Prime(4, 400)
+
+	keys := []int{1, 2, 2, 3, 4}
+	values := make([]interface{}, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i, key int) {
+			defer wg.Done()
+			value, err := loader.Load(key)
+			if err != nil {
+				t.Error(err)
+			}
+			values[i] = value
+		}(i, key)
+	}
+	wg.Wait()
+
+	expected := []interface{}{10, 20, 20, nil, 400}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Wrong values loaded.\nExpected: %v\nActual  : %v", expected, values)
+	}
+
+	// Every key which was not cached was fetched in a single batch, once
+	if len(batches) != 1 {
+		t.Fatalf("Wrong number of batches. Expected: %v. Actual: %v", 1, len(batches))
+	}
+
+	sort.Ints(batches[0])
+	if !reflect.DeepEqual(batches[0], []int{1, 2, 3}) {
+		t.Errorf("Wrong keys fetched. Expected: %v. Actual: %v", []int{1, 2, 3}, batches[0])
+	}
+
+	// Later loads are served from the cache
+	if value, _ := loader.Load(1); value != 10 || len(batches) != 1 {
+		t.Errorf("Cached value was fetched again")
+	}
+}
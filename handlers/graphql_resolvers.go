@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+)
+
+const (
+	defaultInboxPageSize = 20
+	maxInboxPageSize     = 100
+)
+
+type graphqlContextKey struct{}
+
+// graphqlRequest holds what the resolvers of a single GraphQL request share: the user making it, and the loaders
+// which batch the queries of its nested fields.
+type graphqlRequest struct {
+	userId      string
+	users       *batchLoader
+	replies     *batchLoader
+	attachments *batchLoader
+}
+
+func newGraphQLContext(ctx context.Context, userId string) context.Context {
+	r := &graphqlRequest{userId: userId}
+
+	r.users = newBatchLoader(func(ids []int) (map[int]interface{}, error) {
+		users, err := db.GetUsers(ids)
+		values := map[int]interface{}{}
+		for id, user := range users {
+			values[id] = user
+		}
+		return values, err
+	})
+
+	// Only convos the user can see get a resolver, so it is safe to load their replies and attachments by id
+	r.replies = newBatchLoader(func(ids []int) (map[int]interface{}, error) {
+		replies, err := db.GetReplies(userId, ids)
+		values := map[int]interface{}{}
+		for id, children := range replies {
+			values[id] = children
+		}
+		return values, err
+	})
+
+	r.attachments = newBatchLoader(func(ids []int) (map[int]interface{}, error) {
+		attachments, err := db.GetAttachmentsByConvo(ids)
+		values := map[int]interface{}{}
+		for id, as := range attachments {
+			values[id] = as
+		}
+		return values, err
+	})
+
+	return context.WithValue(ctx, graphqlContextKey{}, r)
+}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlContextKey{}).(*graphqlRequest)
+}
+
+// graphqlError gives errors the same message and code as they have in the REST API
+type graphqlError struct {
+	err error
+}
+
+func newGraphQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	logInternalError(err)
+	return &graphqlError{err}
+}
+
+func (e *graphqlError) Error() string {
+	return getErrorMessage(e.err)
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	_, code := getErrorStatus(e.err)
+	return map[string]interface{}{"code": code}
+}
+
+type graphqlResolver struct{}
+
+func (r *graphqlResolver) Me(ctx context.Context) (*userResolver, error) {
+	id, _ := strconv.Atoi(graphqlRequestFrom(ctx).userId)
+	return loadUser(ctx, id)
+}
+
+func (r *graphqlResolver) Convo(ctx context.Context, args struct{ ID graphql.ID }) (*convoResolver, error) {
+	id, err := convoId(args.ID)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	req := graphqlRequestFrom(ctx)
+	convo, err := db.GetConvo(req.userId, id)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	return newFullConvoResolver(req, convo), nil
+}
+
+func (r *graphqlResolver) Inbox(ctx context.Context, args struct {
+	First    *int32
+	After    *string
+	Archived *bool
+}) (*convoConnectionResolver, error) {
+	first := defaultInboxPageSize
+	if args.First != nil {
+		first = int(*args.First)
+	}
+
+	if first < 0 || first > maxInboxPageSize {
+		return nil, newGraphQLError(errgo.WithCausef(nil, db.ErrInvalid, "`first` must be between 0 and %d.", maxInboxPageSize))
+	}
+
+	after := 0
+	if args.After != nil {
+		var err error
+		if after, err = decodeCursor(*args.After); err != nil {
+			return nil, newGraphQLError(err)
+		}
+	}
+
+	// One more thread than asked for tells whether there is another page
+	req := graphqlRequestFrom(ctx)
+	convos, err := db.GetConvosPage(req.userId, args.Archived != nil && *args.Archived, after, first+1)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	conn := &convoConnectionResolver{edges: []*convoEdgeResolver{}, hasNextPage: len(convos) > first}
+	if conn.hasNextPage {
+		convos = convos[:first]
+	}
+
+	for _, c := range convos {
+		conn.edges = append(conn.edges, &convoEdgeResolver{cursor: encodeCursor(c.Id), node: &convoResolver{convo: c}})
+	}
+
+	return conn, nil
+}
+
+func (r *graphqlResolver) CreateConvo(ctx context.Context, args struct {
+	Recipient graphql.ID
+	Subject   string
+	Body      string
+}) (*convoResolver, error) {
+	recipient, err := strconv.Atoi(string(args.Recipient))
+	if err != nil {
+		return nil, newGraphQLError(errgo.WithCausef(err, db.ErrInvalid, "Invalid recipient '%s'.", args.Recipient))
+	}
+
+	req := graphqlRequestFrom(ctx)
+	convo, err := db.CreateConvo(req.userId, &db.Convo{Recipient: recipient, Subject: args.Subject, Body: args.Body})
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	return &convoResolver{convo: convo}, nil
+}
+
+func (r *graphqlResolver) Reply(ctx context.Context, args struct {
+	ID   graphql.ID
+	Body string
+}) (*convoResolver, error) {
+	id, err := convoId(args.ID)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	req := graphqlRequestFrom(ctx)
+	convo, _, err := db.Reply(req.userId, id, &db.Convo{Body: args.Body}, nil)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	return &convoResolver{convo: convo}, nil
+}
+
+func (r *graphqlResolver) UpdateConvo(ctx context.Context, args struct {
+	ID       graphql.ID
+	Etag     string
+	Read     *bool
+	Archived *bool
+	Subject  *string
+	Body     *string
+}) (*convoResolver, error) {
+	id, err := convoId(args.ID)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	patch := &db.ConvoPatch{Read: args.Read, Archived: args.Archived, Subject: args.Subject, Body: args.Body}
+
+	req := graphqlRequestFrom(ctx)
+	convo, err := db.UpdateConvo(req.userId, id, patch, convoPrecondition(args.Etag))
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	return newFullConvoResolver(req, convo), nil
+}
+
+func (r *graphqlResolver) DeleteConvo(ctx context.Context, args struct {
+	ID   graphql.ID
+	Etag string
+}) (bool, error) {
+	id, err := convoId(args.ID)
+	if err != nil {
+		return false, newGraphQLError(err)
+	}
+
+	req := graphqlRequestFrom(ctx)
+	if err := db.DeleteConvo(req.userId, id, convoPrecondition(args.Etag)); err != nil {
+		return false, newGraphQLError(err)
+	}
+
+	return true, nil
+}
+
+// convoId returns the id of a convo given as an argument, which must be an integer like in the REST API
+func convoId(id graphql.ID) (string, error) {
+	if !isId(string(id)) {
+		return "", errgo.WithCausef(nil, db.ErrInvalid, "Invalid id '%s'.", id)
+	}
+
+	return string(id), nil
+}
+
+// Cursors are opaque to clients, so that they can change without breaking them
+func encodeCursor(id int) string {
+	return base64.URLEncoding.EncodeToString([]byte("convo:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.URLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), "convo:") {
+		if id, err := strconv.Atoi(strings.TrimPrefix(string(b), "convo:")); err == nil {
+			return id, nil
+		}
+	}
+
+	return 0, errgo.WithCausef(err, db.ErrInvalid, "Invalid cursor '%s'.", cursor)
+}
+
+type userResolver struct {
+	user *db.User
+}
+
+func loadUser(ctx context.Context, id int) (*userResolver, error) {
+	user, err := graphqlRequestFrom(ctx).users.Load(id)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	// The API key is not checked against the users table, so the authenticated user may not have a row
+	if user == nil {
+		return &userResolver{&db.User{Id: id}}, nil
+	}
+
+	return &userResolver{user.(*db.User)}, nil
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.user.Id))
+}
+
+func (r *userResolver) Name() *string {
+	if r.user.Name == "" {
+		return nil
+	}
+
+	return &r.user.Name
+}
+
+type convoResolver struct {
+	convo *db.Convo
+}
+
+// newFullConvoResolver returns the resolver of a convo returned by `db.GetConvo`, whose replies and attachments
+// were loaded along with it
+func newFullConvoResolver(req *graphqlRequest, convo *db.Convo) *convoResolver {
+	req.attachments.Prime(convo.Id, convo.Attachments)
+	primeReplies(req, convo)
+
+	return &convoResolver{convo: convo}
+}
+
+func primeReplies(req *graphqlRequest, convo *db.Convo) {
+	req.replies.Prime(convo.Id, convo.Children)
+	for _, child := range convo.Children {
+		primeReplies(req, child)
+	}
+}
+
+func (r *convoResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.convo.Id))
+}
+
+func (r *convoResolver) Parent() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.convo.Parent))
+}
+
+func (r *convoResolver) Sender(ctx context.Context) (*userResolver, error) {
+	return loadUser(ctx, r.convo.Sender)
+}
+
+func (r *convoResolver) Recipient(ctx context.Context) (*userResolver, error) {
+	return loadUser(ctx, r.convo.Recipient)
+}
+
+func (r *convoResolver) Subject() string {
+	return r.convo.Subject
+}
+
+func (r *convoResolver) Body() string {
+	return r.convo.Body
+}
+
+func (r *convoResolver) Read() bool {
+	return r.convo.Read
+}
+
+func (r *convoResolver) Archived() bool {
+	return r.convo.Archived
+}
+
+func (r *convoResolver) EditedAt() *string {
+	return formatTime(r.convo.EditedAt)
+}
+
+func (r *convoResolver) UndoUntil() *string {
+	return formatTime(r.convo.UndoUntil)
+}
+
+func (r *convoResolver) Replies(ctx context.Context) ([]*convoResolver, error) {
+	children, err := graphqlRequestFrom(ctx).replies.Load(r.convo.Id)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	replies := []*convoResolver{}
+	if children != nil {
+		for _, child := range children.([]*db.Convo) {
+			replies = append(replies, &convoResolver{convo: child})
+		}
+	}
+
+	return replies, nil
+}
+
+func (r *convoResolver) Attachments(ctx context.Context) ([]*attachmentResolver, error) {
+	loaded, err := graphqlRequestFrom(ctx).attachments.Load(r.convo.Id)
+	if err != nil {
+		return nil, newGraphQLError(err)
+	}
+
+	attachments := []*attachmentResolver{}
+	if loaded != nil {
+		for _, a := range loaded.([]*db.Attachment) {
+			attachments = append(attachments, &attachmentResolver{a})
+		}
+	}
+
+	return attachments, nil
+}
+
+// Etag is computed from the convo's own fields, which every convo is loaded with
+func (r *convoResolver) Etag() string {
+	return r.convo.ETag()
+}
+
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
+
+type attachmentResolver struct {
+	attachment *db.Attachment
+}
+
+func (r *attachmentResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.attachment.Id))
+}
+
+func (r *attachmentResolver) Filename() string {
+	return r.attachment.Filename
+}
+
+func (r *attachmentResolver) ContentType() string {
+	return r.attachment.ContentType
+}
+
+func (r *attachmentResolver) Size() int32 {
+	return int32(r.attachment.Size)
+}
+
+type convoConnectionResolver struct {
+	edges       []*convoEdgeResolver
+	hasNextPage bool
+}
+
+func (r *convoConnectionResolver) Edges() []*convoEdgeResolver {
+	return r.edges
+}
+
+func (r *convoConnectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{hasNextPage: r.hasNextPage}
+	if len(r.edges) > 0 {
+		info.endCursor = &r.edges[len(r.edges)-1].cursor
+	}
+
+	return info
+}
+
+type convoEdgeResolver struct {
+	cursor string
+	node   *convoResolver
+}
+
+func (r *convoEdgeResolver) Cursor() string {
+	return r.cursor
+}
+
+func (r *convoEdgeResolver) Node() *convoResolver {
+	return r.node
+}
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
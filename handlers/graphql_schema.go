@@ -0,0 +1,79 @@
+package handlers
+
+const graphqlSchemaString = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	# The authenticated user
+	me: User!
+
+	# A convo, with all of its replies
+	convo(id: ID!): Convo
+
+	# Threads of the authenticated user, newest first. Only archived threads are returned if archived is true.
+	inbox(first: Int, after: String, archived: Boolean): ConvoConnection!
+}
+
+type Mutation {
+	createConvo(recipient: ID!, subject: String!, body: String!): Convo!
+
+	# Replies to whoever is on the other end of the convo
+	reply(id: ID!, body: String!): Convo!
+
+	# etag is the etag of the version of the convo the changes are based on, or "*" to skip the check
+	updateConvo(id: ID!, etag: String!, read: Boolean, archived: Boolean, subject: String, body: String): Convo!
+
+	deleteConvo(id: ID!, etag: String!): Boolean!
+}
+
+type User {
+	id: ID!
+	name: String
+}
+
+type Convo {
+	id: ID!
+	parent: ID!
+	sender: User!
+	recipient: User!
+	subject: String!
+	body: String!
+	read: Boolean!
+	archived: Boolean!
+
+	# RFC 3339 timestamps
+	editedAt: String
+	undoUntil: String
+
+	replies: [Convo!]!
+	attachments: [Attachment!]!
+
+	# To give as If-Match to PATCH or DELETE /v1/convos/:id/
+	etag: String!
+}
+
+type Attachment {
+	id: ID!
+	filename: String!
+	contentType: String!
+	size: Int!
+}
+
+type ConvoConnection {
+	edges: [ConvoEdge!]!
+	pageInfo: PageInfo!
+}
+
+type ConvoEdge {
+	cursor: String!
+	node: Convo!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+`
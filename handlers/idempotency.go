@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// fingerprintRequest hashes the body of a request as it is read by the handler, so that a retried request can be
+// told apart from a different request reusing the same idempotency key. `parts` identify the endpoint being called.
+//
+// The returned function reads whatever is left of the body and returns the fingerprint.
+func fingerprintRequest(req *http.Request, parts ...string) func() string {
+	hash := sha256.New()
+	for _, part := range parts {
+		io.WriteString(hash, part)
+		io.WriteString(hash, "\n")
+	}
+
+	// Clients pick a new boundary for each attempt of a multipart request, so its fields are hashed instead of its body
+	if isMultipartRequest(req) {
+		return func() string {
+			fingerprintMultipartForm(hash, req)
+			return hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(req.Body, hash), req.Body}
+
+	return func() string {
+		io.Copy(ioutil.Discard, req.Body)
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+}
+
+// fingerprintMultipartForm hashes the `convo` field of a parsed multipart request, and the name, size and contents of
+// each of its attachments
+func fingerprintMultipartForm(hash hash.Hash, req *http.Request) {
+	if req.MultipartForm == nil {
+		return
+	}
+
+	convo := req.FormValue("convo")
+	fmt.Fprintf(hash, "convo\n%d\n%s\n", len(convo), convo)
+
+	for _, fh := range req.MultipartForm.File["attachments"] {
+		contents := sha256.New()
+		if f, err := fh.Open(); err == nil {
+			io.Copy(contents, f)
+			f.Close()
+		}
+
+		fmt.Fprintf(hash, "attachment\n%q\n%d\n%x\n", fh.Filename, fh.Size, contents.Sum(nil))
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"testing"
+)
+
+func Test_FingerprintRequest(t *testing.T) {
+	fingerprint := func(body string, parts ...string) string {
+		req := generateTestRequest("1", body)
+		f := fingerprintRequest(req, parts...)
+
+		// Reading the body must not be affected
+		if read, _ := ioutil.ReadAll(req.Body); string(read) != body {
+			t.Errorf("Body was changed. Expected: %q. Actual: %q", body, read)
+		}
+
+		return f()
+	}
+
+	original := fingerprint(`{"body": "Hello"}`, "create", "")
+
+	if retry := fingerprint(`{"body": "Hello"}`, "create", ""); retry != original {
+		t.Errorf("Same request has a different fingerprint. Expected: %v. Actual: %v", original, retry)
+	}
+
+	if other := fingerprint(`{"body": "Goodbye"}`, "create", ""); other == original {
+		t.Errorf("Different body has the same fingerprint: %v", other)
+	}
+
+	if reply := fingerprint(`{"body": "Hello"}`, "create", "5"); reply == original {
+		t.Errorf("Different endpoint has the same fingerprint: %v", reply)
+	}
+}
+
+func Test_FingerprintRequest_Multipart(t *testing.T) {
+	fingerprint := func(convo string, files map[string]string) string {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("convo", convo)
+		for name, contents := range files {
+			part, _ := writer.CreateFormFile("attachments", name)
+			part.Write([]byte(contents))
+		}
+		writer.Close()
+
+		req := generateTestRequest("1", body.String())
+		req.Method = "POST"
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		f := fingerprintRequest(req, "create", "")
+		if err := req.ParseMultipartForm(multipartMemory); err != nil {
+			t.Fatal(err)
+		}
+
+		return f()
+	}
+
+	files := map[string]string{"report.pdf": "%PDF-1.4"}
+	original := fingerprint(`{"body": "Hello"}`, files)
+
+	// Each attempt is sent with a new boundary
+	if retry := fingerprint(`{"body": "Hello"}`, files); retry != original {
+		t.Errorf("Same request has a different fingerprint. Expected: %v. Actual: %v", original, retry)
+	}
+
+	if other := fingerprint(`{"body": "Goodbye"}`, files); other == original {
+		t.Errorf("Different convo has the same fingerprint: %v", other)
+	}
+
+	if other := fingerprint(`{"body": "Hello"}`, map[string]string{"report.pdf": "%PDF-1.5"}); other == original {
+		t.Errorf("Different attachment has the same fingerprint: %v", other)
+	}
+
+	if other := fingerprint(`{"body": "Hello"}`, map[string]string{"notes.pdf": "%PDF-1.4"}); other == original {
+		t.Errorf("Differently named attachment has the same fingerprint: %v", other)
+	}
+}
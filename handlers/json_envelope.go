@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"reflect"
 	"strconv"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
 )
 
 type JsonEnvelope struct {
@@ -12,6 +15,14 @@ type JsonEnvelope struct {
 	Error    interface{}       `json:"error"`
 }
 
+type JsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Only set when the request was rejected because of invalid fields
+	Fields []*db.FieldError `json:"fields,omitempty"`
+}
+
 func (e *JsonEnvelope) ToJson() string {
 	json, _ := json.Marshal(e.Response)
 	return string(json)
@@ -48,8 +59,14 @@ func NewJsonEnvelopeFromObj(objs interface{}) JsonEnvelope {
 }
 
 func NewJsonEnvelopeFromError(err error) JsonEnvelope {
-	error := map[string]string{
-		"message": err.Error(),
+	_, code := getErrorStatus(err)
+	error := &JsonError{
+		Code:    code,
+		Message: getErrorMessage(err),
+	}
+
+	if verr, ok := errgo.Cause(err).(*db.ValidationError); ok {
+		error.Fields = verr.Fields
 	}
 
 	meta := map[string]string{"count": "1"}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+)
+
+var (
+	ErrInvalidPatch = errgo.New("Invalid Patch")
+
+	// Fields of a convo which are part of its representation, but can never be changed
+	immutableConvoFields = map[string]bool{
+		"id": true, "sender": true, "recipient": true, "parent": true, "replies": true,
+		"edited_at": true, "attachments": true, "undo_until": true, "send_at": true,
+	}
+)
+
+// newConvoPatch converts a JSON merge patch (RFC 7396) into the changes to make to a convo.
+// Unknown or immutable fields, and values of the wrong type, are rejected rather than ignored.
+func newConvoPatch(patch map[string]interface{}) (*db.ConvoPatch, error) {
+	convoPatch := &db.ConvoPatch{}
+	var unknown, immutable, invalid []string
+
+	for field, value := range patch {
+		switch field {
+		case "read":
+			read, ok := patchBool(value)
+			if !ok {
+				invalid = append(invalid, field)
+				continue
+			}
+			convoPatch.Read = &read
+		case "archived":
+			archived, ok := patchBool(value)
+			if !ok {
+				invalid = append(invalid, field)
+				continue
+			}
+			convoPatch.Archived = &archived
+		case "subject":
+			subject, ok := value.(string)
+			if !ok {
+				invalid = append(invalid, field)
+				continue
+			}
+			convoPatch.Subject = &subject
+		case "body":
+			body, ok := value.(string)
+			if !ok {
+				invalid = append(invalid, field)
+				continue
+			}
+			convoPatch.Body = &body
+		default:
+			if immutableConvoFields[field] {
+				immutable = append(immutable, field)
+			} else {
+				unknown = append(unknown, field)
+			}
+		}
+	}
+
+	var problems []string
+	for _, p := range []struct {
+		description string
+		fields      []string
+	}{
+		{"Unknown fields", unknown},
+		{"Immutable fields", immutable},
+		{"Invalid values for fields", invalid},
+	} {
+		if len(p.fields) > 0 {
+			sort.Strings(p.fields)
+			problems = append(problems, p.description+": "+strings.Join(p.fields, ", "))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, errgo.WithCausef(nil, ErrInvalidPatch, "Unable to apply patch. %s.", strings.Join(problems, ". "))
+	}
+
+	return convoPatch, nil
+}
+
+// patchBool accepts real booleans, as well as the "true" / "false" strings older clients send.
+// `null` is not accepted, since a convo can't be neither read nor unread (or archived nor unarchived).
+func patchBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	}
+
+	return false, false
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/juju/errgo"
+)
+
+func Test_NewConvoPatch(t *testing.T) {
+	tests := []struct {
+		patch    string
+		read     interface{}
+		archived interface{}
+		body     interface{}
+		message  string
+	}{
+		{patch: `{"read": true}`, read: true},
+		{patch: `{"read": "false"}`, read: false},
+		{patch: `{"body": "Edited", "read": false}`, read: false, body: "Edited"},
+		{patch: `{"archived": true, "read": true}`, read: true, archived: true},
+		{patch: `{}`},
+		{patch: `{"read": null}`, message: "Unable to apply patch. Invalid values for fields: read."},
+		{patch: `{"body": 12}`, message: "Unable to apply patch. Invalid values for fields: body."},
+		{patch: `{"colour": "red", "id": 3, "sender": 2}`, message: "Unable to apply patch. Unknown fields: colour. Immutable fields: id, sender."},
+	}
+
+	for _, test := range tests {
+		var patch map[string]interface{}
+		if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+
+		convoPatch, err := newConvoPatch(patch)
+		if test.message != "" {
+			if errgo.Cause(err) != ErrInvalidPatch || err.Error() != test.message {
+				t.Errorf("Wrong error for %s.\nExpected: %v\nActual  : %v", test.patch, test.message, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.patch, err)
+			continue
+		}
+
+		if (convoPatch.Read == nil) != (test.read == nil) || (convoPatch.Read != nil && *convoPatch.Read != test.read) {
+			t.Errorf("Wrong read for %s. Expected: %v. Actual: %v", test.patch, test.read, convoPatch.Read)
+		}
+
+		if (convoPatch.Archived == nil) != (test.archived == nil) || (convoPatch.Archived != nil && *convoPatch.Archived != test.archived) {
+			t.Errorf("Wrong archived for %s. Expected: %v. Actual: %v", test.patch, test.archived, convoPatch.Archived)
+		}
+
+		if (convoPatch.Body == nil) != (test.body == nil) || (convoPatch.Body != nil && *convoPatch.Body != test.body) {
+			t.Errorf("Wrong body for %s. Expected: %v. Actual: %v", test.patch, test.body, convoPatch.Body)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 type Render struct {
 	StatusCode int
 	Response   interface{}
+	Headers    http.Header
 }
 
 func (m *Render) JSON(status int, v interface{}) {
@@ -34,7 +35,7 @@ func (m *Render) Error(status int) {
 }
 
 func (m *Render) Status(status int) {
-
+	m.StatusCode = status
 }
 
 func (m *Render) Redirect(location string, status ...int) {
@@ -46,5 +47,9 @@ func (m *Render) Template() *template.Template {
 }
 
 func (m *Render) Header() http.Header {
-	return http.Header{}
+	if m.Headers == nil {
+		m.Headers = http.Header{}
+	}
+
+	return m.Headers
 }
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+// The OpenAPI 3 document is generated from the list of operations below and from the types the handlers send and
+// receive, so that it cannot drift from the models.
+// Routes are registered in `convos.go`; a test there fails if one of them is missing from the document.
+
+// OpenAPIDocument is an OpenAPI 3 document, with only the parts of the specification the API needs
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       map[string]string                       `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema    `json:"schemas"`
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                                 `json:"required,omitempty"`
+	Content  map[string]map[string]*openAPISchema `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                               `json:"description"`
+	Content     map[string]map[string]*openAPISchema `json:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// openAPIConvoPatch only describes the keys accepted by `newConvoPatch`
+type openAPIConvoPatch struct {
+	Read     bool   `json:"read"`
+	Archived bool   `json:"archived"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+}
+
+type openAPIScheduledConvoPatch struct {
+	SendAt time.Time `json:"send_at"`
+}
+
+// The response of operations which return a file rather than a JSON envelope
+type openAPIBinary struct{}
+
+// The response of operations which send Server-Sent Events rather than a JSON envelope
+type openAPIEventStream struct{}
+
+// The response of operations which switch to the WebSocket protocol
+type openAPIWebSocket struct{}
+
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+
+	// Types of the request body and the `response` of the envelope. A nil request means there is no body.
+	Request  interface{}
+	Response interface{}
+	Status   int
+
+	// Whether convos can also be sent as a multipart form, with attachments
+	Multipart bool
+
+	// Whether the `If-Match` header is required
+	IfMatch bool
+
+	// Optional headers
+	Headers []string
+
+	Query []string
+}
+
+// convoOperations are the operations of each version's `/convos` group, relative to the group
+var convoOperations = []*apiOperation{
+	{Method: "GET", Path: "/scheduled/", Summary: "List scheduled convos", Response: []*db.ScheduledConvo{}},
+	{Method: "PATCH", Path: "/scheduled/:id/", Summary: "Reschedule a convo", Request: openAPIScheduledConvoPatch{}, Response: &db.ScheduledConvo{}},
+	{Method: "DELETE", Path: "/scheduled/:id/", Summary: "Cancel a scheduled convo", Response: ""},
+	{Method: "POST", Path: "/batch/", Summary: "Apply an action to many convos", Request: batchRequest{}, Response: []*db.BatchResult{}},
+	{Method: "GET", Path: "/stream/", Summary: "Stream the events of the user's convos", Response: openAPIEventStream{}, Headers: []string{"Last-Event-ID"}},
+	{Method: "GET", Path: "/ws/", Summary: "Open a WebSocket for events, typing indicators and presence", Response: openAPIWebSocket{}, Status: http.StatusSwitchingProtocols, Query: []string{"last_event_id"}},
+	{Method: "GET", Path: "/webhooks/", Summary: "List the user's webhooks", Response: []*db.Webhook{}},
+	{Method: "POST", Path: "/webhooks/", Summary: "Create a webhook", Request: &db.Webhook{}, Response: &db.Webhook{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/webhooks/:id/", Summary: "Delete a webhook and its deliveries", Response: ""},
+	{Method: "GET", Path: "/webhooks/:id/deliveries/", Summary: "List the latest deliveries of a webhook", Response: []*db.WebhookDelivery{}},
+	{Method: "POST", Path: "/webhooks/:id/deliveries/:did/redeliver/", Summary: "Send a delivery again", Response: &db.WebhookDelivery{}},
+	{Method: "GET", Path: "/settings/email/", Summary: "Get the user's email notification settings", Response: &db.EmailSettings{}},
+	{Method: "PATCH", Path: "/settings/email/", Summary: "Turn the user's email notifications on or off", Request: &db.EmailSettingsPatch{}, Response: &db.EmailSettings{}},
+	{Method: "GET", Path: "/", Summary: "List threads", Response: []*db.Convo{}, Query: []string{"archived"}},
+	{Method: "POST", Path: "/", Summary: "Create a thread", Request: &db.Convo{}, Response: &db.Convo{}, Status: http.StatusCreated, Multipart: true},
+	{Method: "GET", Path: "/:id/", Summary: "Get a convo", Response: &db.Convo{}},
+	{Method: "PATCH", Path: "/:id/", Summary: "Update a convo with a JSON merge patch", Request: openAPIConvoPatch{}, Response: &db.Convo{}, IfMatch: true},
+	{Method: "DELETE", Path: "/:id/", Summary: "Delete a convo and its replies", Response: "", IfMatch: true},
+	{Method: "POST", Path: "/:id/reply/", Summary: "Reply to a convo", Request: &db.Convo{}, Response: &db.Convo{}, Status: http.StatusCreated, Multipart: true},
+	{Method: "POST", Path: "/:id/forward/", Summary: "Forward a convo into a new thread", Request: &db.Convo{}, Response: &db.Convo{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/:id/undo/", Summary: "Undo sending a convo", Response: ""},
+	{Method: "GET", Path: "/:id/revisions/", Summary: "List previous versions of a convo", Response: []*db.Revision{}},
+	{Method: "GET", Path: "/:id/attachments/:aid/", Summary: "Download an attachment", Response: openAPIBinary{}},
+}
+
+// rootOperations are the operations of each version which are not under `/convos`, relative to the version's prefix
+var rootOperations = []*apiOperation{
+	{Method: "GET", Path: "/sync/", Summary: "Get the changes to the user's convos since the last sync", Response: &db.SyncResult{}, Query: []string{"since"}},
+}
+
+func GetOpenAPI(r render.Render) {
+	r.JSON(http.StatusOK, NewOpenAPIDocument())
+}
+
+// NewOpenAPIDocument describes every route of the API, including the deprecated unversioned aliases
+func NewOpenAPIDocument() *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "Convos", "version": V1.Name},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{},
+			SecuritySchemes: map[string]map[string]string{
+				"userApiKey": {"type": "apiKey", "in": "header", "name": "X-USER-API-KEY"},
+			},
+		},
+		Security: []map[string][]string{{"userApiKey": {}}},
+	}
+
+	// Every response is wrapped in an envelope; operations describe their own envelope, with the type of `response`
+	doc.schemaFor(reflect.TypeOf(JsonEnvelope{}))
+
+	doc.addOperations(V1.Prefix+"/convos", convoOperations, false)
+	doc.addOperations(V1.Prefix, rootOperations, false)
+	doc.addOperations("/convos", convoOperations, true)
+	doc.addOperations("", rootOperations, true)
+
+	doc.Paths["/openapi.json"] = map[string]*openAPIOperation{
+		"get": {
+			Summary:  "This document",
+			Security: []map[string][]string{{}},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "OK", Content: jsonContent(&openAPISchema{Type: "object"})},
+			},
+		},
+	}
+
+	// GraphQL describes itself through introspection, so only the shape of its requests and responses is given here
+	doc.Paths["/graphql"] = map[string]*openAPIOperation{
+		"post": {
+			Summary: "Run a GraphQL query",
+			RequestBody: &openAPIBody{
+				Required: true,
+				Content:  jsonContent(doc.schemaFor(reflect.TypeOf(graphqlParams{}))),
+			},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "OK", Content: jsonContent(&openAPISchema{Type: "object", Properties: map[string]*openAPISchema{
+					"data":   {Type: "object", Nullable: true},
+					"errors": {Type: "array", Items: &openAPISchema{Type: "object"}},
+				}})},
+			},
+		},
+	}
+
+	return doc
+}
+
+// OpenAPIPath converts a martini route pattern, e.g. "/convos/:id/", to an OpenAPI path, e.g. "/convos/{id}/"
+func OpenAPIPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (doc *OpenAPIDocument) addOperations(prefix string, ops []*apiOperation, deprecated bool) {
+	for _, op := range ops {
+		path := OpenAPIPath(prefix + op.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+
+		doc.Paths[path][strings.ToLower(op.Method)] = doc.newOperation(op, path, deprecated)
+	}
+}
+
+func (doc *OpenAPIDocument) newOperation(op *apiOperation, path string, deprecated bool) *openAPIOperation {
+	operation := &openAPIOperation{
+		Summary:    op.Summary,
+		Deprecated: deprecated,
+		Responses:  map[string]*openAPIResponse{},
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			operation.Parameters = append(operation.Parameters, &openAPIParameter{
+				Name: strings.Trim(segment, "{}"), In: "path", Required: true, Schema: &openAPISchema{Type: "integer"},
+			})
+		}
+	}
+
+	for _, name := range op.Query {
+		operation.Parameters = append(operation.Parameters, &openAPIParameter{
+			Name: name, In: "query", Schema: &openAPISchema{Type: "string"},
+		})
+	}
+
+	if op.IfMatch {
+		operation.Parameters = append(operation.Parameters, &openAPIParameter{
+			Name: "If-Match", In: "header", Required: true, Schema: &openAPISchema{Type: "string"},
+		})
+	}
+
+	for _, name := range op.Headers {
+		operation.Parameters = append(operation.Parameters, &openAPIParameter{
+			Name: name, In: "header", Schema: &openAPISchema{Type: "string"},
+		})
+	}
+
+	if op.Request != nil {
+		operation.RequestBody = &openAPIBody{
+			Required: true,
+			Content:  jsonContent(doc.schemaFor(reflect.TypeOf(op.Request))),
+		}
+
+		if op.Multipart {
+			operation.RequestBody.Content["multipart/form-data"] = map[string]*openAPISchema{
+				"schema": {Type: "object", Properties: map[string]*openAPISchema{
+					"convo":       {Type: "string"},
+					"attachments": {Type: "array", Items: &openAPISchema{Type: "string", Format: "binary"}},
+				}},
+			}
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := &openAPIResponse{Description: http.StatusText(status)}
+	switch op.Response.(type) {
+	case openAPIBinary:
+		success.Content = map[string]map[string]*openAPISchema{
+			"application/octet-stream": {"schema": {Type: "string", Format: "binary"}},
+		}
+	case openAPIEventStream:
+		// Each event's data is an `Event`
+		doc.schemaFor(reflect.TypeOf(db.Event{}))
+		success.Content = map[string]map[string]*openAPISchema{
+			"text/event-stream": {"schema": {Type: "string"}},
+		}
+	case openAPIWebSocket:
+		// Messages are described in the README
+	default:
+		success.Content = jsonContent(doc.envelopeSchema(doc.schemaFor(reflect.TypeOf(op.Response)), false))
+	}
+
+	operation.Responses[strconv.Itoa(status)] = success
+	operation.Responses["default"] = &openAPIResponse{
+		Description: "Error",
+		Content:     jsonContent(doc.envelopeSchema(&openAPISchema{Type: "string"}, true)),
+	}
+
+	return operation
+}
+
+// envelopeSchema describes a `JsonEnvelope` whose `response` is described by `response`
+func (doc *OpenAPIDocument) envelopeSchema(response *openAPISchema, isError bool) *openAPISchema {
+	errorSchema := doc.schemaFor(reflect.TypeOf(&JsonError{}))
+	if !isError {
+		errorSchema = &openAPISchema{Type: "object", Nullable: true}
+	}
+
+	return &openAPISchema{
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"response": response,
+			"meta":     {Type: "object", AdditionalProperties: &openAPISchema{Type: "string"}},
+			"error":    errorSchema,
+		},
+	}
+}
+
+// schemaFor describes a type as it is encoded by `encoding/json`. Structs are added to the components of the
+// document and referenced by name.
+func (doc *OpenAPIDocument) schemaFor(t reflect.Type) *openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := doc.schemaFor(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Slice:
+		// Raw JSON, such as webhook payloads, is embedded as is
+		if t == reflect.TypeOf(json.RawMessage{}) {
+			return &openAPISchema{Type: "object"}
+		}
+		return &openAPISchema{Type: "array", Items: doc.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: doc.schemaFor(t.Elem())}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Interface:
+		return &openAPISchema{}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+	default:
+		return &openAPISchema{}
+	}
+
+	// Document-only types are named after what they describe
+	name := strings.TrimPrefix(t.Name(), "openAPI")
+	name = strings.ToUpper(name[:1]) + name[1:]
+
+	ref := &openAPISchema{Ref: "#/components/schemas/" + name}
+	if _, ok := doc.Components.Schemas[name]; ok {
+		return ref
+	}
+
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	doc.Components.Schemas[name] = schema
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		if tag == "" {
+			tag = field.Name
+		}
+
+		schema.Properties[tag] = doc.schemaFor(field.Type)
+	}
+
+	return ref
+}
+
+func jsonContent(schema *openAPISchema) map[string]map[string]*openAPISchema {
+	return map[string]map[string]*openAPISchema{
+		"application/json": {"schema": schema},
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+func Test_RelayOutbox(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	var events []*db.OutboxEvent
+	failing := false
+	db.SubscribeOutbox("test-relay", func(tx *sql.Tx, event *db.OutboxEvent) error {
+		if failing {
+			return errors.New("Unavailable")
+		}
+
+		events = append(events, event)
+		return nil
+	})
+
+	// The subscriber starts after the events published before its first relay
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "Relayed", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Type != db.EventConvoCreated || events[0].User != 1 || events[0].Convo.Subject != "Relayed" {
+		t.Fatalf("Wrong events relayed: %+v", events)
+	}
+
+	// Events are only relayed once
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Events relayed again: %+v", events)
+	}
+
+	read := true
+	if _, err := db.UpdateConvo("2", strconv.Itoa(c.Id), &db.ConvoPatch{Read: &read}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(delay time.Duration) { db.OutboxRetryDelay = delay }(db.OutboxRetryDelay)
+	db.OutboxRetryDelay = 0
+
+	// A failed event is kept until the subscriber handles it
+	failing = true
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = false
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[1].Type != db.EventReadChanged || events[1].User != 2 || events[1].Read == nil || !*events[1].Read {
+		t.Fatalf("Wrong events relayed: %+v", events)
+	}
+
+	if events[1].Position <= events[0].Position {
+		t.Errorf("Events relayed out of order: %d, %d", events[0].Position, events[1].Position)
+	}
+}
+
+func Test_RelayOutbox_Backoff(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	var events []*db.OutboxEvent
+	failing := true
+	defer func(delay time.Duration) { db.OutboxRetryDelay = delay }(db.OutboxRetryDelay)
+	db.OutboxRetryDelay = 200 * time.Millisecond
+
+	db.SubscribeOutbox("test-backoff", func(tx *sql.Tx, event *db.OutboxEvent) error {
+		if failing {
+			return errors.New("Unavailable")
+		}
+
+		events = append(events, event)
+		return nil
+	})
+
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "Retried", Body: "Message Body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed event is not handed to the subscriber again before the retry delay has passed
+	failing = false
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 0 {
+		t.Fatalf("Failed event retried right away: %+v", events)
+	}
+
+	time.Sleep(db.OutboxRetryDelay)
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Convo.Subject != "Retried" {
+		t.Fatalf("Wrong events relayed: %+v", events)
+	}
+}
+
+func Test_RelayOutbox_Parked(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	defer func(attempts int, delay time.Duration) {
+		db.OutboxMaxAttempts, db.OutboxRetryDelay = attempts, delay
+	}(db.OutboxMaxAttempts, db.OutboxRetryDelay)
+	db.OutboxMaxAttempts, db.OutboxRetryDelay = 2, 0
+
+	var events []*db.OutboxEvent
+	db.SubscribeOutbox("test-parked", func(tx *sql.Tx, event *db.OutboxEvent) error {
+		if event.Convo.Subject == "Poison" {
+			return errors.New("Cannot handle")
+		}
+
+		events = append(events, event)
+		return nil
+	})
+
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subject := range []string{"Poison", "Healthy"} {
+		if _, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: subject, Body: "Message Body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Later events wait for the failed one
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 0 {
+		t.Fatalf("Events relayed past a failed one: %+v", events)
+	}
+
+	// Until it is given up on
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Convo.Subject != "Healthy" {
+		t.Fatalf("Wrong events relayed: %+v", events)
+	}
+
+	// A parked event is not handed to the subscriber again
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("Events relayed again: %+v", events)
+	}
+}
+
+func Test_RelayOutbox_Undone(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	var events []*db.OutboxEvent
+	db.SubscribeOutbox("test-undone", func(tx *sql.Tx, event *db.OutboxEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.UndoSendDelay = time.Minute
+	defer func() { db.UndoSendDelay = 0 }()
+
+	c, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "Undone", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UndoConvo("1", strconv.Itoa(c.Id)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the convo nor its deletion were ever visible to its recipient
+	if err := db.RelayOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 0 {
+		t.Errorf("Events of an undone convo relayed: %+v", events)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/go-martini/martini"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+// isId returns whether a string can be the id of a row, whose ids are all integers
+func isId(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 32)
+	return err == nil
+}
+
+// IdParamsMiddleware rejects requests whose route parameters, which are all ids, are not integers, before they get
+// to the database
+func IdParamsMiddleware(params martini.Params, r render.Render) {
+	for _, id := range params {
+		if !isId(id) {
+			// Responding here stops martini from calling the route's handler
+			returnError(r, errgo.WithCausef(nil, db.ErrInvalid, "Invalid id '%s'.", id))
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+func GetScheduledConvos(r render.Render) {
+	convos, err := db.GetScheduledConvos(userId)
+	returnEnvelope(r, convos, err)
+}
+
+func UpdateScheduledConvo(req *http.Request, params martini.Params, r render.Render) {
+	patch, err := getJsonFromRequest(req)
+
+	if err != nil {
+		returnEnvelope(r, patch, err)
+		return
+	}
+
+	value, _ := patch["send_at"].(string)
+	sendAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		returnEnvelope(r, patch, errgo.WithCausef(err, db.ErrInvalid, "`send_at` must be an RFC 3339 timestamp"))
+		return
+	}
+
+	id := params["id"]
+	convo, err := db.RescheduleConvo(userId, id, sendAt)
+	returnEnvelope(r, convo, err)
+}
+
+func DeleteScheduledConvo(params martini.Params, r render.Render) {
+	id := params["id"]
+	err := db.CancelScheduledConvo(userId, id)
+	returnEnvelope(r, "success", err)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_CreateConvo_Scheduled(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	sendAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body", SendAt: &sendAt,
+	}
+	p := generateHandlerPrerequisites(true, post.ToJson())
+
+	CreateConvo(p.Req, p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	scheduled, ok := renderer.Response.(JsonEnvelope).Response.(*db.ScheduledConvo)
+	if !ok {
+		t.Fatalf("Expected a scheduled convo. Actual: %#v", renderer.Response)
+	}
+
+	if !scheduled.SendAt.Equal(sendAt) {
+		t.Errorf("Wrong send time. Expected: %v. Actual: %v", sendAt, scheduled.SendAt)
+	}
+
+	// Nothing should have been sent yet
+	GetConvos(p.Req, p.Render)
+
+	var emptyList []*db.Convo
+	expected := NewJsonEnvelopeFromObj(emptyList)
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_DeliverScheduledConvos(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	sendAt := time.Now().Add(-time.Minute)
+	post := &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body", SendAt: &sendAt,
+	}
+
+	if _, err := db.ScheduleConvo("1", post); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.DeliverScheduledConvos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Wrong number of convos delivered. Expected: %v. Actual: %v", 1, count)
+	}
+
+	// Delivering again must not send the message a second time
+	count, err = db.DeliverScheduledConvos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Wrong number of convos delivered. Expected: %v. Actual: %v", 0, count)
+	}
+
+	convos, err := db.GetConvos("2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(convos) != 1 {
+		t.Errorf("Wrong number of convos received. Expected: %v. Actual: %v", 1, len(convos))
+	}
+}
+
+func Test_DeleteScheduledConvo_Authorized(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, "")
+
+	sendAt := time.Now().Add(time.Hour)
+	scheduled, err := db.ScheduleConvo("1", &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body", SendAt: &sendAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Params["id"] = strconv.Itoa(scheduled.Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromObj("success")
+
+	DeleteScheduledConvo(p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
+
+func Test_DeleteScheduledConvo_Unauthorized(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(false, "")
+
+	sendAt := time.Now().Add(time.Hour)
+	scheduled, err := db.ScheduleConvo("1", &db.Convo{
+		Recipient: 2, Subject: "First Post", Body: "Message Body", SendAt: &sendAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Params["id"] = strconv.Itoa(scheduled.Id)
+
+	// Set Expectations
+	expected := NewJsonEnvelopeFromError(errgo.WithCausef(nil, db.ErrNoRows, "Unable to find scheduled convo with id '%d'.", scheduled.Id))
+
+	DeleteScheduledConvo(p.Params, p.Render)
+
+	// Verify the result
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusNotFound, renderer.StatusCode)
+	}
+
+	if !reflect.DeepEqual(renderer.Response, expected) {
+		t.Errorf("JSON Envelopes do not match.\nExpected: %#v\nActual  : %#v", expected, renderer.Response)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+var (
+	// How often a stream looks for new events without being notified of any, in case a notification was missed
+	StreamPollInterval time.Duration = 30 * time.Second
+
+	// How often a comment is sent on a stream with no events, so that proxies do not close the connection
+	StreamHeartbeatInterval time.Duration = 15 * time.Second
+
+	// How long clients should wait before reconnecting to a stream
+	StreamRetryDelay time.Duration = 3 * time.Second
+)
+
+// StreamEvents keeps the connection open and sends the events of the user's convos as Server-Sent Events.
+// A client which reconnects with the `Last-Event-ID` header gets every event it missed since that one.
+func StreamEvents(req *http.Request, w http.ResponseWriter, r render.Render) {
+	// The user must not change while the connection is open
+	user := userId
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		returnError(r, errgo.New("Streaming is not supported by the response writer"))
+		return
+	}
+
+	// Subscribe first, so that no event is missed between the first fetch and the wait for the next ones
+	notifications, unsubscribe := db.SubscribeEvents(user)
+	defer unsubscribe()
+
+	cursor := req.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		var err error
+		if cursor, err = db.CurrentEventCursor(user); err != nil {
+			returnError(r, err)
+			return
+		}
+	}
+
+	// Fetch the first events before sending the headers, so that an invalid `Last-Event-ID` is still reported
+	events, err := db.GetEvents(user, cursor)
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", StreamRetryDelay/time.Millisecond)
+	flusher.Flush()
+
+	poll := time.NewTicker(StreamPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		for _, event := range events {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			cursor = event.Cursor
+		}
+		flusher.Flush()
+
+		// There may be more events than a single page
+		if len(events) == 0 {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+				continue
+			case <-notifications:
+			case <-poll.C:
+			}
+		}
+
+		events, err = db.GetEvents(user, cursor)
+		if err != nil {
+			// Clients reconnect with the id of the last event they received, so nothing is lost
+			log.Printf("Error streaming events: %v\n", err)
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event *db.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor, event.Type, data)
+	return err
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_WriteEvent(t *testing.T) {
+	read := true
+	event := &db.Event{Cursor: "7", Type: db.EventReadChanged, Convo: 3, Thread: 2, Read: &read}
+
+	var b bytes.Buffer
+	if err := writeEvent(&b, event); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id: 7\nevent: read.changed\ndata: {\"type\":\"read.changed\",\"convo\":3,\"thread\":2,\"read\":true}\n\n"
+	if b.String() != expected {
+		t.Errorf("Wrong event written.\nExpected: %q\nActual  : %q", expected, b.String())
+	}
+}
+
+func Test_StreamEvents_InvalidLastEventID(t *testing.T) {
+	p := generateHandlerPrerequisites(true, "")
+	p.Req.Header.Set("Last-Event-ID", "not-an-id")
+
+	StreamEvents(p.Req, httptest.NewRecorder(), p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
+	}
+}
+
+// streamRecorder is a ResponseRecorder which can be read while a stream writes to it
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushed chan struct{}
+}
+
+func newStreamRecorder() *streamRecorder {
+	return &streamRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{}, 1)}
+}
+
+func (w *streamRecorder) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseRecorder.Write(b)
+}
+
+func (w *streamRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *streamRecorder) Flush() {
+	w.mu.Lock()
+	w.ResponseRecorder.Flush()
+	w.mu.Unlock()
+
+	select {
+	case w.flushed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *streamRecorder) body() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Body.String()
+}
+
+// waitFor waits until the stream has sent `s`
+func (w *streamRecorder) waitFor(t *testing.T, s string) {
+	timeout := time.After(5 * time.Second)
+	for !strings.Contains(w.body(), s) {
+		select {
+		case <-w.flushed:
+		case <-timeout:
+			t.Fatalf("Stream did not send %q:\n%s", s, w.body())
+		}
+	}
+}
+
+func Test_StreamEvents_Resume(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	defer func(interval time.Duration) { StreamPollInterval = interval }(StreamPollInterval)
+	StreamPollInterval = 10 * time.Millisecond
+
+	cursor, err := db.CurrentEventCursor("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events which happened while the client was disconnected are sent first
+	convo, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "")
+	p.Req.Header.Set("Last-Event-ID", cursor)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Req = p.Req.WithContext(ctx)
+
+	w := newStreamRecorder()
+	done := make(chan struct{})
+	go func() {
+		StreamEvents(p.Req, w, p.Render)
+		close(done)
+	}()
+
+	w.waitFor(t, "event: convo.created\n")
+
+	// Events which happen while the client is connected are sent as they happen
+	if err := db.DeleteConvo("2", strconv.Itoa(convo.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w.waitFor(t, "event: convo.deleted\n")
+	cancel()
+	<-done
+
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Wrong Content-Type. Expected: %v. Actual: %v", "text/event-stream", w.Header().Get("Content-Type"))
+	}
+
+	body := w.body()
+	if strings.Index(body, "event: convo.deleted\n") < strings.Index(body, "event: convo.created\n") {
+		t.Errorf("Wrong events sent:\n%s", body)
+	}
+}
+
+func Test_StreamEvents_Notified(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	// Events must be sent when notified, not when the stream next polls for them
+	defer func(interval time.Duration) { StreamPollInterval = interval }(StreamPollInterval)
+	StreamPollInterval = time.Hour
+
+	// Returns once listening
+	stop := db.StartEventListener()
+	defer stop()
+
+	p := generateHandlerPrerequisites(true, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Req = p.Req.WithContext(ctx)
+
+	w := newStreamRecorder()
+	done := make(chan struct{})
+	go func() {
+		StreamEvents(p.Req, w, p.Render)
+		close(done)
+	}()
+
+	// The stream is subscribed once it has sent its first line
+	w.waitFor(t, "retry: ")
+	if _, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w.waitFor(t, "event: convo.created\n")
+	cancel()
+	<-done
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+// SyncConvos returns the changes to the user's convos since the sync which returned the `since` token, so that
+// clients can keep a local copy of them
+func SyncConvos(req *http.Request, r render.Render) {
+	result, err := db.Sync(userId, req.FormValue("since"))
+	returnEnvelope(r, result, err)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func syncConvos(t *testing.T, since string) *db.SyncResult {
+	p := generateHandlerPrerequisites(true, "")
+	p.Req.Form = map[string][]string{"since": {since}}
+
+	SyncConvos(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusOK, renderer.StatusCode)
+	}
+
+	return renderer.Response.(JsonEnvelope).Response.(*db.SyncResult)
+}
+
+func Test_SyncConvos_InvalidToken(t *testing.T) {
+	p := generateHandlerPrerequisites(true, "")
+	p.Req.Form = map[string][]string{"since": {"not-a-token"}}
+
+	SyncConvos(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusBadRequest, renderer.StatusCode)
+	}
+}
+
+func Test_SyncConvos(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	kept, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "Kept", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "Deleted", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first sync returns every convo
+	first := syncConvos(t, "")
+	if len(first.Changes) != 2 || first.More {
+		t.Fatalf("Wrong changes returned: %+v", first.Changes)
+	}
+
+	if first.Changes[0].Convo == nil || first.Changes[0].Convo.Subject != "Kept" {
+		t.Errorf("Wrong first change: %+v", first.Changes[0])
+	}
+
+	// Nothing changed since
+	if again := syncConvos(t, first.Token); len(again.Changes) != 0 || again.Token != first.Token {
+		t.Errorf("Wrong changes returned without changes: %+v, token %s", again.Changes, again.Token)
+	}
+
+	read := true
+	if _, err := db.UpdateConvo("1", strconv.Itoa(kept.Id), &db.ConvoPatch{Read: &read}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteConvo("2", strconv.Itoa(deleted.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read status is the user's own change, and the deleted convo is a tombstone
+	second := syncConvos(t, first.Token)
+	if len(second.Changes) != 2 {
+		t.Fatalf("Wrong changes returned: %+v", second.Changes)
+	}
+
+	if c := second.Changes[0]; c.Id != kept.Id || c.Deleted || c.Convo == nil || !c.Convo.Read {
+		t.Errorf("Wrong change for the read convo: %+v", c)
+	}
+
+	if c := second.Changes[1]; c.Id != deleted.Id || !c.Deleted || c.Convo != nil {
+		t.Errorf("Wrong change for the deleted convo: %+v", c)
+	}
+
+	// Starting over leaves tombstones out
+	if again := syncConvos(t, ""); len(again.Changes) != 1 || again.Changes[0].Id != kept.Id {
+		t.Errorf("Wrong changes returned when starting over: %+v", again.Changes)
+	}
+}
+
+func Test_SyncConvos_NestedReplies(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	thread, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "Thread", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := db.CreateConvo("1", &db.Convo{Parent: thread.Id, Recipient: 2, Subject: "Thread", Body: "Reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested, err := db.CreateConvo("2", &db.Convo{Parent: reply.Id, Recipient: 1, Subject: "Thread", Body: "Nested reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := syncConvos(t, "").Token
+
+	if err := db.DeleteConvo("2", strconv.Itoa(thread.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replies to replies are deleted along with the thread, so they are tombstones too
+	deleted := map[int]bool{}
+	for _, c := range syncConvos(t, token).Changes {
+		if c.Deleted {
+			deleted[c.Id] = true
+		}
+	}
+
+	for _, id := range []int{thread.Id, reply.Id, nested.Id} {
+		if !deleted[id] {
+			t.Errorf("No tombstone for convo %d: %v", id, deleted)
+		}
+	}
+}
+
+func Test_SyncConvos_ExpiredToken(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	defer func(retention time.Duration) { db.TombstoneRetention = retention }(db.TombstoneRetention)
+	db.TombstoneRetention = 0
+
+	convo, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := syncConvos(t, "").Token
+
+	if err := db.DeleteConvo("2", strconv.Itoa(convo.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tombstone must have been given a version before it can be purged
+	syncConvos(t, "")
+	if _, err := db.PurgeExpiredTombstones(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := generateHandlerPrerequisites(true, "")
+	p.Req.Form = map[string][]string{"since": {token}}
+
+	SyncConvos(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusGone {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusGone, renderer.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+// APIVersion describes how the responses of one version of the API are shaped.
+// Handlers always build a v1 `JsonEnvelope`; later versions convert it to their own envelope and model shapes, so
+// that the handlers and the `db` package can be shared between versions.
+type APIVersion struct {
+	Name string
+
+	// Prepended to the paths of the version's routes, e.g. "/v1"
+	Prefix string
+
+	// Envelope converts a v1 envelope, sent with `status`, to the shape used by this version.
+	// Nil keeps the v1 shape.
+	Envelope func(status int, envelope JsonEnvelope) interface{}
+}
+
+var V1 = &APIVersion{Name: "v1", Prefix: "/v1"}
+
+// Deprecation describes routes which are going to be removed
+type Deprecation struct {
+	// When the routes were deprecated
+	Since time.Time
+
+	// When the routes will stop working
+	Sunset time.Time
+
+	// Turns the path of a request into the path of the route which replaces it. Optional.
+	Successor func(path string) string
+}
+
+// VersionMiddleware makes every response of a route group use the shapes of an API version.
+// It must come before any other middleware which can respond, such as `UserAuthorizationMiddleware`.
+func VersionMiddleware(version *APIVersion) martini.Handler {
+	return func(c martini.Context, r render.Render) {
+		c.MapTo(&versionedRender{r, version}, (*render.Render)(nil))
+	}
+}
+
+// DeprecationMiddleware adds the `Deprecation` (RFC 9745) and `Sunset` (RFC 8594) headers to every response of a
+// route group, along with a link to the successor of the route if there is one.
+func DeprecationMiddleware(deprecation *Deprecation) martini.Handler {
+	return func(req *http.Request, w http.ResponseWriter) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.Since.Unix()))
+		w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+
+		if deprecation.Successor != nil {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, deprecation.Successor(req.URL.Path)))
+		}
+	}
+}
+
+// PathPrefixSuccessor returns a `Deprecation.Successor` for routes which moved under a new prefix, e.g. "/v1"
+func PathPrefixSuccessor(prefix string) func(string) string {
+	return func(path string) string {
+		return strings.TrimSuffix(prefix, "/") + path
+	}
+}
+
+// versionedLocation prefixes the path of a newly created object with the API version of the request, if any
+func versionedLocation(r render.Render, location string) string {
+	if vr, ok := r.(*versionedRender); ok {
+		return vr.version.Prefix + location
+	}
+
+	return location
+}
+
+type versionedRender struct {
+	render.Render
+	version *APIVersion
+}
+
+func (r *versionedRender) JSON(status int, v interface{}) {
+	if envelope, ok := v.(JsonEnvelope); ok && r.version.Envelope != nil {
+		r.Render.JSON(status, r.version.Envelope(status, envelope))
+		return
+	}
+
+	r.Render.JSON(status, v)
+}
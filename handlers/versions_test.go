@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_VersionedRender(t *testing.T) {
+	// A version which only sends the response itself, without the envelope
+	v2 := &APIVersion{Name: "v2", Prefix: "/v2", Envelope: func(status int, envelope JsonEnvelope) interface{} {
+		if envelope.Error != nil {
+			return envelope.Error
+		}
+		return envelope.Response
+	}}
+
+	mock := &mocks.Render{}
+	r := &versionedRender{mock, v2}
+
+	r.JSON(http.StatusOK, NewJsonEnvelopeFromObj("success"))
+
+	if !reflect.DeepEqual(mock.Response, "success") {
+		t.Errorf("Wrong response. Expected: %#v. Actual: %#v", "success", mock.Response)
+	}
+
+	if location := versionedLocation(r, "/convos/1/"); location != "/v2/convos/1/" {
+		t.Errorf("Wrong location. Expected: %v. Actual: %v", "/v2/convos/1/", location)
+	}
+
+	// v1 keeps the envelope as it is
+	r = &versionedRender{mock, V1}
+	expected := NewJsonEnvelopeFromObj("success")
+
+	r.JSON(http.StatusOK, expected)
+
+	if !reflect.DeepEqual(mock.Response, expected) {
+		t.Errorf("Wrong response. Expected: %#v. Actual: %#v", expected, mock.Response)
+	}
+}
+
+func Test_DeprecationMiddleware(t *testing.T) {
+	deprecation := &Deprecation{
+		Since:     time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:    time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC),
+		Successor: PathPrefixSuccessor("/v1"),
+	}
+
+	req := &http.Request{URL: &url.URL{Path: "/convos/1/"}}
+	w := httptest.NewRecorder()
+
+	DeprecationMiddleware(deprecation).(func(*http.Request, http.ResponseWriter))(req, w)
+
+	expected := map[string]string{
+		"Deprecation": "@1792368000",
+		"Sunset":      "Mon, 19 Apr 2027 00:00:00 GMT",
+		"Link":        `</v1/convos/1/>; rel="successor-version"`,
+	}
+
+	for header, value := range expected {
+		if actual := w.Header().Get(header); actual != value {
+			t.Errorf("Wrong %s header. Expected: %v. Actual: %v", header, value, actual)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-martini/martini"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+func GetWebhooks(r render.Render) {
+	webhooks, err := db.GetWebhooks(userId)
+	returnEnvelope(r, webhooks, err)
+}
+
+func CreateWebhook(req *http.Request, r render.Render) {
+	var webhook *db.Webhook
+	err := json.NewDecoder(req.Body).Decode(&webhook)
+
+	if err != nil || webhook == nil {
+		returnError(r, errgo.WithCausef(err, ErrInvalidJson, "The webhook must be a JSON object."))
+		return
+	}
+
+	webhook, err = db.CreateWebhook(userId, webhook)
+	returnCreatedEnvelope(r, "", webhook, err)
+}
+
+func DeleteWebhook(params martini.Params, r render.Render) {
+	err := db.DeleteWebhook(userId, params["id"])
+	returnEnvelope(r, "success", err)
+}
+
+func GetWebhookDeliveries(params martini.Params, r render.Render) {
+	deliveries, err := db.GetWebhookDeliveries(userId, params["id"])
+	returnEnvelope(r, deliveries, err)
+}
+
+func RedeliverWebhook(params martini.Params, r render.Render) {
+	delivery, err := db.RedeliverWebhook(userId, params["id"], params["did"])
+	returnEnvelope(r, delivery, err)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/go-martini/martini"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func Test_CreateWebhook_Invalid(t *testing.T) {
+	p := generateHandlerPrerequisites(true, `{"url":"ftp://example.com/hook","events":["convo.archived"],"secret":"short"}`)
+
+	CreateWebhook(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusUnprocessableEntity, renderer.StatusCode)
+	}
+
+	jsonErr := renderer.Response.(JsonEnvelope).Error.(*JsonError)
+	if len(jsonErr.Fields) != 3 {
+		t.Errorf("Wrong invalid fields. Expected: url, events and secret. Actual: %+v", jsonErr.Fields)
+	}
+}
+
+func Test_CreateWebhook_NotPublic(t *testing.T) {
+	for _, url := range []string{"http://127.0.0.1/hook", "http://169.254.169.254/latest/meta-data/", "http://10.0.0.1/hook", "http://[::1]:8080/hook", "http://[::ffff:192.168.0.1]/hook"} {
+		p := generateHandlerPrerequisites(true, `{"url":"`+url+`","events":["convo.created"]}`)
+
+		CreateWebhook(p.Req, p.Render)
+
+		renderer, _ := p.Render.(*mocks.Render)
+		if renderer.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("Wrong Status Code set for %s. Expected: %v. Actual: %v", url, http.StatusUnprocessableEntity, renderer.StatusCode)
+			continue
+		}
+
+		jsonErr := renderer.Response.(JsonEnvelope).Error.(*JsonError)
+		if len(jsonErr.Fields) != 1 || jsonErr.Fields[0].Code != "not_public" {
+			t.Errorf("Wrong invalid fields for %s: %+v", url, jsonErr.Fields)
+		}
+	}
+}
+
+func Test_CreateWebhook(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	p := generateHandlerPrerequisites(true, `{"url":"https://203.0.113.10/hook","events":["convo.created","read.changed"]}`)
+
+	CreateWebhook(p.Req, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusCreated {
+		t.Fatalf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusCreated, renderer.StatusCode)
+	}
+
+	// A secret is generated, and only returned when the webhook is created
+	webhook := renderer.Response.(JsonEnvelope).Response.(*db.Webhook)
+	if len(webhook.Secret) != 64 {
+		t.Errorf("Wrong secret generated: %q", webhook.Secret)
+	}
+
+	webhooks, err := db.GetWebhooks("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webhooks) != 1 || webhooks[0].Id != webhook.Id || webhooks[0].Secret != "" || len(webhooks[0].Events) != 2 {
+		t.Errorf("Wrong webhooks: %+v", webhooks)
+	}
+
+	// Other users cannot see its deliveries
+	if _, err := db.GetWebhookDeliveries("2", strconv.Itoa(webhook.Id)); err == nil {
+		t.Errorf("Another user's webhook deliveries were returned")
+	}
+}
+
+func Test_RedeliverWebhook_Pending(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	webhook, err := db.CreateWebhook("1", &db.Webhook{URL: "https://203.0.113.10/hook", Events: []string{db.EventConvoCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "First Post", Body: "Message Body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := db.GetWebhookDeliveries("1", strconv.Itoa(webhook.Id))
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Wrong deliveries: %+v %v", deliveries, err)
+	}
+
+	p := generateHandlerPrerequisites(true, "")
+	p.Params = martini.Params{"id": strconv.Itoa(webhook.Id), "did": strconv.FormatInt(deliveries[0].Id, 10)}
+
+	RedeliverWebhook(p.Params, p.Render)
+
+	renderer, _ := p.Render.(*mocks.Render)
+	if renderer.StatusCode != http.StatusConflict {
+		t.Errorf("Wrong Status Code set. Expected: %v. Actual: %v", http.StatusConflict, renderer.StatusCode)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/juju/errgo"
+	"github.com/martini-contrib/render"
+	"github.com/nt3rp/convos/db"
+)
+
+// Types of the messages sent and received over a WebSocket
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsTyping      = "typing"
+
+	wsSubscribed = "subscribed"
+	wsPresence   = "presence"
+	wsEvent      = "event"
+	wsError      = "error"
+)
+
+var (
+	// How often a ping is sent to the client, which must answer within `WebSocketPongWait`
+	WebSocketPingInterval time.Duration = 30 * time.Second
+	WebSocketPongWait     time.Duration = 60 * time.Second
+
+	// How many messages can be waiting to be sent to a client before it is considered too slow and disconnected
+	webSocketSendBuffer = 64
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+)
+
+type wsMessage struct {
+	Type   string `json:"type"`
+	Thread int    `json:"thread,omitempty"`
+
+	// Set for `typing` and `presence`: the user who is typing, or who connected to or left the thread
+	User   int   `json:"user,omitempty"`
+	Online *bool `json:"online,omitempty"`
+
+	// Set for `subscribed`: the other users connected to the thread
+	Users []int `json:"users,omitempty"`
+
+	// Set for `event`
+	EventId string    `json:"event_id,omitempty"`
+	Event   *db.Event `json:"event,omitempty"`
+
+	Error interface{} `json:"error,omitempty"`
+}
+
+type wsConn struct {
+	user int
+	ws   *websocket.Conn
+	send chan *wsMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// enqueue queues a message to send, disconnecting clients which do not keep up
+func (c *wsConn) enqueue(msg *wsMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		c.close()
+	}
+}
+
+func (c *wsConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// WebSocket upgrades the connection to a WebSocket, over which the user's convo events are sent, and over which
+// clients subscribed to a thread exchange typing indicators and presence.
+// Events are resumed after the one with the `last_event_id` given in the query string, if any.
+func WebSocket(req *http.Request, w http.ResponseWriter, r render.Render) {
+	// The user must not change while the connection is open
+	user := userId
+
+	notifications, unsubscribe := db.SubscribeEvents(user)
+	defer unsubscribe()
+
+	cursor := req.FormValue("last_event_id")
+	if cursor == "" {
+		var err error
+		if cursor, err = db.CurrentEventCursor(user); err != nil {
+			returnError(r, err)
+			return
+		}
+	}
+
+	// Reject an invalid cursor before upgrading, while an error can still be sent as a response
+	events, err := db.GetEvents(user, cursor)
+	if err != nil {
+		returnError(r, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(user)
+	ws, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already responded
+		return
+	}
+
+	c := &wsConn{user: id, ws: ws, send: make(chan *wsMessage, webSocketSendBuffer), done: make(chan struct{})}
+	defer hub.unsubscribeAll(c)
+	defer c.close()
+
+	go c.writeMessages()
+	go c.sendEvents(user, cursor, events, notifications)
+
+	c.readMessages(user)
+}
+
+func (c *wsConn) readMessages(user string) {
+	c.ws.SetReadLimit(4096)
+	c.ws.SetReadDeadline(time.Now().Add(WebSocketPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(WebSocketPongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.enqueueError(errgo.WithCausef(err, ErrInvalidJson, "Messages must be JSON objects."))
+			continue
+		}
+
+		if err := c.handleMessage(user, &msg); err != nil {
+			c.enqueueError(err)
+		}
+	}
+}
+
+func (c *wsConn) handleMessage(user string, msg *wsMessage) error {
+	switch msg.Type {
+	case wsSubscribe:
+		// Only participants of a thread can subscribe to it
+		convo, err := db.LookupConvo(user, strconv.Itoa(msg.Thread))
+		if err != nil {
+			return err
+		}
+
+		if convo.Parent != convo.Id {
+			return errgo.WithCausef(nil, db.ErrInvalid, "Convo with id '%d' is not a thread.", msg.Thread)
+		}
+
+		online := hub.subscribe(c, msg.Thread)
+		c.enqueue(&wsMessage{Type: wsSubscribed, Thread: msg.Thread, Users: online})
+	case wsUnsubscribe:
+		hub.unsubscribe(c, msg.Thread)
+	case wsTyping:
+		if !hub.isSubscribed(c, msg.Thread) {
+			return errgo.WithCausef(nil, db.ErrInvalid, "Subscribe to thread '%d' before sending to it.", msg.Thread)
+		}
+
+		hub.relay(c, msg.Thread, &wsMessage{Type: wsTyping, Thread: msg.Thread, User: c.user})
+	default:
+		return errgo.WithCausef(nil, db.ErrInvalid, "Unknown message type '%s'.", msg.Type)
+	}
+
+	return nil
+}
+
+func (c *wsConn) enqueueError(err error) {
+	logInternalError(err)
+	c.enqueue(&wsMessage{Type: wsError, Error: NewJsonEnvelopeFromError(err).Error})
+}
+
+func (c *wsConn) writeMessages() {
+	ping := time.NewTicker(WebSocketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			if err := c.ws.WriteJSON(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WebSocketPongWait)); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// sendEvents sends the user's convo events as they happen, like `StreamEvents`
+func (c *wsConn) sendEvents(user, cursor string, events []*db.Event, notifications <-chan struct{}) {
+	poll := time.NewTicker(StreamPollInterval)
+	defer poll.Stop()
+
+	for {
+		for _, event := range events {
+			c.enqueue(&wsMessage{Type: wsEvent, EventId: event.Cursor, Event: event})
+			cursor = event.Cursor
+		}
+
+		// There may be more events than a single page
+		if len(events) == 0 {
+			select {
+			case <-c.done:
+				return
+			case <-notifications:
+			case <-poll.C:
+			}
+		}
+
+		var err error
+		events, err = db.GetEvents(user, cursor)
+		if err != nil {
+			log.Printf("Error sending events: %v\n", err)
+			c.close()
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/handlers/mocks"
+)
+
+func newTestConn(user int) *wsConn {
+	return &wsConn{user: user, send: make(chan *wsMessage, webSocketSendBuffer), done: make(chan struct{})}
+}
+
+// received returns the messages queued for a connection
+func received(c *wsConn) []*wsMessage {
+	var msgs []*wsMessage
+	for {
+		select {
+		case msg := <-c.send:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func Test_WsHub(t *testing.T) {
+	h := &wsHub{threads: map[int]map[*wsConn]bool{}}
+	alice, bob, bobsPhone := newTestConn(1), newTestConn(2), newTestConn(2)
+
+	if online := h.subscribe(alice, 10); len(online) != 0 {
+		t.Errorf("Wrong users online. Expected: none. Actual: %v", online)
+	}
+
+	// Alice sees Bob come online once, even with two devices
+	if online := h.subscribe(bob, 10); !reflect.DeepEqual(online, []int{1}) {
+		t.Errorf("Wrong users online. Expected: %v. Actual: %v", []int{1}, online)
+	}
+	h.subscribe(bobsPhone, 10)
+
+	expected := []*wsMessage{{Type: wsPresence, Thread: 10, User: 2, Online: boolPtr(true)}}
+	if msgs := received(alice); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Wrong messages. Expected: %v. Actual: %v", expected, msgs)
+	}
+
+	// Typing is relayed to the other participants only
+	h.relay(bob, 10, &wsMessage{Type: wsTyping, Thread: 10, User: 2})
+
+	expected = []*wsMessage{{Type: wsTyping, Thread: 10, User: 2}}
+	if msgs := received(alice); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Wrong messages. Expected: %v. Actual: %v", expected, msgs)
+	}
+
+	if msgs := received(bobsPhone); len(msgs) != 0 {
+		t.Errorf("Typing was relayed to its own user: %v", msgs)
+	}
+
+	// Bob only goes offline once his last device leaves
+	h.unsubscribeAll(bob)
+	if msgs := received(alice); len(msgs) != 0 {
+		t.Errorf("Wrong messages. Expected: none. Actual: %v", msgs)
+	}
+
+	h.unsubscribe(bobsPhone, 10)
+	expected = []*wsMessage{{Type: wsPresence, Thread: 10, User: 2, Online: boolPtr(false)}}
+	if msgs := received(alice); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Wrong messages. Expected: %v. Actual: %v", expected, msgs)
+	}
+
+	if h.isSubscribed(bob, 10) || !h.isSubscribed(alice, 10) {
+		t.Errorf("Wrong subscriptions: %v", h.threads)
+	}
+}
+
+func Test_WebSocket_Typing(t *testing.T) {
+	setupConvoHandlerTest(t)
+	defer tearDownConvoHandlerTest(t)
+
+	convo, err := db.CreateConvo("1", firstPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		UserAuthorizationMiddleware(req, &mocks.Render{})
+		WebSocket(req, w, &mocks.Render{})
+	}))
+	defer server.Close()
+
+	dial := func(user string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(
+			strings.Replace(server.URL, "http", "ws", 1), http.Header{"X-USER-API-KEY": {user}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+
+	read := func(ws *websocket.Conn) *wsMessage {
+		msg := &wsMessage{}
+		if err := ws.ReadJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	alice := dial("1")
+	defer alice.Close()
+	alice.WriteJSON(&wsMessage{Type: wsSubscribe, Thread: convo.Id})
+	if msg := read(alice); msg.Type != wsSubscribed {
+		t.Fatalf("Wrong message. Expected: %v. Actual: %+v", wsSubscribed, msg)
+	}
+
+	// Someone who is not part of the thread cannot subscribe to it
+	carol := dial("3")
+	defer carol.Close()
+	carol.WriteJSON(&wsMessage{Type: wsSubscribe, Thread: convo.Id})
+	if msg := read(carol); msg.Type != wsError {
+		t.Fatalf("Wrong message. Expected: %v. Actual: %+v", wsError, msg)
+	}
+
+	bob := dial("2")
+	defer bob.Close()
+	bob.WriteJSON(&wsMessage{Type: wsSubscribe, Thread: convo.Id})
+	if msg := read(bob); msg.Type != wsSubscribed || !reflect.DeepEqual(msg.Users, []int{1}) {
+		t.Fatalf("Wrong message. Expected: %v with user 1. Actual: %+v", wsSubscribed, msg)
+	}
+
+	if msg := read(alice); msg.Type != wsPresence || msg.User != 2 {
+		t.Fatalf("Wrong message. Expected: %v of user 2. Actual: %+v", wsPresence, msg)
+	}
+
+	bob.WriteJSON(&wsMessage{Type: wsTyping, Thread: convo.Id})
+	if msg := read(alice); msg.Type != wsTyping || msg.User != 2 || msg.Thread != convo.Id {
+		t.Fatalf("Wrong message. Expected: %v of user 2. Actual: %+v", wsTyping, msg)
+	}
+
+	// Convo events are delivered too
+	if err := db.DeleteConvo("1", strconv.Itoa(convo.Id), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := read(bob); msg.Type != wsEvent || msg.Event.Type != db.EventConvoDeleted {
+		t.Fatalf("Wrong message. Expected: %v. Actual: %+v", db.EventConvoDeleted, msg)
+	}
+}
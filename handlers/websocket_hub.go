@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"sync"
+)
+
+// wsHub relays the ephemeral messages of a thread, such as typing indicators and presence, between the connections
+// subscribed to it. It only knows about the connections of this process.
+type wsHub struct {
+	mu      sync.Mutex
+	threads map[int]map[*wsConn]bool
+}
+
+var hub = &wsHub{threads: map[int]map[*wsConn]bool{}}
+
+// subscribe adds a connection to a thread, returning the other users connected to it
+func (h *wsHub) subscribe(c *wsConn, thread int) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns := h.threads[thread]
+	if conns == nil {
+		conns = map[*wsConn]bool{}
+		h.threads[thread] = conns
+	}
+
+	wasOnline := h.isOnline(thread, c.user)
+	conns[c] = true
+
+	if !wasOnline {
+		h.broadcast(thread, c, &wsMessage{Type: wsPresence, Thread: thread, User: c.user, Online: boolPtr(true)})
+	}
+
+	var online []int
+	seen := map[int]bool{c.user: true}
+	for other := range conns {
+		if !seen[other.user] {
+			seen[other.user] = true
+			online = append(online, other.user)
+		}
+	}
+
+	return online
+}
+
+func (h *wsHub) unsubscribe(c *wsConn, thread int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(c, thread)
+}
+
+// unsubscribeAll removes a connection which is closing from every thread
+func (h *wsHub) unsubscribeAll(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for thread, conns := range h.threads {
+		if conns[c] {
+			h.remove(c, thread)
+		}
+	}
+}
+
+func (h *wsHub) isSubscribed(c *wsConn, thread int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.threads[thread][c]
+}
+
+// relay sends a message to the other users connected to a thread
+func (h *wsHub) relay(from *wsConn, thread int, msg *wsMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.broadcast(thread, from, msg)
+}
+
+func (h *wsHub) remove(c *wsConn, thread int) {
+	conns := h.threads[thread]
+	if !conns[c] {
+		return
+	}
+
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.threads, thread)
+	}
+
+	// The user may still be connected to the thread from another device
+	if !h.isOnline(thread, c.user) {
+		h.broadcast(thread, c, &wsMessage{Type: wsPresence, Thread: thread, User: c.user, Online: boolPtr(false)})
+	}
+}
+
+func (h *wsHub) isOnline(thread, user int) bool {
+	for c := range h.threads[thread] {
+		if c.user == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// broadcast must be called with the lock held
+func (h *wsHub) broadcast(thread int, from *wsConn, msg *wsMessage) {
+	for c := range h.threads[thread] {
+		if c.user != from.user {
+			c.enqueue(msg)
+		}
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,13 @@
+CREATE TABLE scheduled_convos (
+  id            SERIAL                    PRIMARY KEY,
+  parent_id     INTEGER                   REFERENCES convos(id) ON DELETE CASCADE,
+  sender_id     INTEGER                   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  recipient_id  INTEGER                   NOT NULL REFERENCES users(id),
+  subject       VARCHAR(140)              NOT NULL,
+  body          VARCHAR(64000)            NOT NULL,
+  send_at       TIMESTAMP WITH TIME ZONE  NOT NULL,
+  convo_id      INTEGER                   REFERENCES convos(id) ON DELETE SET NULL,
+  delivered_at  TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX scheduled_convos_pending_idx ON scheduled_convos (send_at) WHERE delivered_at IS NULL;
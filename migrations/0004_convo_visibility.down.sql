@@ -0,0 +1 @@
+ALTER TABLE convos DROP COLUMN visible_at;
@@ -0,0 +1 @@
+ALTER TABLE convos ADD COLUMN visible_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
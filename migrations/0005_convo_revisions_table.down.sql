@@ -0,0 +1,3 @@
+DROP TABLE convo_revisions;
+ALTER TABLE convos DROP COLUMN edited_at;
+ALTER TABLE convos DROP COLUMN created_at;
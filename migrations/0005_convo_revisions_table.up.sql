@@ -0,0 +1,12 @@
+ALTER TABLE convos ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+ALTER TABLE convos ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE convo_revisions (
+  id          SERIAL                    PRIMARY KEY,
+  convo_id    INTEGER                   NOT NULL REFERENCES convos(id) ON DELETE CASCADE,
+  subject     VARCHAR(140)              NOT NULL,
+  body        VARCHAR(64000)            NOT NULL,
+  revised_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX convo_revisions_convo_id_idx ON convo_revisions (convo_id);
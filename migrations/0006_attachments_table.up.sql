@@ -0,0 +1,12 @@
+CREATE TABLE attachments (
+  id            SERIAL                    PRIMARY KEY,
+  convo_id      INTEGER                   REFERENCES convos(id) ON DELETE SET NULL,
+  filename      VARCHAR(255)              NOT NULL,
+  content_type  VARCHAR(255)              NOT NULL,
+  size          BIGINT                    NOT NULL,
+  storage_key   VARCHAR(255)              NOT NULL,
+  created_at    TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX attachments_convo_id_idx ON attachments (convo_id);
+CREATE INDEX attachments_storage_key_idx ON attachments (storage_key);
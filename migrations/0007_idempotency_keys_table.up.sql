@@ -0,0 +1,10 @@
+CREATE TABLE idempotency_keys (
+  user_id      INTEGER                   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key          VARCHAR(255)              NOT NULL,
+  fingerprint  CHAR(64)                  NOT NULL,
+  response     TEXT                      NOT NULL,
+  created_at   TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,5 @@
+CREATE TABLE archive_status (
+  thread_id  INTEGER  NOT NULL  REFERENCES convos(id) ON DELETE CASCADE,
+  user_id    INTEGER  NOT NULL  REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY (thread_id, user_id)
+);
@@ -0,0 +1 @@
+DROP TABLE convo_events;
@@ -0,0 +1,13 @@
+CREATE TABLE convo_events (
+  id          BIGSERIAL                 PRIMARY KEY,
+  user_id     INTEGER                   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  type        VARCHAR(32)               NOT NULL,
+  convo_id    INTEGER                   NOT NULL,
+  thread_id   INTEGER                   NOT NULL,
+  read        BOOLEAN,
+  visible_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+-- There is no foreign key to `convos`, so that the events of a convo are kept after it has been deleted
+CREATE INDEX convo_events_user_id_visible_at_idx ON convo_events (user_id, visible_at, id);
+CREATE INDEX convo_events_visible_at_idx ON convo_events (visible_at);
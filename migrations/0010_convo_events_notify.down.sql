@@ -0,0 +1,2 @@
+DROP TRIGGER convo_events_notify ON convo_events;
+DROP FUNCTION notify_convo_event();
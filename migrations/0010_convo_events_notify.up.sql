@@ -0,0 +1,11 @@
+-- Tell every server instance when a user has a new event, so that they can wake up that user's streams
+CREATE FUNCTION notify_convo_event() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('convo_events', json_build_object('user', NEW.user_id, 'visible_at', NEW.visible_at)::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER convo_events_notify
+AFTER INSERT ON convo_events
+FOR EACH ROW EXECUTE PROCEDURE notify_convo_event();
@@ -0,0 +1,2 @@
+DROP TABLE sync_versions;
+DROP TABLE convo_changes;
@@ -0,0 +1,24 @@
+CREATE TABLE convo_changes (
+  user_id     INTEGER                   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  convo_id    INTEGER                   NOT NULL,
+  version     BIGINT,
+  deleted     BOOLEAN                   NOT NULL DEFAULT false,
+  visible_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, convo_id)
+);
+
+-- There is no foreign key to `convos`, so that deleted convos are kept as tombstones
+CREATE INDEX convo_changes_user_id_version_idx ON convo_changes (user_id, version);
+CREATE INDEX convo_changes_convo_id_idx ON convo_changes (convo_id);
+
+CREATE TABLE sync_versions (
+  user_id  INTEGER  PRIMARY KEY  REFERENCES users(id) ON DELETE CASCADE,
+  version  BIGINT   NOT NULL DEFAULT 0,
+  purged   BIGINT   NOT NULL DEFAULT 0
+);
+
+-- Existing convos are returned by the first sync of each user
+INSERT INTO convo_changes (user_id, convo_id, visible_at)
+SELECT sender_id, id, LEAST(visible_at, now()) FROM convos
+UNION ALL
+SELECT recipient_id, id, visible_at FROM convos;
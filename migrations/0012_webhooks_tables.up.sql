@@ -0,0 +1,31 @@
+-- Webhooks without a user are global, and get the events of every convo
+CREATE TABLE webhooks (
+  id          SERIAL                    PRIMARY KEY,
+  user_id     INTEGER                   REFERENCES users(id) ON DELETE CASCADE,
+  url         VARCHAR(2048)             NOT NULL,
+  events      VARCHAR(32)[]             NOT NULL,
+  secret      VARCHAR(255)              NOT NULL,
+  created_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TABLE webhook_deliveries (
+  id                BIGSERIAL                 PRIMARY KEY,
+  webhook_id        INTEGER                   NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_type        VARCHAR(32)               NOT NULL,
+  convo_id          INTEGER                   NOT NULL,
+  payload           TEXT                      NOT NULL,
+  status            VARCHAR(16)               NOT NULL DEFAULT 'pending',
+  attempts          INTEGER                   NOT NULL DEFAULT 0,
+  next_attempt_at   TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  last_status_code  INTEGER,
+  last_error        TEXT,
+  created_at        TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  finished_at       TIMESTAMP WITH TIME ZONE
+);
+
+-- There is no foreign key to `convos`, so that the deliveries of a convo are kept after it has been deleted
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_finished_at_idx ON webhook_deliveries (finished_at);
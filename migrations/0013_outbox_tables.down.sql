@@ -0,0 +1,2 @@
+DROP TABLE outbox_checkpoints;
+DROP TABLE outbox;
@@ -0,0 +1,18 @@
+CREATE TABLE outbox (
+  id          BIGSERIAL                 PRIMARY KEY,
+  position    BIGINT                    UNIQUE,
+  type        VARCHAR(32)               NOT NULL,
+  convo_id    INTEGER                   NOT NULL,
+  payload     TEXT                      NOT NULL,
+  visible_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  created_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+-- There is no foreign key to `convos`, so that the events of a convo are kept after it has been deleted
+CREATE INDEX outbox_unpublished_idx ON outbox (visible_at, id) WHERE position IS NULL;
+
+CREATE TABLE outbox_checkpoints (
+  subscriber  VARCHAR(64)               PRIMARY KEY,
+  position    BIGINT                    NOT NULL,
+  updated_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+DROP TABLE email_notifications;
+DROP TABLE email_opt_outs;
+ALTER TABLE users DROP COLUMN email;
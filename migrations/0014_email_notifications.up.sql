@@ -0,0 +1,22 @@
+-- Like `fullname`, emails are expected to be provided by whatever manages users
+ALTER TABLE users ADD COLUMN email VARCHAR(255);
+
+-- The users who do not want to be notified by email
+CREATE TABLE email_opt_outs (
+  user_id     INTEGER                   PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  created_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now()
+);
+
+CREATE TABLE email_notifications (
+  id               BIGSERIAL                 PRIMARY KEY,
+  user_id          INTEGER                   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  convo_id         INTEGER                   NOT NULL,
+  attempts         INTEGER                   NOT NULL DEFAULT 0,
+  next_attempt_at  TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  created_at       TIMESTAMP WITH TIME ZONE  NOT NULL DEFAULT now(),
+  sent_at          TIMESTAMP WITH TIME ZONE
+);
+
+-- There is no foreign key to `convos`: the notifications of deleted convos are dropped before they are sent
+CREATE INDEX email_notifications_pending_idx ON email_notifications (user_id) WHERE sent_at IS NULL;
+CREATE INDEX email_notifications_sent_at_idx ON email_notifications (user_id, sent_at);
@@ -0,0 +1,7 @@
+DROP TABLE event_positions;
+
+DROP INDEX convo_events_unpositioned_idx;
+DROP INDEX convo_events_user_id_position_idx;
+CREATE INDEX convo_events_user_id_visible_at_idx ON convo_events (user_id, visible_at, id);
+
+ALTER TABLE convo_events DROP COLUMN position;
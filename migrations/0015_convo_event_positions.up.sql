@@ -0,0 +1,12 @@
+-- Positions are given out when events are read, in the order they became visible, so that an event committed after a
+-- read is never placed before the events that read returned
+ALTER TABLE convo_events ADD COLUMN position BIGINT;
+
+DROP INDEX convo_events_user_id_visible_at_idx;
+CREATE UNIQUE INDEX convo_events_user_id_position_idx ON convo_events (user_id, position);
+CREATE INDEX convo_events_unpositioned_idx ON convo_events (user_id, visible_at, id) WHERE position IS NULL;
+
+CREATE TABLE event_positions (
+  user_id   INTEGER  PRIMARY KEY  REFERENCES users(id) ON DELETE CASCADE,
+  position  BIGINT   NOT NULL DEFAULT 0
+);
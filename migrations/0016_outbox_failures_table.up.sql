@@ -0,0 +1,12 @@
+-- An event is parked once a subscriber has failed to handle it too many times, and its payload is kept here after
+-- the event itself has been purged
+CREATE TABLE outbox_failures (
+  subscriber       VARCHAR(64)               NOT NULL,
+  position         BIGINT                    NOT NULL,
+  payload          TEXT                      NOT NULL,
+  attempts         INTEGER                   NOT NULL,
+  last_error       TEXT                      NOT NULL,
+  next_attempt_at  TIMESTAMP WITH TIME ZONE,
+  parked_at        TIMESTAMP WITH TIME ZONE,
+  PRIMARY KEY (subscriber, position)
+);
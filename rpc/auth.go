@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The metadata key holding the id of the user, like the `X-USER-API-KEY` header of the HTTP API
+const userKeyMetadata = "x-user-api-key"
+
+type userIdKey struct{}
+
+// authenticate adds the user of a call to its context. Like the HTTP API, it only checks that a user id was given.
+func authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(userKeyMetadata)
+	if len(keys) == 0 || keys[0] == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "The `%s` metadata is required.", userKeyMetadata)
+	}
+
+	// The key is the id of the user
+	if _, err := strconv.ParseInt(keys[0], 10, 32); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid `%s` metadata.", userKeyMetadata)
+	}
+
+	return context.WithValue(ctx, userIdKey{}, keys[0]), nil
+}
+
+func userIdFrom(ctx context.Context) string {
+	return ctx.Value(userIdKey{}).(string)
+}
+
+func authorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func authorizeStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ss, ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/rpc/convospb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toProto(c *db.Convo) *convospb.Convo {
+	convo := &convospb.Convo{
+		Id:        int64(c.Id),
+		Parent:    int64(c.Parent),
+		Sender:    int64(c.Sender),
+		Recipient: int64(c.Recipient),
+		Subject:   c.Subject,
+		Body:      c.Body,
+		Read:      c.Read,
+		Archived:  c.Archived,
+		EditedAt:  toTimestamp(c.EditedAt),
+		UndoUntil: toTimestamp(c.UndoUntil),
+	}
+
+	for _, child := range c.Children {
+		convo.Replies = append(convo.Replies, toProto(child))
+	}
+
+	for _, a := range c.Attachments {
+		convo.Attachments = append(convo.Attachments, &convospb.Attachment{
+			Id: int64(a.Id), Filename: a.Filename, ContentType: a.ContentType, Size: a.Size,
+		})
+	}
+
+	return convo
+}
+
+// toProtoWithETag converts a convo along with its ETag, for the methods which return a convo that can be changed next
+func toProtoWithETag(c *db.Convo) *convospb.Convo {
+	convo := toProto(c)
+	convo.Etag = c.ETag()
+	return convo
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
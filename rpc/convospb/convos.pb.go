@@ -0,0 +1,834 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: convos.proto
+
+package convospb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Convo struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Parent    int64                  `protobuf:"varint,2,opt,name=parent,proto3" json:"parent,omitempty"`
+	Sender    int64                  `protobuf:"varint,3,opt,name=sender,proto3" json:"sender,omitempty"`
+	Recipient int64                  `protobuf:"varint,4,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Subject   string                 `protobuf:"bytes,5,opt,name=subject,proto3" json:"subject,omitempty"`
+	Body      string                 `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	Read      bool                   `protobuf:"varint,7,opt,name=read,proto3" json:"read,omitempty"`
+	Archived  bool                   `protobuf:"varint,8,opt,name=archived,proto3" json:"archived,omitempty"`
+	EditedAt  *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=edited_at,json=editedAt,proto3" json:"edited_at,omitempty"`
+	// Only set for the sender, while a newly created convo can still be undone
+	UndoUntil   *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=undo_until,json=undoUntil,proto3" json:"undo_until,omitempty"`
+	Replies     []*Convo               `protobuf:"bytes,11,rep,name=replies,proto3" json:"replies,omitempty"`
+	Attachments []*Attachment          `protobuf:"bytes,12,rep,name=attachments,proto3" json:"attachments,omitempty"`
+	// Only set by GetConvo and UpdateConvo; the same as the ETag header of the HTTP API
+	Etag          string `protobuf:"bytes,13,opt,name=etag,proto3" json:"etag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Convo) Reset() {
+	*x = Convo{}
+	mi := &file_convos_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Convo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Convo) ProtoMessage() {}
+
+func (x *Convo) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Convo.ProtoReflect.Descriptor instead.
+func (*Convo) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Convo) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Convo) GetParent() int64 {
+	if x != nil {
+		return x.Parent
+	}
+	return 0
+}
+
+func (x *Convo) GetSender() int64 {
+	if x != nil {
+		return x.Sender
+	}
+	return 0
+}
+
+func (x *Convo) GetRecipient() int64 {
+	if x != nil {
+		return x.Recipient
+	}
+	return 0
+}
+
+func (x *Convo) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *Convo) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+func (x *Convo) GetRead() bool {
+	if x != nil {
+		return x.Read
+	}
+	return false
+}
+
+func (x *Convo) GetArchived() bool {
+	if x != nil {
+		return x.Archived
+	}
+	return false
+}
+
+func (x *Convo) GetEditedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.EditedAt
+	}
+	return nil
+}
+
+func (x *Convo) GetUndoUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UndoUntil
+	}
+	return nil
+}
+
+func (x *Convo) GetReplies() []*Convo {
+	if x != nil {
+		return x.Replies
+	}
+	return nil
+}
+
+func (x *Convo) GetAttachments() []*Attachment {
+	if x != nil {
+		return x.Attachments
+	}
+	return nil
+}
+
+func (x *Convo) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+type Attachment struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Filename      string                 `protobuf:"bytes,2,opt,name=filename,proto3" json:"filename,omitempty"`
+	ContentType   string                 `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Size          int64                  `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Attachment) Reset() {
+	*x = Attachment{}
+	mi := &file_convos_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Attachment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attachment) ProtoMessage() {}
+
+func (x *Attachment) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attachment.ProtoReflect.Descriptor instead.
+func (*Attachment) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Attachment) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Attachment) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *Attachment) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Attachment) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+type ListConvosRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only return archived threads
+	Archived      bool `protobuf:"varint,1,opt,name=archived,proto3" json:"archived,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConvosRequest) Reset() {
+	*x = ListConvosRequest{}
+	mi := &file_convos_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConvosRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConvosRequest) ProtoMessage() {}
+
+func (x *ListConvosRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConvosRequest.ProtoReflect.Descriptor instead.
+func (*ListConvosRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListConvosRequest) GetArchived() bool {
+	if x != nil {
+		return x.Archived
+	}
+	return false
+}
+
+type ListConvosResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Convos        []*Convo               `protobuf:"bytes,1,rep,name=convos,proto3" json:"convos,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConvosResponse) Reset() {
+	*x = ListConvosResponse{}
+	mi := &file_convos_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConvosResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConvosResponse) ProtoMessage() {}
+
+func (x *ListConvosResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConvosResponse.ProtoReflect.Descriptor instead.
+func (*ListConvosResponse) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListConvosResponse) GetConvos() []*Convo {
+	if x != nil {
+		return x.Convos
+	}
+	return nil
+}
+
+type GetConvoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetConvoRequest) Reset() {
+	*x = GetConvoRequest{}
+	mi := &file_convos_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetConvoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetConvoRequest) ProtoMessage() {}
+
+func (x *GetConvoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetConvoRequest.ProtoReflect.Descriptor instead.
+func (*GetConvoRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetConvoRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type CreateConvoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Recipient     int64                  `protobuf:"varint,1,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Subject       string                 `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	Body          string                 `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateConvoRequest) Reset() {
+	*x = CreateConvoRequest{}
+	mi := &file_convos_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateConvoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateConvoRequest) ProtoMessage() {}
+
+func (x *CreateConvoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateConvoRequest.ProtoReflect.Descriptor instead.
+func (*CreateConvoRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CreateConvoRequest) GetRecipient() int64 {
+	if x != nil {
+		return x.Recipient
+	}
+	return 0
+}
+
+func (x *CreateConvoRequest) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *CreateConvoRequest) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+type ReplyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Body          string                 `protobuf:"bytes,2,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplyRequest) Reset() {
+	*x = ReplyRequest{}
+	mi := &file_convos_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplyRequest) ProtoMessage() {}
+
+func (x *ReplyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplyRequest.ProtoReflect.Descriptor instead.
+func (*ReplyRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ReplyRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ReplyRequest) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+type UpdateConvoRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// ETag of the version of the convo the changes are based on, or "*" to skip the check
+	Etag          string  `protobuf:"bytes,2,opt,name=etag,proto3" json:"etag,omitempty"`
+	Read          *bool   `protobuf:"varint,3,opt,name=read,proto3,oneof" json:"read,omitempty"`
+	Archived      *bool   `protobuf:"varint,4,opt,name=archived,proto3,oneof" json:"archived,omitempty"`
+	Subject       *string `protobuf:"bytes,5,opt,name=subject,proto3,oneof" json:"subject,omitempty"`
+	Body          *string `protobuf:"bytes,6,opt,name=body,proto3,oneof" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateConvoRequest) Reset() {
+	*x = UpdateConvoRequest{}
+	mi := &file_convos_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateConvoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateConvoRequest) ProtoMessage() {}
+
+func (x *UpdateConvoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateConvoRequest.ProtoReflect.Descriptor instead.
+func (*UpdateConvoRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *UpdateConvoRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateConvoRequest) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *UpdateConvoRequest) GetRead() bool {
+	if x != nil && x.Read != nil {
+		return *x.Read
+	}
+	return false
+}
+
+func (x *UpdateConvoRequest) GetArchived() bool {
+	if x != nil && x.Archived != nil {
+		return *x.Archived
+	}
+	return false
+}
+
+func (x *UpdateConvoRequest) GetSubject() string {
+	if x != nil && x.Subject != nil {
+		return *x.Subject
+	}
+	return ""
+}
+
+func (x *UpdateConvoRequest) GetBody() string {
+	if x != nil && x.Body != nil {
+		return *x.Body
+	}
+	return ""
+}
+
+type DeleteConvoRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Works as it does for UpdateConvo
+	Etag          string `protobuf:"bytes,2,opt,name=etag,proto3" json:"etag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteConvoRequest) Reset() {
+	*x = DeleteConvoRequest{}
+	mi := &file_convos_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteConvoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteConvoRequest) ProtoMessage() {}
+
+func (x *DeleteConvoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteConvoRequest.ProtoReflect.Descriptor instead.
+func (*DeleteConvoRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeleteConvoRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DeleteConvoRequest) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+type DeleteConvoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteConvoResponse) Reset() {
+	*x = DeleteConvoResponse{}
+	mi := &file_convos_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteConvoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteConvoResponse) ProtoMessage() {}
+
+func (x *DeleteConvoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteConvoResponse.ProtoReflect.Descriptor instead.
+func (*DeleteConvoResponse) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{9}
+}
+
+type WatchConvosRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchConvosRequest) Reset() {
+	*x = WatchConvosRequest{}
+	mi := &file_convos_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchConvosRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchConvosRequest) ProtoMessage() {}
+
+func (x *WatchConvosRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_convos_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchConvosRequest.ProtoReflect.Descriptor instead.
+func (*WatchConvosRequest) Descriptor() ([]byte, []int) {
+	return file_convos_proto_rawDescGZIP(), []int{10}
+}
+
+var File_convos_proto protoreflect.FileDescriptor
+
+const file_convos_proto_rawDesc = "" +
+	"\n" +
+	"\fconvos.proto\x12\tconvos.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb0\x03\n" +
+	"\x05Convo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
+	"\x06parent\x18\x02 \x01(\x03R\x06parent\x12\x16\n" +
+	"\x06sender\x18\x03 \x01(\x03R\x06sender\x12\x1c\n" +
+	"\trecipient\x18\x04 \x01(\x03R\trecipient\x12\x18\n" +
+	"\asubject\x18\x05 \x01(\tR\asubject\x12\x12\n" +
+	"\x04body\x18\x06 \x01(\tR\x04body\x12\x12\n" +
+	"\x04read\x18\a \x01(\bR\x04read\x12\x1a\n" +
+	"\barchived\x18\b \x01(\bR\barchived\x127\n" +
+	"\tedited_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\beditedAt\x129\n" +
+	"\n" +
+	"undo_until\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\tundoUntil\x12*\n" +
+	"\areplies\x18\v \x03(\v2\x10.convos.v1.ConvoR\areplies\x127\n" +
+	"\vattachments\x18\f \x03(\v2\x15.convos.v1.AttachmentR\vattachments\x12\x12\n" +
+	"\x04etag\x18\r \x01(\tR\x04etag\"o\n" +
+	"\n" +
+	"Attachment\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1a\n" +
+	"\bfilename\x18\x02 \x01(\tR\bfilename\x12!\n" +
+	"\fcontent_type\x18\x03 \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04size\x18\x04 \x01(\x03R\x04size\"/\n" +
+	"\x11ListConvosRequest\x12\x1a\n" +
+	"\barchived\x18\x01 \x01(\bR\barchived\">\n" +
+	"\x12ListConvosResponse\x12(\n" +
+	"\x06convos\x18\x01 \x03(\v2\x10.convos.v1.ConvoR\x06convos\"!\n" +
+	"\x0fGetConvoRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"`\n" +
+	"\x12CreateConvoRequest\x12\x1c\n" +
+	"\trecipient\x18\x01 \x01(\x03R\trecipient\x12\x18\n" +
+	"\asubject\x18\x02 \x01(\tR\asubject\x12\x12\n" +
+	"\x04body\x18\x03 \x01(\tR\x04body\"2\n" +
+	"\fReplyRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04body\x18\x02 \x01(\tR\x04body\"\xd5\x01\n" +
+	"\x12UpdateConvoRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04etag\x18\x02 \x01(\tR\x04etag\x12\x17\n" +
+	"\x04read\x18\x03 \x01(\bH\x00R\x04read\x88\x01\x01\x12\x1f\n" +
+	"\barchived\x18\x04 \x01(\bH\x01R\barchived\x88\x01\x01\x12\x1d\n" +
+	"\asubject\x18\x05 \x01(\tH\x02R\asubject\x88\x01\x01\x12\x17\n" +
+	"\x04body\x18\x06 \x01(\tH\x03R\x04body\x88\x01\x01B\a\n" +
+	"\x05_readB\v\n" +
+	"\t_archivedB\n" +
+	"\n" +
+	"\b_subjectB\a\n" +
+	"\x05_body\"8\n" +
+	"\x12DeleteConvoRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04etag\x18\x02 \x01(\tR\x04etag\"\x15\n" +
+	"\x13DeleteConvoResponse\"\x14\n" +
+	"\x12WatchConvosRequest2\xd1\x03\n" +
+	"\x06Convos\x12I\n" +
+	"\n" +
+	"ListConvos\x12\x1c.convos.v1.ListConvosRequest\x1a\x1d.convos.v1.ListConvosResponse\x128\n" +
+	"\bGetConvo\x12\x1a.convos.v1.GetConvoRequest\x1a\x10.convos.v1.Convo\x12>\n" +
+	"\vCreateConvo\x12\x1d.convos.v1.CreateConvoRequest\x1a\x10.convos.v1.Convo\x122\n" +
+	"\x05Reply\x12\x17.convos.v1.ReplyRequest\x1a\x10.convos.v1.Convo\x12>\n" +
+	"\vUpdateConvo\x12\x1d.convos.v1.UpdateConvoRequest\x1a\x10.convos.v1.Convo\x12L\n" +
+	"\vDeleteConvo\x12\x1d.convos.v1.DeleteConvoRequest\x1a\x1e.convos.v1.DeleteConvoResponse\x12@\n" +
+	"\vWatchConvos\x12\x1d.convos.v1.WatchConvosRequest\x1a\x10.convos.v1.Convo0\x01B&Z$github.com/nt3rp/convos/rpc/convospbb\x06proto3"
+
+var (
+	file_convos_proto_rawDescOnce sync.Once
+	file_convos_proto_rawDescData []byte
+)
+
+func file_convos_proto_rawDescGZIP() []byte {
+	file_convos_proto_rawDescOnce.Do(func() {
+		file_convos_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_convos_proto_rawDesc), len(file_convos_proto_rawDesc)))
+	})
+	return file_convos_proto_rawDescData
+}
+
+var file_convos_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_convos_proto_goTypes = []any{
+	(*Convo)(nil),                 // 0: convos.v1.Convo
+	(*Attachment)(nil),            // 1: convos.v1.Attachment
+	(*ListConvosRequest)(nil),     // 2: convos.v1.ListConvosRequest
+	(*ListConvosResponse)(nil),    // 3: convos.v1.ListConvosResponse
+	(*GetConvoRequest)(nil),       // 4: convos.v1.GetConvoRequest
+	(*CreateConvoRequest)(nil),    // 5: convos.v1.CreateConvoRequest
+	(*ReplyRequest)(nil),          // 6: convos.v1.ReplyRequest
+	(*UpdateConvoRequest)(nil),    // 7: convos.v1.UpdateConvoRequest
+	(*DeleteConvoRequest)(nil),    // 8: convos.v1.DeleteConvoRequest
+	(*DeleteConvoResponse)(nil),   // 9: convos.v1.DeleteConvoResponse
+	(*WatchConvosRequest)(nil),    // 10: convos.v1.WatchConvosRequest
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+}
+var file_convos_proto_depIdxs = []int32{
+	11, // 0: convos.v1.Convo.edited_at:type_name -> google.protobuf.Timestamp
+	11, // 1: convos.v1.Convo.undo_until:type_name -> google.protobuf.Timestamp
+	0,  // 2: convos.v1.Convo.replies:type_name -> convos.v1.Convo
+	1,  // 3: convos.v1.Convo.attachments:type_name -> convos.v1.Attachment
+	0,  // 4: convos.v1.ListConvosResponse.convos:type_name -> convos.v1.Convo
+	2,  // 5: convos.v1.Convos.ListConvos:input_type -> convos.v1.ListConvosRequest
+	4,  // 6: convos.v1.Convos.GetConvo:input_type -> convos.v1.GetConvoRequest
+	5,  // 7: convos.v1.Convos.CreateConvo:input_type -> convos.v1.CreateConvoRequest
+	6,  // 8: convos.v1.Convos.Reply:input_type -> convos.v1.ReplyRequest
+	7,  // 9: convos.v1.Convos.UpdateConvo:input_type -> convos.v1.UpdateConvoRequest
+	8,  // 10: convos.v1.Convos.DeleteConvo:input_type -> convos.v1.DeleteConvoRequest
+	10, // 11: convos.v1.Convos.WatchConvos:input_type -> convos.v1.WatchConvosRequest
+	3,  // 12: convos.v1.Convos.ListConvos:output_type -> convos.v1.ListConvosResponse
+	0,  // 13: convos.v1.Convos.GetConvo:output_type -> convos.v1.Convo
+	0,  // 14: convos.v1.Convos.CreateConvo:output_type -> convos.v1.Convo
+	0,  // 15: convos.v1.Convos.Reply:output_type -> convos.v1.Convo
+	0,  // 16: convos.v1.Convos.UpdateConvo:output_type -> convos.v1.Convo
+	9,  // 17: convos.v1.Convos.DeleteConvo:output_type -> convos.v1.DeleteConvoResponse
+	0,  // 18: convos.v1.Convos.WatchConvos:output_type -> convos.v1.Convo
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_convos_proto_init() }
+func file_convos_proto_init() {
+	if File_convos_proto != nil {
+		return
+	}
+	file_convos_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_convos_proto_rawDesc), len(file_convos_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_convos_proto_goTypes,
+		DependencyIndexes: file_convos_proto_depIdxs,
+		MessageInfos:      file_convos_proto_msgTypes,
+	}.Build()
+	File_convos_proto = out.File
+	file_convos_proto_goTypes = nil
+	file_convos_proto_depIdxs = nil
+}
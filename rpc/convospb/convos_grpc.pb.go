@@ -0,0 +1,371 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: convos.proto
+
+package convospb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Convos_ListConvos_FullMethodName  = "/convos.v1.Convos/ListConvos"
+	Convos_GetConvo_FullMethodName    = "/convos.v1.Convos/GetConvo"
+	Convos_CreateConvo_FullMethodName = "/convos.v1.Convos/CreateConvo"
+	Convos_Reply_FullMethodName       = "/convos.v1.Convos/Reply"
+	Convos_UpdateConvo_FullMethodName = "/convos.v1.Convos/UpdateConvo"
+	Convos_DeleteConvo_FullMethodName = "/convos.v1.Convos/DeleteConvo"
+	Convos_WatchConvos_FullMethodName = "/convos.v1.Convos/WatchConvos"
+)
+
+// ConvosClient is the client API for Convos service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Convos is the gRPC interface of the convos API, for internal callers.
+// Every call must carry the id of the user it is made for in the `x-user-api-key` metadata, and can only see the
+// convos that user can see through the HTTP API.
+type ConvosClient interface {
+	// ListConvos returns the threads of the user, newest first
+	ListConvos(ctx context.Context, in *ListConvosRequest, opts ...grpc.CallOption) (*ListConvosResponse, error)
+	// GetConvo returns a convo with all of its replies
+	GetConvo(ctx context.Context, in *GetConvoRequest, opts ...grpc.CallOption) (*Convo, error)
+	CreateConvo(ctx context.Context, in *CreateConvoRequest, opts ...grpc.CallOption) (*Convo, error)
+	// Reply replies to whoever is on the other end of a convo
+	Reply(ctx context.Context, in *ReplyRequest, opts ...grpc.CallOption) (*Convo, error)
+	UpdateConvo(ctx context.Context, in *UpdateConvoRequest, opts ...grpc.CallOption) (*Convo, error)
+	// DeleteConvo deletes a convo and its replies
+	DeleteConvo(ctx context.Context, in *DeleteConvoRequest, opts ...grpc.CallOption) (*DeleteConvoResponse, error)
+	// WatchConvos sends every convo the user receives from now on, until the call is cancelled
+	WatchConvos(ctx context.Context, in *WatchConvosRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Convo], error)
+}
+
+type convosClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConvosClient(cc grpc.ClientConnInterface) ConvosClient {
+	return &convosClient{cc}
+}
+
+func (c *convosClient) ListConvos(ctx context.Context, in *ListConvosRequest, opts ...grpc.CallOption) (*ListConvosResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListConvosResponse)
+	err := c.cc.Invoke(ctx, Convos_ListConvos_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) GetConvo(ctx context.Context, in *GetConvoRequest, opts ...grpc.CallOption) (*Convo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Convo)
+	err := c.cc.Invoke(ctx, Convos_GetConvo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) CreateConvo(ctx context.Context, in *CreateConvoRequest, opts ...grpc.CallOption) (*Convo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Convo)
+	err := c.cc.Invoke(ctx, Convos_CreateConvo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) Reply(ctx context.Context, in *ReplyRequest, opts ...grpc.CallOption) (*Convo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Convo)
+	err := c.cc.Invoke(ctx, Convos_Reply_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) UpdateConvo(ctx context.Context, in *UpdateConvoRequest, opts ...grpc.CallOption) (*Convo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Convo)
+	err := c.cc.Invoke(ctx, Convos_UpdateConvo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) DeleteConvo(ctx context.Context, in *DeleteConvoRequest, opts ...grpc.CallOption) (*DeleteConvoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteConvoResponse)
+	err := c.cc.Invoke(ctx, Convos_DeleteConvo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convosClient) WatchConvos(ctx context.Context, in *WatchConvosRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Convo], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Convos_ServiceDesc.Streams[0], Convos_WatchConvos_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchConvosRequest, Convo]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Convos_WatchConvosClient = grpc.ServerStreamingClient[Convo]
+
+// ConvosServer is the server API for Convos service.
+// All implementations must embed UnimplementedConvosServer
+// for forward compatibility.
+//
+// Convos is the gRPC interface of the convos API, for internal callers.
+// Every call must carry the id of the user it is made for in the `x-user-api-key` metadata, and can only see the
+// convos that user can see through the HTTP API.
+type ConvosServer interface {
+	// ListConvos returns the threads of the user, newest first
+	ListConvos(context.Context, *ListConvosRequest) (*ListConvosResponse, error)
+	// GetConvo returns a convo with all of its replies
+	GetConvo(context.Context, *GetConvoRequest) (*Convo, error)
+	CreateConvo(context.Context, *CreateConvoRequest) (*Convo, error)
+	// Reply replies to whoever is on the other end of a convo
+	Reply(context.Context, *ReplyRequest) (*Convo, error)
+	UpdateConvo(context.Context, *UpdateConvoRequest) (*Convo, error)
+	// DeleteConvo deletes a convo and its replies
+	DeleteConvo(context.Context, *DeleteConvoRequest) (*DeleteConvoResponse, error)
+	// WatchConvos sends every convo the user receives from now on, until the call is cancelled
+	WatchConvos(*WatchConvosRequest, grpc.ServerStreamingServer[Convo]) error
+	mustEmbedUnimplementedConvosServer()
+}
+
+// UnimplementedConvosServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedConvosServer struct{}
+
+func (UnimplementedConvosServer) ListConvos(context.Context, *ListConvosRequest) (*ListConvosResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConvos not implemented")
+}
+func (UnimplementedConvosServer) GetConvo(context.Context, *GetConvoRequest) (*Convo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConvo not implemented")
+}
+func (UnimplementedConvosServer) CreateConvo(context.Context, *CreateConvoRequest) (*Convo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateConvo not implemented")
+}
+func (UnimplementedConvosServer) Reply(context.Context, *ReplyRequest) (*Convo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reply not implemented")
+}
+func (UnimplementedConvosServer) UpdateConvo(context.Context, *UpdateConvoRequest) (*Convo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConvo not implemented")
+}
+func (UnimplementedConvosServer) DeleteConvo(context.Context, *DeleteConvoRequest) (*DeleteConvoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteConvo not implemented")
+}
+func (UnimplementedConvosServer) WatchConvos(*WatchConvosRequest, grpc.ServerStreamingServer[Convo]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchConvos not implemented")
+}
+func (UnimplementedConvosServer) mustEmbedUnimplementedConvosServer() {}
+func (UnimplementedConvosServer) testEmbeddedByValue()                {}
+
+// UnsafeConvosServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConvosServer will
+// result in compilation errors.
+type UnsafeConvosServer interface {
+	mustEmbedUnimplementedConvosServer()
+}
+
+func RegisterConvosServer(s grpc.ServiceRegistrar, srv ConvosServer) {
+	// If the following call pancis, it indicates UnimplementedConvosServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Convos_ServiceDesc, srv)
+}
+
+func _Convos_ListConvos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConvosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).ListConvos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_ListConvos_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).ListConvos(ctx, req.(*ListConvosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_GetConvo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConvoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).GetConvo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_GetConvo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).GetConvo(ctx, req.(*GetConvoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_CreateConvo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateConvoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).CreateConvo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_CreateConvo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).CreateConvo(ctx, req.(*CreateConvoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_Reply_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).Reply(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_Reply_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).Reply(ctx, req.(*ReplyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_UpdateConvo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateConvoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).UpdateConvo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_UpdateConvo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).UpdateConvo(ctx, req.(*UpdateConvoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_DeleteConvo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteConvoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvosServer).DeleteConvo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Convos_DeleteConvo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvosServer).DeleteConvo(ctx, req.(*DeleteConvoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Convos_WatchConvos_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchConvosRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConvosServer).WatchConvos(m, &grpc.GenericServerStream[WatchConvosRequest, Convo]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Convos_WatchConvosServer = grpc.ServerStreamingServer[Convo]
+
+// Convos_ServiceDesc is the grpc.ServiceDesc for Convos service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Convos_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "convos.v1.Convos",
+	HandlerType: (*ConvosServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListConvos",
+			Handler:    _Convos_ListConvos_Handler,
+		},
+		{
+			MethodName: "GetConvo",
+			Handler:    _Convos_GetConvo_Handler,
+		},
+		{
+			MethodName: "CreateConvo",
+			Handler:    _Convos_CreateConvo_Handler,
+		},
+		{
+			MethodName: "Reply",
+			Handler:    _Convos_Reply_Handler,
+		},
+		{
+			MethodName: "UpdateConvo",
+			Handler:    _Convos_UpdateConvo_Handler,
+		},
+		{
+			MethodName: "DeleteConvo",
+			Handler:    _Convos_DeleteConvo_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchConvos",
+			Handler:       _Convos_WatchConvos_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "convos.proto",
+}
@@ -0,0 +1,4 @@
+// Package convospb holds the protobuf messages and gRPC stubs of the convos service, generated from `convos.proto`.
+package convospb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative convos.proto
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"log"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const internalErrorMessage = "An unexpected error occurred."
+
+// getErrorCode returns the gRPC status code matching the HTTP status the API sends for an error
+func getErrorCode(err error) codes.Code {
+	cause := errgo.Cause(err)
+	if _, ok := cause.(*db.ValidationError); ok {
+		return codes.InvalidArgument
+	}
+
+	switch cause {
+	case db.ErrNoRows:
+		return codes.NotFound
+	case db.ErrForbidden:
+		return codes.PermissionDenied
+	case db.ErrConflict:
+		return codes.Aborted
+	case db.ErrPreconditionFailed:
+		return codes.FailedPrecondition
+	case db.ErrInvalid:
+		return codes.InvalidArgument
+	default:
+		return codes.Internal
+	}
+}
+
+// toStatus converts an error from the `db` package to a gRPC status. As in the HTTP API, the message of internal
+// errors is logged rather than sent.
+func toStatus(err error) error {
+	code := getErrorCode(err)
+	if code == codes.Internal {
+		log.Printf("Internal error: %v\n", err)
+		return status.Error(code, internalErrorMessage)
+	}
+
+	message := err.Error()
+	if e, ok := err.(interface {
+		Message() string
+	}); ok && e.Message() != "" {
+		message = e.Message()
+	}
+
+	st := status.New(code, message)
+
+	// Like the `fields` of the HTTP API's errors, list every invalid field
+	if verr, ok := errgo.Cause(err).(*db.ValidationError); ok {
+		details := &errdetails.BadRequest{}
+		for _, f := range verr.Fields {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field: f.Field, Reason: f.Code, Description: f.Message,
+			})
+		}
+
+		if withDetails, err := st.WithDetails(details); err == nil {
+			st = withDetails
+		}
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,157 @@
+// Package rpc serves the convos API over gRPC, for backend services.
+//
+// It shares the `db` package with the HTTP API, so it has the same authorization rules: a call can only see and
+// change the convos the user in its `x-user-api-key` metadata can see.
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/rpc/convospb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// How often `WatchConvos` looks for new convos without being notified of any, in case a notification was missed
+	WatchInterval time.Duration = 30 * time.Second
+)
+
+type server struct {
+	convospb.UnimplementedConvosServer
+}
+
+// NewServer returns a gRPC server with the convos service registered
+func NewServer() *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(authorizeUnary), grpc.StreamInterceptor(authorizeStream))
+	convospb.RegisterConvosServer(s, &server{})
+	return s
+}
+
+func (s *server) ListConvos(ctx context.Context, req *convospb.ListConvosRequest) (*convospb.ListConvosResponse, error) {
+	convos, err := db.GetConvos(userIdFrom(ctx), req.Archived)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &convospb.ListConvosResponse{}
+	for _, c := range convos {
+		resp.Convos = append(resp.Convos, toProto(c))
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetConvo(ctx context.Context, req *convospb.GetConvoRequest) (*convospb.Convo, error) {
+	convo, err := db.GetConvo(userIdFrom(ctx), idString(req.Id))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProtoWithETag(convo), nil
+}
+
+func (s *server) CreateConvo(ctx context.Context, req *convospb.CreateConvoRequest) (*convospb.Convo, error) {
+	convo, err := db.CreateConvo(userIdFrom(ctx), &db.Convo{
+		Recipient: int(req.Recipient), Subject: req.Subject, Body: req.Body,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(convo), nil
+}
+
+func (s *server) Reply(ctx context.Context, req *convospb.ReplyRequest) (*convospb.Convo, error) {
+	convo, _, err := db.Reply(userIdFrom(ctx), idString(req.Id), &db.Convo{Body: req.Body}, nil)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(convo), nil
+}
+
+func (s *server) UpdateConvo(ctx context.Context, req *convospb.UpdateConvoRequest) (*convospb.Convo, error) {
+	precondition, err := etagPrecondition(req.Etag)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &db.ConvoPatch{Read: req.Read, Archived: req.Archived, Subject: req.Subject, Body: req.Body}
+	convo, err := db.UpdateConvo(userIdFrom(ctx), idString(req.Id), patch, precondition)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProtoWithETag(convo), nil
+}
+
+func (s *server) DeleteConvo(ctx context.Context, req *convospb.DeleteConvoRequest) (*convospb.DeleteConvoResponse, error) {
+	precondition, err := etagPrecondition(req.Etag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.DeleteConvo(userIdFrom(ctx), idString(req.Id), precondition); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &convospb.DeleteConvoResponse{}, nil
+}
+
+func (s *server) WatchConvos(req *convospb.WatchConvosRequest, stream convospb.Convos_WatchConvosServer) error {
+	ctx := stream.Context()
+	userId := userIdFrom(ctx)
+
+	notifications, unsubscribe := db.SubscribeEvents(userId)
+	defer unsubscribe()
+
+	// Only convos received from now on are sent
+	cursor, err := db.CurrentEventCursor(userId)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notifications:
+		case <-ticker.C:
+		}
+
+		convos, latest, err := db.GetReceivedConvos(userId, cursor)
+		if err != nil {
+			return toStatus(err)
+		}
+		cursor = latest
+
+		for _, c := range convos {
+			if err := stream.Send(toProto(c)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// etagPrecondition works like the `If-Match` header of the HTTP API, which is required to change a convo
+func etagPrecondition(etag string) (db.Precondition, error) {
+	if etag == "" {
+		return nil, status.Error(codes.FailedPrecondition, "The etag is required.")
+	}
+
+	return func(current string) bool {
+		return etag == "*" || db.OwnETag(etag) == current
+	}, nil
+}
+
+func idString(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
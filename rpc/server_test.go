@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/nt3rp/convos/db"
+	"github.com/nt3rp/convos/rpc/convospb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/* Utilities */
+
+// newTestClient serves the service in memory, returning a client for it
+func newTestClient(t *testing.T) convospb.ConvosClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return convospb.NewConvosClient(conn)
+}
+
+func asUser(userId string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), userKeyMetadata, userId)
+}
+
+func setupServerTest(t *testing.T) {
+	db.Initialize("test_convos")
+
+	// In case we had paniced previously
+	tearDownServerTest(t)
+
+	for id, name := range map[string]string{"1": "Alice", "2": "Bob", "3": "Carol"} {
+		if err := db.AddUser(id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tearDownServerTest(t *testing.T) {
+	if err := db.TruncateAllTables(); err != nil {
+		t.Fatalf("Truncate tables: %s\n", err)
+	}
+}
+
+/* Tests */
+
+func Test_Unauthenticated(t *testing.T) {
+	client := newTestClient(t)
+
+	_, err := client.ListConvos(context.Background(), &convospb.ListConvosRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Wrong code. Expected: %v. Actual: %v", codes.Unauthenticated, status.Code(err))
+	}
+
+	stream, err := client.WatchConvos(context.Background(), &convospb.WatchConvosRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Wrong code for stream. Expected: %v. Actual: %v", codes.Unauthenticated, status.Code(err))
+	}
+}
+
+func Test_UpdateConvo_EtagRequired(t *testing.T) {
+	client := newTestClient(t)
+
+	_, err := client.UpdateConvo(asUser("1"), &convospb.UpdateConvoRequest{Id: 1})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Wrong code. Expected: %v. Actual: %v", codes.FailedPrecondition, status.Code(err))
+	}
+}
+
+func Test_ToStatus(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{errgo.WithCausef(nil, db.ErrNoRows, "Unable to find convo with id '1'."), codes.NotFound, "Unable to find convo with id '1'."},
+		{errgo.WithCausef(nil, db.ErrPreconditionFailed, "Changed."), codes.FailedPrecondition, "Changed."},
+		{errgo.WithCausef(nil, db.ErrConflict, "Conflict."), codes.Aborted, "Conflict."},
+		{errgo.WithCausef(nil, db.ErrRowScan, "Error Scanning Row"), codes.Internal, internalErrorMessage},
+	}
+
+	for _, test := range tests {
+		st := status.Convert(toStatus(test.err))
+		if st.Code() != test.code || st.Message() != test.message {
+			t.Errorf("Wrong status for %v. Expected: %v %q. Actual: %v %q", test.err, test.code, test.message, st.Code(), st.Message())
+		}
+	}
+}
+
+func Test_ToStatus_ValidationError(t *testing.T) {
+	verr := &db.ValidationError{Fields: []*db.FieldError{{Field: "subject", Code: "required", Message: "The subject is required."}}}
+
+	st := status.Convert(toStatus(verr))
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("Wrong code. Expected: %v. Actual: %v", codes.InvalidArgument, st.Code())
+	}
+
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("Wrong number of details. Expected: %v. Actual: %v", 1, len(details))
+	}
+
+	violations := details[0].(*errdetails.BadRequest).FieldViolations
+	if len(violations) != 1 || violations[0].Field != "subject" || violations[0].Reason != "required" {
+		t.Errorf("Wrong field violations: %v", violations)
+	}
+}
+
+func Test_GetConvo_OtherUser(t *testing.T) {
+	setupServerTest(t)
+	defer tearDownServerTest(t)
+
+	client := newTestClient(t)
+
+	convo, err := client.CreateConvo(asUser("1"), &convospb.CreateConvoRequest{Recipient: 2, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.GetConvo(asUser("1"), &convospb.GetConvoRequest{Id: convo.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Subject != "First Post" || got.Etag == "" {
+		t.Errorf("Wrong convo returned: %v", got)
+	}
+
+	// Carol is neither the sender nor the recipient
+	_, err = client.GetConvo(asUser("3"), &convospb.GetConvoRequest{Id: convo.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Wrong code. Expected: %v. Actual: %v", codes.NotFound, status.Code(err))
+	}
+
+	_, err = client.DeleteConvo(asUser("3"), &convospb.DeleteConvoRequest{Id: convo.Id, Etag: "*"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Wrong code. Expected: %v. Actual: %v", codes.NotFound, status.Code(err))
+	}
+
+	// The convo is still there for its sender
+	if _, err := client.GetConvo(asUser("1"), &convospb.GetConvoRequest{Id: convo.Id}); err != nil {
+		t.Errorf("Convo was deleted by another user: %v", err)
+	}
+}
+
+func Test_WatchConvos(t *testing.T) {
+	setupServerTest(t)
+	defer tearDownServerTest(t)
+
+	defer func(interval time.Duration) { WatchInterval = interval }(WatchInterval)
+	WatchInterval = 10 * time.Millisecond
+	client := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(asUser("2"))
+	defer cancel()
+
+	stream, err := client.WatchConvos(ctx, &convospb.WatchConvosRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only convos received after the call started are sent
+	time.Sleep(2 * WatchInterval)
+
+	convo, err := db.CreateConvo("1", &db.Convo{Recipient: 2, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Id != int64(convo.Id) {
+		t.Errorf("Wrong convo received. Expected: %v. Actual: %v", convo.Id, received.Id)
+	}
+}
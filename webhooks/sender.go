@@ -0,0 +1,215 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+// Deliveries are queued by the db package and sent from here, by every server instance: each delivery is claimed by
+// a single instance at a time (see `db.ClaimWebhookDeliveries`).
+//
+// Payloads are signed with the webhook's secret, so that receivers can check they come from us:
+//
+//	X-Convos-Signature: t=<unix time>,v1=<hex-encoded HMAC-SHA256 of "<unix time>.<payload>">
+//
+// Including the time lets receivers reject old payloads being replayed.
+
+const (
+	SignatureHeader = "X-Convos-Signature"
+	EventHeader     = "X-Convos-Event"
+	DeliveryHeader  = "X-Convos-Delivery"
+
+	// How many due deliveries are claimed at once
+	claimBatchSize = 20
+)
+
+var (
+	// How many times a delivery is attempted before it is marked as failed
+	MaxAttempts = 8
+
+	// How long to wait before the first retry, doubled after each failed attempt up to `MaxRetryDelay`
+	RetryDelay    time.Duration = 30 * time.Second
+	MaxRetryDelay time.Duration = time.Hour
+
+	// How long a webhook has to respond
+	Timeout time.Duration = 10 * time.Second
+
+	// Webhooks are checked to be on a public address when they are created, but their host may resolve to another
+	// address since, so each connection is checked again. Redirects are not followed, and proxies are not used, since
+	// the address they lead to could not be checked.
+	Client = &http.Client{
+		Timeout: Timeout,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: Timeout, Control: dialPublic}).DialContext,
+			TLSHandshakeTimeout: Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	errNotPublic = errors.New("The webhook is not on a public address")
+)
+
+// dialPublic refuses connections to addresses which are not public (see `db.IsPublicAddress`)
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !db.IsPublicAddress(net.ParseIP(host)) {
+		return errNotPublic
+	}
+
+	return nil
+}
+
+// Sign returns the signature of a payload sent at `timestamp`, as the `v1` part of the signature header
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a payload, which must have been sent at most `tolerance` ago.
+// It is what receivers written in Go can use.
+func Verify(secret, header string, payload []byte, tolerance time.Duration) bool {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			signature = kv[1]
+		}
+	}
+
+	if timestamp == 0 || time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, payload)))
+}
+
+// Send makes a single attempt at a delivery. It returns the status code of the response, if there was one, and an
+// error unless it was a 2xx.
+func Send(client *http.Client, d *db.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "convos-webhooks")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.Id, 10))
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, Sign(d.Secret, timestamp, d.Payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Read some of the body so that the connection can be reused, without letting the receiver keep us busy
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// retryDelay returns how long to wait before attempting a delivery again, after `attempts` failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := RetryDelay
+	for i := 1; i < attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > MaxRetryDelay {
+		return MaxRetryDelay
+	}
+
+	return delay
+}
+
+// DeliverDue sends every due delivery, and returns how many were delivered
+func DeliverDue() (int, error) {
+	// Claimed deliveries must be sent before another instance may claim them again
+	lease := Timeout + time.Minute
+
+	count := 0
+	for {
+		ds, err := db.ClaimWebhookDeliveries(claimBatchSize, lease)
+		if err != nil {
+			return count, err
+		}
+
+		for _, d := range ds {
+			if deliver(d) {
+				count++
+			}
+		}
+
+		if len(ds) < claimBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// deliver attempts a claimed delivery and records the outcome, returning whether it was delivered
+func deliver(d *db.WebhookDelivery) bool {
+	statusCode, err := Send(Client, d)
+
+	status, attemptErr, retryIn := db.WebhookDelivered, "", time.Duration(0)
+	switch {
+	case err == nil:
+	case d.Attempts >= MaxAttempts:
+		status, attemptErr = db.WebhookFailed, err.Error()
+	default:
+		status, attemptErr, retryIn = db.WebhookPending, err.Error(), retryDelay(d.Attempts)
+	}
+
+	if err := db.RecordWebhookAttempt(d, status, statusCode, attemptErr, retryIn); err != nil {
+		log.Printf("Error recording webhook delivery %d: %v\n", d.Id, err)
+	}
+
+	return status == db.WebhookDelivered
+}
+
+// StartDelivery sends due deliveries every `interval` until the process exits
+func StartDelivery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := DeliverDue(); err != nil {
+				log.Printf("Error delivering webhooks: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,278 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nt3rp/convos/db"
+)
+
+/* Utilities */
+
+// receiver is a local webhook endpoint which records the payloads it receives, and responds with `status`
+type receiver struct {
+	sync.Mutex
+	secret   string
+	status   int
+	payloads []*db.WebhookEvent
+	invalid  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	if !Verify(rc.secret, req.Header.Get(SignatureHeader), body, time.Minute) {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var event *db.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.Type != req.Header.Get(EventHeader) {
+		rc.invalid++
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rc.payloads = append(rc.payloads, event)
+	w.WriteHeader(rc.status)
+}
+
+func setupWebhookTest(t *testing.T) {
+	db.Initialize("test_convos")
+
+	// Receivers are local servers
+	db.AllowPrivateWebhooks = true
+
+	// In case we had paniced previously
+	tearDownWebhookTest(t)
+
+	for id, name := range map[string]string{"1": "Alice", "2": "Bob"} {
+		if err := db.AddUser(id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tearDownWebhookTest(t *testing.T) {
+	db.AllowPrivateWebhooks = false
+
+	if err := db.TruncateAllTables(); err != nil {
+		t.Fatalf("Truncate tables: %s\n", err)
+	}
+}
+
+/* Tests */
+
+func Test_SignAndVerify(t *testing.T) {
+	payload := []byte(`{"type":"convo.created"}`)
+	now := time.Now().Unix()
+	header := "t=" + strconv.FormatInt(now, 10) + ",v1=" + Sign("a secret of some length", now, payload)
+
+	if !Verify("a secret of some length", header, payload, time.Minute) {
+		t.Errorf("Valid signature was rejected: %s", header)
+	}
+
+	if Verify("another secret entirely", header, payload, time.Minute) {
+		t.Errorf("Signature with the wrong secret was accepted")
+	}
+
+	if Verify("a secret of some length", header, []byte(`{"type":"convo.deleted"}`), time.Minute) {
+		t.Errorf("Signature of another payload was accepted")
+	}
+
+	old := now - 3600
+	replayed := "t=" + strconv.FormatInt(old, 10) + ",v1=" + Sign("a secret of some length", old, payload)
+	if Verify("a secret of some length", replayed, payload, time.Minute) {
+		t.Errorf("Old signature was accepted")
+	}
+}
+
+func Test_Send(t *testing.T) {
+	rc := &receiver{secret: "a secret of some length", status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d := &db.WebhookDelivery{
+		Id:      7,
+		Event:   db.EventConvoCreated,
+		Payload: json.RawMessage(`{"type":"convo.created","convo":{"id":3}}`),
+		URL:     server.URL,
+		Secret:  rc.secret,
+	}
+
+	status, err := Send(server.Client(), d)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Wrong result. Expected: %v <nil>. Actual: %v %v", http.StatusNoContent, status, err)
+	}
+
+	if len(rc.payloads) != 1 || rc.payloads[0].Convo.Id != 3 {
+		t.Errorf("Wrong payloads received: %+v", rc.payloads)
+	}
+
+	// Anything but a 2xx is a failure
+	rc.status = http.StatusInternalServerError
+	if status, err := Send(server.Client(), d); err == nil || status != http.StatusInternalServerError {
+		t.Errorf("Wrong result. Expected: %v and an error. Actual: %v %v", http.StatusInternalServerError, status, err)
+	}
+}
+
+func Test_Send_NotPublic(t *testing.T) {
+	rc := &receiver{secret: "a secret of some length", status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d := &db.WebhookDelivery{Id: 7, Event: db.EventConvoCreated, Payload: json.RawMessage(`{}`), URL: server.URL, Secret: rc.secret}
+
+	// The receiver is on a loopback address
+	if _, err := Send(Client, d); err == nil || !strings.Contains(err.Error(), errNotPublic.Error()) {
+		t.Errorf("Wrong error. Expected: %v. Actual: %v", errNotPublic, err)
+	}
+
+	if len(rc.payloads) != 0 || rc.invalid != 0 {
+		t.Errorf("Payload was sent to a loopback address")
+	}
+}
+
+func Test_Send_Redirect(t *testing.T) {
+	db.AllowPrivateWebhooks = true
+	defer func() { db.AllowPrivateWebhooks = false }()
+
+	rc := &receiver{secret: "a secret of some length", status: http.StatusNoContent}
+	target := httptest.NewServer(rc)
+	defer target.Close()
+
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	d := &db.WebhookDelivery{Id: 7, Event: db.EventConvoCreated, Payload: json.RawMessage(`{}`), URL: server.URL, Secret: rc.secret}
+
+	// Redirects are failures, rather than followed
+	if status, err := Send(Client, d); err == nil || status != http.StatusTemporaryRedirect {
+		t.Errorf("Wrong result. Expected: %v and an error. Actual: %v %v", http.StatusTemporaryRedirect, status, err)
+	}
+
+	if len(rc.payloads) != 0 || rc.invalid != 0 {
+		t.Errorf("Redirect was followed")
+	}
+}
+
+func Test_RetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  RetryDelay,
+		2:  2 * RetryDelay,
+		3:  4 * RetryDelay,
+		20: MaxRetryDelay,
+	}
+
+	for attempts, expected := range tests {
+		if actual := retryDelay(attempts); actual != expected {
+			t.Errorf("Wrong delay after %d attempts. Expected: %v. Actual: %v", attempts, expected, actual)
+		}
+	}
+}
+
+func Test_DeliverDue(t *testing.T) {
+	setupWebhookTest(t)
+	defer tearDownWebhookTest(t)
+
+	rc := &receiver{secret: "a secret of some length", status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhook, err := db.CreateWebhook("1", &db.Webhook{
+		URL: server.URL, Events: []string{db.EventConvoCreated}, Secret: rc.secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := DeliverDue(); err != nil || count != 1 {
+		t.Fatalf("Wrong result. Expected: 1 <nil>. Actual: %v %v", count, err)
+	}
+
+	if len(rc.payloads) != 1 || rc.payloads[0].Convo.Id != convo.Id || rc.payloads[0].Convo.Subject != "First Post" {
+		t.Errorf("Wrong payloads received: %+v", rc.payloads)
+	}
+
+	deliveries, err := db.GetWebhookDeliveries("1", strconv.Itoa(webhook.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Status != db.WebhookDelivered || deliveries[0].Attempts != 1 {
+		t.Errorf("Wrong deliveries: %+v", deliveries)
+	}
+}
+
+func Test_DeliverDue_DeadLetter(t *testing.T) {
+	setupWebhookTest(t)
+	defer tearDownWebhookTest(t)
+
+	defer func(attempts int, delay time.Duration) { MaxAttempts, RetryDelay = attempts, delay }(MaxAttempts, RetryDelay)
+	MaxAttempts, RetryDelay = 2, 0
+
+	rc := &receiver{secret: "a secret of some length", status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhook, err := db.CreateWebhook("1", &db.Webhook{
+		URL: server.URL, Events: []string{db.EventConvoCreated}, Secret: rc.secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateConvo("2", &db.Convo{Recipient: 1, Subject: "First Post", Body: "Message Body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retried right away, then given up on
+	if count, err := DeliverDue(); err != nil || count != 0 {
+		t.Fatalf("Wrong result. Expected: 0 <nil>. Actual: %v %v", count, err)
+	}
+	if count, err := DeliverDue(); err != nil || count != 0 {
+		t.Fatalf("Wrong result. Expected: 0 <nil>. Actual: %v %v", count, err)
+	}
+
+	deliveries, err := db.GetWebhookDeliveries("1", strconv.Itoa(webhook.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Status != db.WebhookFailed || deliveries[0].Attempts != 2 {
+		t.Fatalf("Wrong deliveries: %+v", deliveries)
+	}
+
+	if code := deliveries[0].LastStatusCode; code == nil || *code != http.StatusServiceUnavailable {
+		t.Errorf("Wrong last status code: %v", code)
+	}
+
+	// Once the receiver is fixed, the delivery can be sent again
+	rc.status = http.StatusOK
+	if _, err := db.RedeliverWebhook("1", strconv.Itoa(webhook.Id), strconv.FormatInt(deliveries[0].Id, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := DeliverDue(); err != nil || count != 1 {
+		t.Errorf("Wrong result. Expected: 1 <nil>. Actual: %v %v", count, err)
+	}
+
+	if len(rc.payloads) != 3 || rc.invalid != 0 {
+		t.Errorf("Wrong payloads received: %d, %d invalid", len(rc.payloads), rc.invalid)
+	}
+}